@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vupdivup/typomat/internal/domain"
+	"github.com/vupdivup/typomat/pkg/alphabet"
+	"github.com/vupdivup/typomat/pkg/layout"
+)
+
+// weakDrillFingers is the number of fingers drilled with --drill weak.
+const weakDrillFingers = 2
+
+// addSourceFlags adds the flags selecting and configuring the sources of
+// practice text to the command.
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("cache", "c", false, "store data for subsequent runs")
+	cmd.Flags().Uint64("seed", 0, "seed prompts to reproduce a session")
+	cmd.Flags().Float64Slice("weights", nil, "relative weights of the paths in prompts")
+	addFilterFlags(cmd)
+	addErrorFlags(cmd)
+	cmd.Flags().Int("commits", 0, "practice on files changed in the last N commits")
+	cmd.Flags().String("base", "", "practice on files changed on the current branch since base")
+	cmd.Flags().String("author", "", "practice on files changed by a matching author")
+	cmd.Flags().Bool("hunks", false, "only use lines added by the selected commits")
+	cmd.Flags().Bool("daily", false, "take today's challenge shared by everyone on the repository")
+	cmd.MarkFlagsMutuallyExclusive("daily", "seed")
+}
+
+// addFilterFlags adds the flags restricting the files of source directories
+// to the command.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("include", nil, "only use files matching glob patterns")
+	cmd.Flags().StringSlice("exclude", nil, "skip files matching glob patterns")
+	cmd.Flags().StringSlice("lang", nil, "only use files of languages, e.g. go,ts")
+	cmd.Flags().StringSlice("alphabet", nil, fmt.Sprintf(
+		"also use words with letters of languages, one of %s",
+		strings.Join(alphabet.Presets(), ",")))
+	cmd.Flags().String("letters", "", "also use words with these letters, e.g. ñç")
+}
+
+// addErrorFlags adds the flags handling files that fail to process to the
+// command.
+func addErrorFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("max-errors", 16, "abort once this many files failed to process")
+	cmd.Flags().Bool("skip-errors", false, "skip files that fail to process instead of aborting")
+	cmd.MarkFlagsMutuallyExclusive("max-errors", "skip-errors")
+}
+
+// addPromptFlags adds the flags selecting the difficulty and style of prompts
+// to the command.
+func addPromptFlags(cmd *cobra.Command) {
+	var names []string
+	for _, d := range domain.Difficulties() {
+		names = append(names, string(d))
+	}
+	cmd.Flags().String("difficulty", string(domain.DifficultyNormal), fmt.Sprintf(
+		"difficulty of prompts, one of %s", strings.Join(names, ",")))
+	cmd.Flags().Bool("markov", false, "string words into sequences as they occur in the code")
+	cmd.MarkFlagsMutuallyExclusive("difficulty", "daily")
+	cmd.MarkFlagsMutuallyExclusive("markov", "daily")
+}
+
+// difficultyOption returns the difficulty of prompts of the command's flags.
+func difficultyOption(cmd *cobra.Command) (domain.Difficulty, error) {
+	name, err := cmd.Flags().GetString("difficulty")
+	if err != nil {
+		return "", err
+	}
+	return domain.ParseDifficulty(name)
+}
+
+// addModeFlag adds the flag selecting how strictly mistakes are handled to
+// the command.
+func addModeFlag(cmd *cobra.Command) {
+	var names []string
+	for _, m := range domain.Modes() {
+		names = append(names, string(m))
+	}
+	cmd.Flags().String("mode", string(domain.ModeNormal), fmt.Sprintf(
+		"how strictly mistakes are handled, one of %s", strings.Join(names, ",")))
+}
+
+// modeOption returns the mode of the command's flags.
+func modeOption(cmd *cobra.Command) (domain.Mode, error) {
+	name, err := cmd.Flags().GetString("mode")
+	if err != nil {
+		return "", err
+	}
+	return domain.ParseMode(name)
+}
+
+// addLayoutFlag adds the flag selecting the keyboard layout to the command.
+func addLayoutFlag(cmd *cobra.Command) {
+	cmd.Flags().String("layout", "qwerty", fmt.Sprintf(
+		"keyboard layout, one of %s, or a layout file",
+		strings.Join(layout.Presets(), ",")))
+}
+
+// layoutOption returns the keyboard layout of the command's flags.
+func layoutOption(cmd *cobra.Command) (*layout.Layout, error) {
+	name, err := cmd.Flags().GetString("layout")
+	if err != nil {
+		return nil, err
+	}
+	return layout.Load(name)
+}
+
+// drillOption returns the finger drill of the command's flags, or nil if the
+// drill flag is not set. The fingers are either named or picked from the key
+// stats recorded by the engine with "weak".
+func drillOption(
+	cmd *cobra.Command, engine *domain.Engine,
+) (*domain.Drill, error) {
+	names, err := cmd.Flags().GetStringSlice("drill")
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	l, err := layoutOption(cmd)
+	if err != nil {
+		return nil, err
+	}
+	drill := &domain.Drill{Layout: l}
+
+	if len(names) == 1 && names[0] == "weak" {
+		stats, err := engine.LoadKeyStats()
+		if err != nil {
+			return nil, err
+		}
+		drill.Fingers = domain.WeakFingers(stats, l, weakDrillFingers)
+		if len(drill.Fingers) == 0 {
+			return nil, errors.New("not enough key stats to find weak fingers, practice without --drill first")
+		}
+		return drill, nil
+	}
+
+	for _, name := range names {
+		finger, err := layout.ParseFinger(name)
+		if err != nil {
+			return nil, err
+		}
+		drill.Fingers = append(drill.Fingers, finger)
+	}
+	return drill, nil
+}
+
+// sourceOptions returns the source options of the command's flags for the
+// specified source paths.
+func sourceOptions(cmd *cobra.Command, args []string) (domain.Options, error) {
+	flags := cmd.Flags()
+
+	// Handle cache flag
+	cache, err := flags.GetBool("cache")
+	if err != nil {
+		return domain.Options{}, err
+	}
+
+	// Handle filter flags
+	filter, err := filterOptions(cmd)
+	if err != nil {
+		return domain.Options{}, err
+	}
+
+	// Handle error flags
+	errOpts, err := errorOptions(cmd)
+	if err != nil {
+		return domain.Options{}, err
+	}
+
+	// Handle history flags
+	history, err := historyOptions(cmd)
+	if err != nil {
+		return domain.Options{}, err
+	}
+
+	// Handle weights flag
+	weights, err := flags.GetFloat64Slice("weights")
+	if err != nil {
+		return domain.Options{}, err
+	}
+	if len(weights) > 0 && len(weights) != len(args) {
+		return domain.Options{}, fmt.Errorf(
+			"expected %d weights, one per path, got %d", len(args), len(weights))
+	}
+
+	// Handle seed flag
+	var seed *uint64
+	if flags.Changed("seed") {
+		value, err := flags.GetUint64("seed")
+		if err != nil {
+			return domain.Options{}, err
+		}
+		seed = &value
+	}
+
+	opts := domain.Options{
+		Cache: cache, Seed: seed, Weights: weights, Filter: filter,
+		Errors: errOpts, History: history,
+	}
+
+	// Handle daily flag
+	daily, err := flags.GetBool("daily")
+	if err != nil {
+		return domain.Options{}, err
+	}
+	if daily {
+		challenge, err := domain.DailyChallenge(args, opts, time.Now())
+		if err != nil {
+			return domain.Options{}, err
+		}
+		opts.Challenge = &challenge
+	}
+
+	return opts, nil
+}
+
+// filterOptions returns the file filter of the command's flags.
+func filterOptions(cmd *cobra.Command) (domain.Filter, error) {
+	flags := cmd.Flags()
+
+	var filter domain.Filter
+	var err error
+	if filter.Include, err = flags.GetStringSlice("include"); err != nil {
+		return filter, err
+	}
+	if filter.Exclude, err = flags.GetStringSlice("exclude"); err != nil {
+		return filter, err
+	}
+	if filter.Langs, err = flags.GetStringSlice("lang"); err != nil {
+		return filter, err
+	}
+
+	// Handle alphabet flags
+	langs, err := flags.GetStringSlice("alphabet")
+	if err != nil {
+		return filter, err
+	}
+	for _, lang := range langs {
+		preset, ok := alphabet.Preset(lang)
+		if !ok {
+			return filter, fmt.Errorf("unknown alphabet %q, expected one of %s",
+				lang, strings.Join(alphabet.Presets(), ", "))
+		}
+		filter.Alphabet = filter.Alphabet.Union(preset)
+	}
+	letters, err := flags.GetString("letters")
+	if err != nil {
+		return filter, err
+	}
+	filter.Alphabet = filter.Alphabet.Union(alphabet.New(letters))
+
+	return filter, nil
+}
+
+// errorOptions returns the handling of failed files of the command's flags.
+func errorOptions(cmd *cobra.Command) (domain.ErrorOptions, error) {
+	flags := cmd.Flags()
+
+	var opts domain.ErrorOptions
+	var err error
+	if opts.MaxErrors, err = flags.GetInt("max-errors"); err != nil {
+		return opts, err
+	}
+	if opts.MaxErrors < 1 {
+		return opts, fmt.Errorf("invalid maximum number of errors: %d", opts.MaxErrors)
+	}
+	if opts.Skip, err = flags.GetBool("skip-errors"); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// historyOptions returns the Git history selection of the command's flags, or
+// nil if no history flag is set.
+func historyOptions(cmd *cobra.Command) (*domain.HistoryOptions, error) {
+	flags := cmd.Flags()
+	if !slices.ContainsFunc(
+		[]string{"commits", "base", "author", "hunks"}, flags.Changed) {
+		return nil, nil
+	}
+
+	opts := &domain.HistoryOptions{}
+	var err error
+	if opts.MaxCommits, err = flags.GetInt("commits"); err != nil {
+		return nil, err
+	}
+	if opts.MaxCommits < 0 {
+		return nil, fmt.Errorf("invalid number of commits: %d", opts.MaxCommits)
+	}
+	if opts.Base, err = flags.GetString("base"); err != nil {
+		return nil, err
+	}
+	if opts.Author, err = flags.GetString("author"); err != nil {
+		return nil, err
+	}
+	if opts.Hunks, err = flags.GetBool("hunks"); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
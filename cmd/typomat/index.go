@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+	"go.uber.org/zap"
+)
+
+// progressInterval is the interval between progress reports of the index
+// command.
+const progressInterval = 100 * time.Millisecond
+
+var indexCmd = &cobra.Command{
+	Use:   "index <dir>...",
+	Short: "Build or refresh the cache of directories",
+	Long: `Build or refresh the cache of each directory ahead of a typing session, as
+if starting one with --cache, and print a summary of the changes.
+
+Progress and files that failed to process are reported on standard error.
+Pass --json for a machine-readable summary. Indexing is aborted once
+--max-errors files failed, unless --skip-errors is passed. Files are
+selected with --include, --exclude and --lang, which must match those of
+later sessions for the cache to be used.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runIndex,
+}
+
+// indexOutput is the JSON representation of an index summary.
+type indexOutput struct {
+	Path       string          `json:"path"`
+	Files      int             `json:"files"`
+	New        int             `json:"new"`
+	Changed    int             `json:"changed"`
+	Unchanged  int             `json:"unchanged"`
+	Ineligible int             `json:"ineligible"`
+	Removed    int             `json:"removed"`
+	Tokens     int             `json:"tokens"`
+	Errors     int             `json:"errors"`
+	Failures   []failureOutput `json:"failures"`
+	Warnings   []failureOutput `json:"warnings"`
+	Elapsed    float64         `json:"elapsed_seconds"`
+}
+
+// failureOutput is the JSON representation of a file that failed to process,
+// or that was processed only in part.
+type failureOutput struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+func runIndex(cmd *cobra.Command, args []string) error {
+	if err := config.Init(); err != nil {
+		zap.S().Error("Failed to initialize configuration", "error", err)
+		return err
+	}
+
+	filter, err := filterOptions(cmd)
+	if err != nil {
+		return err
+	}
+	errOpts, err := errorOptions(cmd)
+	if err != nil {
+		return err
+	}
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+
+	outputs := []indexOutput{}
+	for _, dirPath := range args {
+		summary, err := indexWithProgress(
+			cmd.ErrOrStderr(), dirPath, filter, errOpts)
+		domain.WriteWarningReport(cmd.ErrOrStderr(), summary.Warnings) // nolint:errcheck
+		domain.WriteFailureReport(cmd.ErrOrStderr(), summary.Failures) // nolint:errcheck
+		if err != nil {
+			return err
+		}
+
+		outputs = append(outputs, indexOutput{
+			Path: dirPath, Files: summary.Files, New: summary.New,
+			Changed: summary.Changed, Unchanged: summary.Unchanged,
+			Ineligible: summary.Ineligible, Removed: summary.Removed,
+			Tokens: summary.Tokens, Errors: summary.Errors,
+			Failures: failureOutputs(summary.Failures),
+			Warnings: failureOutputs(summary.Warnings),
+			Elapsed:  summary.Elapsed.Seconds(),
+		})
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(outputs)
+	}
+	return printIndexSummaries(cmd.OutOrStdout(), outputs)
+}
+
+// failureOutputs returns the JSON representations of the files.
+func failureOutputs(failures []domain.FileFailure) []failureOutput {
+	outputs := []failureOutput{}
+	for _, f := range failures {
+		outputs = append(outputs, failureOutput{
+			Path: f.Path, Reason: string(f.Reason), Error: f.Detail,
+		})
+	}
+	return outputs
+}
+
+// indexWithProgress indexes a directory, reporting progress to w if it is a
+// terminal.
+func indexWithProgress(
+	w io.Writer, dirPath string, filter domain.Filter,
+	errOpts domain.ErrorOptions,
+) (domain.IndexSummary, error) {
+	engine := domain.NewEngine()
+	if !isTerminal(w) {
+		summary, err := engine.Index(dirPath, filter, errOpts)
+		return summary, errors.Join(err, engine.Close())
+	}
+
+	// Report events until the engine is closed, at most once per interval
+	// unless the phase changes
+	events := engine.Subscribe()
+	var reporter sync.WaitGroup
+	reporter.Go(func() {
+		var last domain.ProgressEvent
+		var lastTime time.Time
+		for event := range events {
+			if event.Phase == last.Phase &&
+				time.Since(lastTime) < progressInterval {
+				continue
+			}
+			fmt.Fprintf(w, "\rIndexing %s... %3.0f%% %s\033[K",
+				dirPath, event.Fraction()*100, event)
+			last, lastTime = event, time.Now()
+		}
+		fmt.Fprintln(w)
+	})
+
+	summary, err := engine.Index(dirPath, filter, errOpts)
+	err = errors.Join(err, engine.Close())
+	reporter.Wait()
+	return summary, err
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// printIndexSummaries prints index summaries as a table.
+func printIndexSummaries(w io.Writer, outputs []indexOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "path\tfiles\tnew\tchanged\tunchanged\tineligible\tremoved\ttokens\terrors\ttime")
+	for _, o := range outputs {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1fs\n",
+			o.Path, o.Files, o.New, o.Changed, o.Unchanged, o.Ineligible,
+			o.Removed, o.Tokens, o.Errors, o.Elapsed)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	addFilterFlags(indexCmd)
+	addErrorFlags(indexCmd)
+	indexCmd.Flags().Bool("json", false, "print the summary as JSON")
+	rootCmd.AddCommand(indexCmd)
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
 	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
 	"github.com/vupdivup/typomat/internal/ui"
 	"go.uber.org/zap"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   fmt.Sprintf("%s <directory>", config.AppName),
+	Use:   fmt.Sprintf("%s <path>...", config.AppName),
 	Short: "Turn your code into muscle memory",
 	Long: `typomat is a command-line typing practice tool that creates exercises
 from the contents of your repository.
@@ -20,11 +22,53 @@ declarations, string literals and function signatures. These words are then used
 to build short, randomized typing prompts relevant to your codebase.
 
 Start a typing session by passing the path to the directory you'd like to
-practice on.
+practice on. Pass several directories to practice on all of them at once,
+optionally weighting each with --weights. Single text files work as well, and
+"-" reads text from standard input, e.g. git diff | typomat -.
 
 For large directories, startup times can be greatly reduced by reusing data
-across sessions. Pass the --cache flag to store results for subsequent runs.`,
-	Args: cobra.ExactArgs(1),
+across sessions. Pass the --cache flag to store results for subsequent runs.
+Pass --watch to pick up changes to the directories while you practice, e.g.
+while coding in another window.
+
+Each round shows the seed of the session once finished. Pass it with --seed
+to type the identical sequence of prompts, e.g. to compare results with a
+colleague practicing on the same code.
+
+Narrow down the files used with --include and --exclude, which take
+.gitignore-style glob patterns, or pick languages with --lang, e.g. --lang go,ts.
+Words with letters beyond the English alphabet are used once the letters are
+added with --alphabet, e.g. --alphabet de, or --letters.
+
+To warm up on the code you're about to work on, practice on recent changes of
+the directory's Git history instead. Select commits with --commits, --base and
+--author, and pass --hunks to only use lines added by them.
+
+Every round adds to the key stats shown by the stats command. Pass --drill to
+favor words typed with specific fingers, or --drill weak for the fingers you
+mistype most. Keys are assigned to fingers by --layout, e.g. --layout colemak.
+Between rounds, press k to see the error rate and speed of each key.
+
+Words you mistype are scheduled for review. Pass --review to have them come
+back in later prompts, at growing intervals, until you type them cleanly.
+Reviews are left out of seeded sessions and daily challenges.
+
+Prompts are made of common, short words with --difficulty easy, and of long,
+rare words with capitals and symbols of code with hard and expert. Press d
+between rounds to change the difficulty of the next ones. Pass --markov to
+string words into sequences as they follow each other in the code, e.g.
+"err nil return err", rather than shuffling them.
+
+For stricter practice, pass --mode stop-on-letter to only move on once the
+right key is pressed, stop-on-word to type mistyped words again, or
+sudden-death to fail the round on the first mistake. Pass --blind to only see
+your mistakes once the round is over, or --memory 5s to type the prompt from
+memory after seeing it for five seconds.
+
+Pass --daily to take the daily challenge instead. Its prompts are derived from
+the date and the repository, so everyone on your team practicing on the same
+code gets the same ones that day. Compare results with the results command.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: run,
 }
 
@@ -46,22 +90,68 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Handle cache flag
-	cache, err := cmd.Flags().GetBool("cache")
+	opts, err := sourceOptions(cmd, args)
 	if err != nil {
 		return err
 	}
+	if opts.Watch, err = cmd.Flags().GetBool("watch"); err != nil {
+		return err
+	}
+	// Reviews would make seeded prompts depend on past sessions
+	review, err := cmd.Flags().GetBool("review")
+	if err != nil {
+		return err
+	}
+	opts.Review = review && opts.Seed == nil && opts.Challenge == nil
+
+	if opts.Difficulty, err = difficultyOption(cmd); err != nil {
+		return err
+	}
+	if opts.Markov, err = cmd.Flags().GetBool("markov"); err != nil {
+		return err
+	}
+	if opts.Mode, err = modeOption(cmd); err != nil {
+		return err
+	}
 
-	// Parse args
-	dirPath := args[0]
+	var uiOpts ui.Options
+	if uiOpts.Layout, err = layoutOption(cmd); err != nil {
+		return err
+	}
+	if uiOpts.Blind, err = cmd.Flags().GetBool("blind"); err != nil {
+		return err
+	}
+	if uiOpts.Memory, err = cmd.Flags().GetDuration("memory"); err != nil {
+		return err
+	}
+	if uiOpts.Memory < 0 {
+		return fmt.Errorf("invalid memory duration: %s", uiOpts.Memory)
+	}
 
-	// Launch UI
-	return ui.Launch(dirPath, cache)
+	engine := domain.NewEngine()
+	if opts.Drill, err = drillOption(cmd, engine); err != nil {
+		return errors.Join(err, engine.Close())
+	}
+
+	// Launch UI, which closes the engine once done
+	return ui.Launch(engine, args, opts, uiOpts)
 }
 
 func init() {
-	rootCmd.Flags().BoolP("cache", "c", false, "store data for subsequent runs")
 	rootCmd.Flags().BoolP("purge", "p", false, "purge application cache")
+	rootCmd.Flags().BoolP("watch", "w", false, "keep directories in sync with changes while practicing")
+	rootCmd.Flags().Bool("review", false, "mix words you mistyped before into prompts")
+	rootCmd.Flags().StringSlice("drill", nil, "favor words typed with fingers, e.g. left-pinky,left-ring, or weak")
+	addLayoutFlag(rootCmd)
+	addSourceFlags(rootCmd)
+	addPromptFlags(rootCmd)
+	addModeFlag(rootCmd)
+	rootCmd.Flags().Bool("blind", false, "hide mistakes until the round is over")
+	rootCmd.Flags().Duration("memory", 0, "hide the prompt after showing it this long, e.g. 5s")
+	for _, flag := range []string{"daily", "commits", "base", "author", "hunks"} {
+		rootCmd.MarkFlagsMutuallyExclusive("watch", flag)
+	}
+	rootCmd.MarkFlagsMutuallyExclusive("drill", "daily")
 }
 
 func main() {
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+	"go.uber.org/zap"
+)
+
+var promptCmd = &cobra.Command{
+	Use:   "prompt <path>...",
+	Short: "Print prompts without starting a session",
+	Long: `Process the paths like a typing session would and print generated prompts
+to standard output, one per line, instead of starting a session.
+
+This makes the vocabulary of your code available to other tools and scripts.
+Pass --json for machine-readable output including the seed and number of each
+round. Sources are selected with the same flags as a typing session.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runPrompt,
+}
+
+// promptOutput is the JSON representation of a generated round.
+type promptOutput struct {
+	Prompt     string `json:"prompt"`
+	Seed       uint64 `json:"seed"`
+	Round      int    `json:"round"`
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty string `json:"difficulty"`
+}
+
+func runPrompt(cmd *cobra.Command, args []string) (err error) {
+	if err := config.Init(); err != nil {
+		zap.S().Error("Failed to initialize configuration", "error", err)
+		return err
+	}
+
+	count, err := cmd.Flags().GetInt("count")
+	if err != nil {
+		return err
+	}
+	if count < 1 {
+		return fmt.Errorf("invalid number of prompts: %d", count)
+	}
+	length, err := cmd.Flags().GetInt("length")
+	if err != nil {
+		return err
+	}
+	if length < 1 {
+		return fmt.Errorf("invalid prompt length: %d", length)
+	}
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+
+	opts, err := sourceOptions(cmd, args)
+	if err != nil {
+		return err
+	}
+	if opts.Difficulty, err = difficultyOption(cmd); err != nil {
+		return err
+	}
+	if opts.Markov, err = cmd.Flags().GetBool("markov"); err != nil {
+		return err
+	}
+
+	engine := domain.NewEngine()
+	defer func() {
+		err = errors.Join(err, engine.Close())
+	}()
+	err = engine.Setup(args, opts, length)
+	// Files that failed to process are reported whether or not setup failed
+	domain.WriteWarningReport(cmd.ErrOrStderr(), engine.Warnings()) // nolint:errcheck
+	domain.WriteFailureReport(cmd.ErrOrStderr(), engine.Failures()) // nolint:errcheck
+	if err != nil {
+		return err
+	}
+
+	rounds := []promptOutput{}
+	for range count {
+		round, err := engine.Prompt()
+		if err != nil {
+			return err
+		}
+		if !asJSON {
+			fmt.Fprintln(cmd.OutOrStdout(), round.Prompt)
+			continue
+		}
+		rounds = append(rounds, promptOutput{
+			Prompt: round.Prompt, Seed: round.Seed, Round: round.Number,
+			Challenge: round.Challenge, Difficulty: string(round.Difficulty),
+		})
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(rounds)
+	}
+	return nil
+}
+
+func init() {
+	promptCmd.Flags().IntP("count", "n", 1, "number of prompts to print")
+	promptCmd.Flags().Int("length", domain.DefaultPromptLen, "maximum length of a prompt in characters")
+	promptCmd.Flags().Bool("json", false, "print prompts as JSON")
+	addSourceFlags(promptCmd)
+	addPromptFlags(promptCmd)
+	rootCmd.AddCommand(promptCmd)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+	"go.uber.org/zap"
+)
+
+var resultsCmd = &cobra.Command{
+	Use:   "results",
+	Short: "Compare results of daily challenges",
+	Long: `Show the standings of the daily challenges of a day, today by default.
+
+Results of rounds typed with --daily are recorded locally. To compare them with
+your colleagues, write them to a file with --export and pass the files you
+receive to --import. Rounds typed in a strict --mode are ranked separately.`,
+	Args: cobra.NoArgs,
+	RunE: runResults,
+}
+
+func runResults(cmd *cobra.Command, args []string) error {
+	if err := config.Init(); err != nil {
+		zap.S().Error("Failed to initialize configuration", "error", err)
+		return err
+	}
+
+	day := time.Now()
+	if cmd.Flags().Changed("date") {
+		date, err := cmd.Flags().GetString("date")
+		if err != nil {
+			return err
+		}
+		if day, err = domain.ParseDate(date); err != nil {
+			return err
+		}
+	}
+
+	// Handle export flag
+	export, err := cmd.Flags().GetString("export")
+	if err != nil {
+		return err
+	}
+	if export != "" {
+		return exportResults(export, day)
+	}
+
+	// Handle import flag
+	imports, err := cmd.Flags().GetStringSlice("import")
+	if err != nil {
+		return err
+	}
+
+	challenges, err := domain.CompareResults(day, imports)
+	if err != nil {
+		return err
+	}
+	return printResults(cmd.OutOrStdout(), challenges)
+}
+
+// exportResults writes the results of the day to the file at the specified
+// path, or to standard output if the path is "-".
+func exportResults(path string, day time.Time) error {
+	if path == "-" {
+		return domain.ExportResults(os.Stdout, day)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := domain.ExportResults(file, day); err != nil {
+		return errors.Join(err, file.Close())
+	}
+	return file.Close()
+}
+
+// printResults prints the standings of each challenge as a table.
+func printResults(w io.Writer, challenges []domain.ChallengeResults) error {
+	if len(challenges) == 0 {
+		_, err := fmt.Fprintln(w, "No results recorded.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, c := range challenges {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		// Rounds of strict modes are ranked apart from normal ones
+		if c.Mode != domain.ModeNormal {
+			fmt.Fprintf(tw, "%s (%s) %s\n", c.ID, c.Label, c.Mode)
+		} else {
+			fmt.Fprintf(tw, "%s (%s)\n", c.ID, c.Label)
+		}
+		fmt.Fprintln(tw, "\tplayer\trounds\tfailed\twpm\tbest\tacc")
+		for rank, s := range c.Standings {
+			fmt.Fprintf(tw, "%d.\t%s\t%d\t%d\t%d\t%d\t%d%%\n",
+				rank+1, s.Player, s.Rounds, s.Failed, s.AvgWPM, s.BestWPM,
+				s.AvgAccuracy)
+		}
+	}
+	return tw.Flush()
+}
+
+func init() {
+	resultsCmd.Flags().String("date", "", "show results of a date in YYYY-MM-DD format")
+	resultsCmd.Flags().String("export", "", "write results to a file, - for standard output")
+	resultsCmd.Flags().StringSlice("import", nil, "compare with results exported by others")
+	resultsCmd.MarkFlagsMutuallyExclusive("export", "import")
+	rootCmd.AddCommand(resultsCmd)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+	"github.com/vupdivup/typomat/pkg/layout"
+	"go.uber.org/zap"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show typing errors per finger and row",
+	Long: `Show how the keys of your keyboard layout were typed across all rounds,
+summed per finger and per row: the number of key presses, the share of them
+mistyped and the average time taken per key.
+
+Keys are assigned to fingers by --layout, which takes the name of a built-in
+layout or the path of a layout file. A layout file lists the keys of each row,
+from the number row, which may be left out, to the bottom row, e.g.:
+
+  # Workman
+  qdrwbjfup;[]
+  ashtgyneoi'
+  zxmcvkl,./
+
+Practice the fingers you mistype most with typomat --drill weak.`,
+	Args: cobra.NoArgs,
+	RunE: runStats,
+}
+
+// groupStats are the key stats of a finger or row as written by --json.
+type groupStats struct {
+	Name         string  `json:"name"`
+	Presses      int     `json:"presses"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+}
+
+// statsOutput is the output of --json.
+type statsOutput struct {
+	Layout      string       `json:"layout"`
+	Fingers     []groupStats `json:"fingers"`
+	Rows        []groupStats `json:"rows"`
+	WeakFingers []string     `json:"weak_fingers"`
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	if err := config.Init(); err != nil {
+		zap.S().Error("Failed to initialize configuration", "error", err)
+		return err
+	}
+
+	l, err := layoutOption(cmd)
+	if err != nil {
+		return err
+	}
+	engine := domain.NewEngine()
+	stats, err := engine.LoadKeyStats()
+	if err := errors.Join(err, engine.Close()); err != nil {
+		return err
+	}
+
+	out := statsOutput{
+		Layout: l.Name, Fingers: []groupStats{}, Rows: []groupStats{},
+		WeakFingers: []string{},
+	}
+	byFinger := domain.StatsByFinger(stats, l)
+	for _, f := range layout.Fingers() {
+		if s, ok := byFinger[f]; ok {
+			out.Fingers = append(out.Fingers, newGroupStats(f.String(), s))
+		}
+	}
+	byRow := domain.StatsByRow(stats, l)
+	for _, r := range layout.Rows() {
+		if s, ok := byRow[r]; ok {
+			out.Rows = append(out.Rows, newGroupStats(r.String(), s))
+		}
+	}
+	for _, f := range domain.WeakFingers(stats, l, weakDrillFingers) {
+		out.WeakFingers = append(out.WeakFingers, f.String())
+	}
+
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	return printStats(cmd.OutOrStdout(), out)
+}
+
+// newGroupStats returns the output of the key stats of a finger or row.
+func newGroupStats(name string, s domain.KeyStats) groupStats {
+	return groupStats{
+		Name:         name,
+		Presses:      s.Presses,
+		Errors:       s.Errors,
+		ErrorRate:    s.ErrorRate(),
+		AvgLatencyMs: s.AvgLatency().Milliseconds(),
+	}
+}
+
+// printStats prints the key stats per finger and per row as tables.
+func printStats(w io.Writer, out statsOutput) error {
+	if len(out.Fingers) == 0 {
+		_, err := fmt.Fprintln(w, "No key stats recorded.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, table := range []struct {
+		title  string
+		groups []groupStats
+	}{{"finger", out.Fingers}, {"row", out.Rows}} {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s (%s)\tpresses\terrors\tms/key\n", table.title, out.Layout)
+		for _, g := range table.groups {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%d\n",
+				g.Name, g.Presses, g.ErrorRate*100, g.AvgLatencyMs)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(out.WeakFingers) > 0 {
+		_, err := fmt.Fprintf(w, "\nWeakest fingers: %s\n", strings.Join(out.WeakFingers, ","))
+		return err
+	}
+	return nil
+}
+
+func init() {
+	addLayoutFlag(statsCmd)
+	statsCmd.Flags().Bool("json", false, "print stats as JSON")
+	rootCmd.AddCommand(statsCmd)
+}
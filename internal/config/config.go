@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/vupdivup/typomat/pkg/files"
@@ -20,6 +21,9 @@ const (
 )
 
 var (
+	// dirsMu guards the directory paths, which SetupDirs may change while
+	// they are in use.
+	dirsMu      sync.RWMutex
 	appDir      string
 	dbDir       string
 	logDir      string
@@ -30,28 +34,13 @@ var (
 // Init initializes the configuration by setting up necessary directories
 // and configuring the logger.
 func Init() error {
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return ErrInit
-	}
-
-	// Create application directories
-	appDir = filepath.Join(cacheDir, AppName)
-	dbDir = filepath.Join(appDir, "db")
-	logDir = filepath.Join(appDir, "logs")
-	tempDbDir = filepath.Join(dbDir, "tmp")
-	cachedDbDir = filepath.Join(dbDir, "cache")
-
-	dirs := []string{appDir, dbDir, logDir, tempDbDir, cachedDbDir}
-	for _, dir := range dirs {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return ErrInit
-		}
+	if err := SetupDirs(); err != nil {
+		return err
 	}
 
 	// Determine log file path
 	logFileName := time.Now().Format("20060102_150405") + ".log"
-	logPath := filepath.Join(logDir, logFileName)
+	logPath := filepath.Join(LogDir(), logFileName)
 
 	// Initialize zap logger
 	var config zap.Config
@@ -70,31 +59,80 @@ func Init() error {
 	return nil
 }
 
+// SetupDirs places the application directories in the user cache directory
+// and creates those missing, e.g. after the cache directory was removed. It
+// may be called again to follow changes of the user cache directory.
+func SetupDirs() error {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ErrInit
+	}
+
+	dirsMu.Lock()
+	defer dirsMu.Unlock()
+
+	// Create application directories
+	appDir = filepath.Join(cacheDir, AppName)
+	dbDir = filepath.Join(appDir, "db")
+	logDir = filepath.Join(appDir, "logs")
+	tempDbDir = filepath.Join(dbDir, "tmp")
+	cachedDbDir = filepath.Join(dbDir, "cache")
+
+	dirs := []string{appDir, dbDir, logDir, tempDbDir, cachedDbDir}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return ErrInit
+		}
+	}
+	return nil
+}
+
 // AppDir returns the application directory path.
 func AppDir() string {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
 	return appDir
 }
 
+// LogDir returns the directory path where log files are stored.
+func LogDir() string {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
+	return logDir
+}
+
 // DbDir returns the directory path where database files are stored.
 func DbDir() string {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
 	return dbDir
 }
 
 // TempDbDir returns the directory path where temporary database files are
 // stored.
 func TempDbDir() string {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
 	return tempDbDir
 }
 
 // CachedDbDir returns the directory path where cached database files are
 // stored.
 func CachedDbDir() string {
+	dirsMu.RLock()
+	defer dirsMu.RUnlock()
 	return cachedDbDir
 }
 
+// ResultsDbPath returns the path of the database holding typing results.
+// Unlike cached databases, it is kept when the cache is purged.
+func ResultsDbPath() string {
+	return filepath.Join(DbDir(), "results.db")
+}
+
 // PurgeCache deletes all cached data stored in the database directory.
 func PurgeCache() error {
 	zap.S().Infow("Purging application cache",
-		"db_dir", dbDir)
-	return files.RemoveChildren(cachedDbDir)
+		"db_dir", DbDir())
+	return files.RemoveChildren(CachedDbDir())
 }
@@ -5,9 +5,15 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"iter"
 	"math"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -15,6 +21,7 @@ import (
 	"github.com/glebarez/sqlite"
 	"github.com/vupdivup/typomat/internal/config"
 	"github.com/vupdivup/typomat/pkg/files"
+	"github.com/vupdivup/typomat/pkg/lease"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
@@ -24,28 +31,56 @@ const (
 	// batchSize is the number of records to process in a single batch
 	// operation.
 	batchSize = 100
+
+	// busyTimeout is the time in milliseconds a connection waits for another
+	// process to release its lock on the database.
+	busyTimeout = 10_000
+
+	// leaseTTL is the time after which a lease held by an unresponsive
+	// process is considered abandoned.
+	leaseTTL = 30 * time.Second
+
+	// ownerSuffix is appended to the path of a temporary database to name the
+	// lock file of the lease its instance holds until closing it.
+	ownerSuffix = ".owner"
 )
 
-var (
-	// db is the global database connection.
+// Store is a handle to the database of a single source, holding its files
+// and tokens.
+type Store struct {
+	// db is the database connection.
 	db *gorm.DB
-	// dbPath is the path to the database file.
-	dbPath string
-	// dirPath is the directory path associated with the database.
-	dirPath string
-
-	// ctx is the data-level context for graceful shutdowns.
+	// path is the path to the database file.
+	path string
+	// id identifies the source associated with the database, e.g. its
+	// directory path.
+	id string
+	// isTemp indicates whether the database is private to this instance and
+	// should be removed on close.
+	isTemp bool
+	// lockMu is held from Lock to Unlock, so goroutines sharing the store
+	// take turns like instances do.
+	lockMu sync.Mutex
+	// leaseMu guards lease.
+	leaseMu sync.Mutex
+	// lease is the lease held while the database is being modified.
+	lease *lease.Lease
+	// owner is the lease held on a temporary database for as long as it is
+	// open, telling other instances that it is in use.
+	owner *lease.Lease
+
+	// ctx is the store-level context for graceful shutdowns.
 	ctx context.Context
-	// cancel is the cancel function for the data-level context.
+	// cancel is the cancel function for the store-level context.
 	cancel context.CancelFunc
-)
+}
 
 // Token represents a token record in the database.
 type Token struct {
 	// Path is the path to the file from which the token was extracted.
 	Path string `gorm:"primaryKey"`
 	// Value is the token value.
-	Value string `gorm:"primaryKey"`
+	Value string `gorm:"primaryKey;index"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -73,8 +108,13 @@ type File struct {
 	UpdatedAt time.Time
 }
 
-func init() {
-	ctx, cancel = context.WithCancel(context.Background())
+// Meta represents a key-value pair describing the database itself, such as
+// the state of the last indexing run.
+type Meta struct {
+	// Key is the metadata key.
+	Key string `gorm:"primaryKey"`
+	// Value is the metadata value.
+	Value string
 }
 
 // VersionEquals checks if two File instances refer to the same version of a file.
@@ -85,8 +125,14 @@ func (f *File) VersionEquals(other File) bool {
 }
 
 // UpsertTokens inserts or updates the given tokens in a database.
-func UpsertTokens(tokens []Token) error {
-	result := db.Clauses(clause.OnConflict{UpdateAll: true}).
+func (s *Store) UpsertTokens(tokens []Token) error {
+	return upsertTokens(s.db, tokens)
+}
+
+// upsertTokens inserts or updates the given tokens using the specified
+// connection or transaction.
+func upsertTokens(tx *gorm.DB, tokens []Token) error {
+	result := tx.Clauses(clause.OnConflict{UpdateAll: true}).
 		CreateInBatches(tokens, batchSize)
 	if result.Error != nil {
 		zap.S().Errorw("Failed to upsert tokens into database",
@@ -101,8 +147,14 @@ func UpsertTokens(tokens []Token) error {
 }
 
 // UpsertFiles uploads or updates file records in a database.
-func UpsertFiles(files []File) error {
-	result := db.Clauses(clause.OnConflict{UpdateAll: true}).
+func (s *Store) UpsertFiles(files []File) error {
+	return upsertFiles(s.db, files)
+}
+
+// upsertFiles uploads or updates file records using the specified connection
+// or transaction.
+func upsertFiles(tx *gorm.DB, files []File) error {
+	result := tx.Clauses(clause.OnConflict{UpdateAll: true}).
 		CreateInBatches(files, batchSize)
 	if result.Error != nil {
 		zap.S().Errorw("Failed to upsert files into database",
@@ -116,31 +168,62 @@ func UpsertFiles(files []File) error {
 	return nil
 }
 
-// DeleteFile removes a file record from the database, optionally cascading
-// the deletion to associated tokens.
-func DeleteFile(file File, cascade bool) error {
-	// Delete the file record
-	if err := db.Delete(&file).Error; err != nil {
-		zap.S().Errorw("Failed to delete file from database",
-			"file_path", file.Path,
+// SaveFiles records the given files as up to date and replaces their tokens
+// and token transitions in a single transaction. Either all changes are
+// applied or none are, so a file is never recorded without its tokens.
+func (s *Store) SaveFiles(
+	files []File, tokens []Token, transitions []Transition,
+) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Delete tokens of previous file versions
+		for _, file := range files {
+			if err := deleteTokensOfFile(tx, file.Path); err != nil {
+				return err
+			}
+		}
+
+		if err := upsertFiles(tx, files); err != nil {
+			return err
+		}
+		if err := upsertTokens(tx, tokens); err != nil {
+			return err
+		}
+		return upsertTransitions(tx, transitions)
+	})
+	if err != nil {
+		zap.S().Errorw("Failed to save files, rolled back transaction",
+			"file_count", len(files),
+			"token_count", len(tokens),
+			"transition_count", len(transitions),
 			"error", err)
 		return ErrQuery
 	}
 
+	return nil
+}
+
+// DeleteFile removes a file record from the database, optionally cascading
+// the deletion to associated tokens. A cascading deletion is performed in a
+// single transaction.
+func (s *Store) DeleteFile(file File, cascade bool) error {
 	if !cascade {
-		zap.S().Debugw("Deleted file from database",
-			"file_path", file.Path)
+		if err := deleteFile(s.db, file); err != nil {
+			return err
+		}
 		zap.S().Debugw("Skipping cascade delete of associated tokens",
 			"file_path", file.Path)
 		return nil
 	}
 
-	// Cascade delete associated tokens
-	if err := db.
-		Where("path = ?", file.Path).
-		Delete(&Token{}).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteFile(tx, file); err != nil {
+			return err
+		}
+		return deleteTokensOfFile(tx, file.Path)
+	})
+	if err != nil {
 		zap.S().Errorw(
-			"Failed to cascade delete tokens from database",
+			"Failed to cascade delete file, rolled back transaction",
 			"file_path", file.Path,
 			"error", err)
 		return ErrQuery
@@ -152,11 +235,35 @@ func DeleteFile(file File, cascade bool) error {
 	return nil
 }
 
-// DeleteTokensOfFile removes all tokens associated with a specific file
-// from the database.
-func DeleteTokensOfFile(path string) error {
-	// Delete associated tokens
-	if err := db.
+// deleteFile removes a file record using the specified connection or
+// transaction.
+func deleteFile(tx *gorm.DB, file File) error {
+	if err := tx.Delete(&file).Error; err != nil {
+		zap.S().Errorw("Failed to delete file from database",
+			"file_path", file.Path,
+			"error", err)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Deleted file from database",
+		"file_path", file.Path)
+	return nil
+}
+
+// DeleteTokensOfFile removes all tokens associated with a specific file,
+// along with their transitions, from the database.
+func (s *Store) DeleteTokensOfFile(path string) error {
+	return deleteTokensOfFile(s.db, path)
+}
+
+// deleteTokensOfFile removes all tokens associated with a specific file,
+// along with their transitions, using the specified connection or
+// transaction.
+func deleteTokensOfFile(tx *gorm.DB, path string) error {
+	if err := deleteTransitionsOfFile(tx, path); err != nil {
+		return err
+	}
+	if err := tx.
 		Where("path = ?", path).
 		Delete(&Token{}).Error; err != nil {
 		zap.S().Errorw(
@@ -172,11 +279,67 @@ func DeleteTokensOfFile(path string) error {
 	return nil
 }
 
+// Clear removes all file, token and transition records from the database in
+// a single transaction. Metadata is kept.
+func (s *Store) Clear() error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&Transition{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("1 = 1").Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("1 = 1").Delete(&File{}).Error
+	})
+	if err != nil {
+		zap.S().Errorw("Failed to clear database, rolled back transaction",
+			"error", err)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Cleared files and tokens from database")
+	return nil
+}
+
+// GetMeta retrieves the metadata value stored under the specified key. The
+// boolean return value reports whether the key exists.
+func (s *Store) GetMeta(key string) (string, bool, error) {
+	var meta []Meta
+	if err := s.db.Where(&Meta{Key: key}).Limit(1).Find(&meta).Error; err != nil {
+		zap.S().Errorw("Failed to retrieve metadata from database",
+			"key", key,
+			"error", err)
+		return "", false, ErrQuery
+	}
+
+	if len(meta) == 0 {
+		return "", false, nil
+	}
+	return meta[0].Value, true, nil
+}
+
+// SetMeta stores a metadata value under the specified key.
+func (s *Store) SetMeta(key, value string) error {
+	result := s.db.Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&Meta{Key: key, Value: value})
+	if result.Error != nil {
+		zap.S().Errorw("Failed to store metadata in database",
+			"key", key,
+			"error", result.Error)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Stored metadata in database",
+		"key", key,
+		"value", value)
+	return nil
+}
+
 // IterUniqueTokens returns an iterator over distinct tokens in the database.
-func IterUniqueTokens() iter.Seq[TokenResult] {
+func (s *Store) IterUniqueTokens() iter.Seq[TokenResult] {
 	return func(yield func(TokenResult) bool) {
 		// Query distinct tokens
-		rows, err := db.Model(&Token{}).Distinct("value").Rows()
+		rows, err := s.db.Model(&Token{}).Distinct("value").Order("value").Rows()
 		if err != nil {
 			zap.S().Errorw("Failed to query distinct tokens",
 				"error", err)
@@ -188,7 +351,7 @@ func IterUniqueTokens() iter.Seq[TokenResult] {
 		// Iterate over the result set
 		for rows.Next() {
 			var token Token
-			if err := db.ScanRows(rows, &token); err != nil {
+			if err := s.db.ScanRows(rows, &token); err != nil {
 				zap.S().Errorw("Failed to scan token row",
 					"error", err)
 				yield(TokenResult{Err: ErrQuery})
@@ -201,10 +364,61 @@ func IterUniqueTokens() iter.Seq[TokenResult] {
 	}
 }
 
+// FilterTokens returns the values among the specified ones that are tokens
+// of the database, in their original order.
+func (s *Store) FilterTokens(values []string) ([]string, error) {
+	if len(values) == 0 {
+		return []string{}, nil
+	}
+
+	var found []string
+	if err := s.db.Model(&Token{}).Distinct("value").
+		Where("value IN ?", values).Pluck("value", &found).Error; err != nil {
+		zap.S().Errorw("Failed to look up tokens in database",
+			"token_count", len(values),
+			"error", err)
+		return []string{}, ErrQuery
+	}
+
+	filtered := []string{}
+	for _, v := range values {
+		if slices.Contains(found, v) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered, nil
+}
+
+// CountTokens returns the number of files containing each of the specified
+// token values. Values that are not tokens of the database are left out.
+func (s *Store) CountTokens(values []string) (map[string]int, error) {
+	counts := map[string]int{}
+	if len(values) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		Value string
+		Count int
+	}
+	if err := s.db.Model(&Token{}).Select("value, COUNT(*) AS count").
+		Where("value IN ?", values).Group("value").Scan(&rows).Error; err != nil {
+		zap.S().Errorw("Failed to count tokens in database",
+			"token_count", len(values),
+			"error", err)
+		return counts, ErrQuery
+	}
+
+	for _, row := range rows {
+		counts[row.Value] = row.Count
+	}
+	return counts, nil
+}
+
 // GetFiles retrieves all file records from the database.
-func GetFiles() ([]File, error) {
+func (s *Store) GetFiles() ([]File, error) {
 	var files []File
-	if err := db.Find(&files).Error; err != nil {
+	if err := s.db.Find(&files).Error; err != nil {
 		zap.S().Errorw("Failed to retrieve files from database",
 			"error", err)
 		return []File{}, ErrQuery
@@ -215,74 +429,216 @@ func GetFiles() ([]File, error) {
 	return files, nil
 }
 
-// Setup initializes the database connection for the specified directory.
-// If a cached database already exists for the directory, it will be used.
-// Alternatively, if useCache is true, the cached database will be used or created.
-func Setup(dirPath string, useCache bool) error {
+// Open opens the database of the source with the specified ID, typically its
+// directory path. If a cached database already exists for the ID, it will be
+// used. Alternatively, if useCache is true, the cached database will be used
+// or created.
+//
+// Temporary databases are unique to each instance, while cached databases are
+// shared between instances working on the same source. See Lock for
+// coordinating writes to a shared database.
+func Open(id string, useCache bool) (*Store, error) {
 	// Hash the ID to create a filename
 	h := sha256.New()
-	h.Write([]byte(dirPath))
+	h.Write([]byte(id))
 	hashedId := hex.EncodeToString(h.Sum(nil))
 
+	s := &Store{id: id}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
 	// Check if the database was cached on a previous run
 	cachedDbPath := filepath.Join(config.CachedDbDir(), hashedId+".db")
 	cacheExists, err := files.FileExists(cachedDbPath)
 	if err != nil {
 		zap.S().Errorw("Failed to check cached database existence",
-			"db_id", dirPath,
+			"db_id", id,
 			"db_path", cachedDbPath,
 			"error", err)
-		return ErrConn
+		return nil, ErrConn
 	}
 
 	// If cache is enabled or a cached database exists, use it
 	if cacheExists || useCache {
 		zap.S().Debugw("Using cached database",
-			"db_id", dirPath,
+			"db_id", id,
 			"db_path", cachedDbPath)
-		dbPath = cachedDbPath
+		s.path = cachedDbPath
 	} else {
-		dbPath = filepath.Join(config.TempDbDir(), hashedId+".db")
+		removeStaleTempDbs()
+
+		// Reserve a database file name unique to this instance
+		f, err := os.CreateTemp(config.TempDbDir(), hashedId+"-*.db")
+		if err != nil {
+			zap.S().Errorw("Failed to create temporary database file",
+				"db_id", id,
+				"error", err)
+			return nil, ErrConn
+		}
+		if err := f.Close(); err != nil {
+			zap.S().Errorw("Failed to close temporary database file",
+				"db_id", id,
+				"error", err)
+			return nil, ErrConn
+		}
+		s.path = f.Name()
+		s.isTemp = true
+
+		// Claim the database before another instance takes it for stale
+		owner, err := lease.Acquire(s.ctx, s.path+ownerSuffix, leaseTTL)
+		if err != nil {
+			zap.S().Errorw("Failed to acquire temporary database owner lease",
+				"db_id", id,
+				"db_path", s.path,
+				"error", err)
+			removeDbFiles(s.path) // nolint:errcheck
+			return nil, ErrLock
+		}
+		s.owner = owner
 	}
 
 	// Open (or create) the SQLite database
-	db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	// WAL mode lets readers proceed while another instance writes
+	dsn := fmt.Sprintf("%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)",
+		s.path, busyTimeout)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
 		zap.S().Errorw("Failed to open database",
-			"db_id", dirPath,
-			"db_path", dbPath,
+			"db_id", id,
+			"db_path", s.path,
 			"error", err)
-		return ErrConn
+		if s.isTemp {
+			removeDbFiles(s.path) // nolint:errcheck
+			s.releaseOwner()
+		}
+		return nil, ErrConn
 	}
-	db = db.WithContext(ctx)
+	s.db = db.WithContext(s.ctx)
 	zap.S().Infow("Opened database",
-		"db_id", dirPath,
-		"db_path", dbPath)
+		"db_id", id,
+		"db_path", s.path)
+
+	// Perform migrations, holding the lease to avoid racing other instances
+	if err := s.migrate(); err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+
+	return s, nil
+}
+
+// migrate creates or updates the database schema.
+func (s *Store) migrate() error {
+	if err := s.Lock(); err != nil {
+		return err
+	}
+	defer s.Unlock() // nolint:errcheck
 
-	// Perform migrations
-	if err := db.AutoMigrate(&File{}, &Token{}); err != nil {
+	if err := s.db.AutoMigrate(&File{}, &Token{}, &Transition{}, &Meta{}); err != nil {
 		zap.S().Errorw("Failed to migrate or create database schema",
-			"db_id", dirPath,
+			"db_id", s.id,
 			"error", err)
 		return ErrQuery
 	}
+	return nil
+}
 
+// Lock acquires a lease on the database, blocking while another instance or
+// another goroutine of this instance holds it. Callers should hold the lease
+// while modifying the database to avoid interleaving their changes with those
+// of others.
+func (s *Store) Lock() error {
+	s.lockMu.Lock()
+	l, err := lease.Acquire(s.ctx, s.path+".lock", leaseTTL)
+	if err != nil {
+		s.lockMu.Unlock()
+		zap.S().Errorw("Failed to acquire database lease",
+			"db_path", s.path,
+			"error", err)
+		return ErrLock
+	}
+
+	zap.S().Debugw("Acquired database lease",
+		"db_path", s.path)
+	s.leaseMu.Lock()
+	s.lease = l
+	s.leaseMu.Unlock()
 	return nil
 }
 
-// Teardown closes the database connection and cleans up temporary files.
-func Teardown() error {
-	// Cancel any ongoing operations
-	cancel()
-	
-	if db == nil {
+// Unlock releases the lease acquired by Lock. It has no effect if the lease
+// is not held.
+func (s *Store) Unlock() error {
+	s.leaseMu.Lock()
+	l := s.lease
+	s.lease = nil
+	s.leaseMu.Unlock()
+	if l == nil {
 		return nil
 	}
+	defer s.lockMu.Unlock()
+
+	if err := l.Release(); err != nil {
+		zap.S().Errorw("Failed to release database lease",
+			"db_path", s.path,
+			"error", err)
+		return ErrLock
+	}
 
+	zap.S().Debugw("Released database lease",
+		"db_path", s.path)
+	return nil
+}
 
-	sqlDB, err := db.DB()
+// removeStaleTempDbs removes temporary databases whose instance is no longer
+// running, e.g. because it crashed. An instance holds the owner lease of its
+// temporary database until closing it, so a database whose owner lease can be
+// acquired is stale. Files without an owner lease are left alone.
+func removeStaleTempDbs() {
+	owners, err := filepath.Glob(
+		filepath.Join(config.TempDbDir(), "*.db"+ownerSuffix))
+	if err != nil {
+		zap.S().Warnw("Failed to list temporary database files",
+			"error", err)
+		return
+	}
+
+	for _, ownerPath := range owners {
+		owner, err := lease.TryAcquire(ownerPath, leaseTTL)
+		if errors.Is(err, lease.ErrHeld) {
+			continue
+		} else if err != nil {
+			zap.S().Warnw("Failed to check temporary database owner lease",
+				"path", ownerPath,
+				"error", err)
+			continue
+		}
+
+		path := strings.TrimSuffix(ownerPath, ownerSuffix)
+		err = errors.Join(removeDbFiles(path), os.RemoveAll(path+".lock"))
+		if err != nil {
+			zap.S().Warnw("Failed to remove stale temporary database files",
+				"db_path", path,
+				"error", err)
+		} else {
+			zap.S().Infow("Removed stale temporary database files",
+				"db_path", path)
+		}
+		owner.Release() // nolint:errcheck
+	}
+}
+
+// Close closes the database connection and cleans up temporary files.
+// Only files belonging to this instance are removed.
+func (s *Store) Close() error {
+	// Cancel any ongoing operations
+	s.cancel()
+
+	if err := s.Unlock(); err != nil {
+		return ErrCleanup
+	}
+
+	sqlDB, err := s.db.DB()
 	if err != nil {
 		zap.S().Errorw("Failed to get sql.DB from gorm.DB during teardown",
 			"error", err)
@@ -294,13 +650,20 @@ func Teardown() error {
 		return ErrCleanup
 	}
 
+	if !s.isTemp {
+		return nil
+	}
+	// Files left behind are removed by the next instance once released
+	defer s.releaseOwner()
+
 	// Attempt to delete temporary database files with retries
 	baseTimeout := time.Millisecond * 50
 	deleteRetries := 4
 	for i := range deleteRetries {
-		err := files.RemoveChildren(config.TempDbDir())
+		err := removeDbFiles(s.path)
 		if err == nil {
 			zap.S().Infow("Removed temporary database files during teardown",
+				"db_path", s.path,
 				"attempt", i+1)
 			break
 		}
@@ -318,3 +681,30 @@ func Teardown() error {
 
 	return nil
 }
+
+// releaseOwner releases the owner lease of a temporary database. It has no
+// effect if the lease is not held.
+func (s *Store) releaseOwner() {
+	if s.owner == nil {
+		return
+	}
+
+	if err := s.owner.Release(); err != nil {
+		zap.S().Warnw("Failed to release temporary database owner lease",
+			"db_path", s.path,
+			"error", err)
+	}
+	s.owner = nil
+}
+
+// removeDbFiles removes a SQLite database file along with its auxiliary
+// files.
+func removeDbFiles(path string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		err := os.Remove(path + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
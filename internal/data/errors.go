@@ -7,6 +7,8 @@ var (
 	ErrConn = errors.New("failed to connect to database")
 	// ErrQuery indicates a failure during a database operation.
 	ErrQuery = errors.New("database operation failed")
+	// ErrLock indicates a failure to acquire or release a database lease.
+	ErrLock = errors.New("failed to coordinate database access")
 	// ErrCleanup indicates a failure to clean up database resources.
 	ErrCleanup = errors.New("failed to clean up database resources")
 )
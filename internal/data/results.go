@@ -0,0 +1,298 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/pkg/lease"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// ResultStore is a handle to the database of typing results. It is shared by
+// all instances and kept across cache purges.
+type ResultStore struct {
+	// db is the database connection.
+	db *gorm.DB
+	// path is the path to the database file.
+	path string
+}
+
+// Result represents the outcome of a single round of a daily challenge.
+type Result struct {
+	ID uint `gorm:"primaryKey"`
+	// Challenge identifies the challenge the round belongs to. Empty for
+	// rounds outside daily challenges.
+	Challenge string `gorm:"index"`
+	// Date is the date of the challenge, or of the round outside challenges,
+	// in YYYY-MM-DD format.
+	Date string `gorm:"index"`
+	// Label is a human-readable description of the challenge's sources.
+	Label string
+	// Player is the name of the person who typed the round.
+	Player string
+	// Round is the position of the round within the challenge, starting at 1.
+	Round int
+	// Prompt is the text that was typed.
+	Prompt string
+	// WPM is the typing speed in words per minute.
+	WPM int
+	// Accuracy is the typing accuracy in percent.
+	Accuracy int
+	// Mode is how strictly mistakes were handled while typing the round,
+	// e.g. "sudden-death". Empty for rounds recorded before modes existed.
+	Mode string
+	// Failed indicates that the round was failed by a mistake rather than
+	// finished.
+	Failed bool
+	// FinishedAt is the time the round was finished.
+	FinishedAt time.Time
+
+	CreatedAt time.Time
+}
+
+// KeyStat accumulates how a character of prompts was typed across all
+// sessions.
+type KeyStat struct {
+	// Char is the expected character.
+	Char string `gorm:"primaryKey"`
+	// Presses is the number of times the character was typed.
+	Presses int
+	// Errors is the number of times the character was mistyped.
+	Errors int
+	// LatencyMs is the total time in milliseconds from the previous key press
+	// to typing the character, over the timed presses.
+	LatencyMs int64
+	// Timed is the number of presses with a latency, i.e. all but the first
+	// of each round.
+	Timed int
+
+	UpdatedAt time.Time
+}
+
+// Review schedules a word that was mistyped to be typed again, following the
+// SM-2 spaced repetition algorithm.
+type Review struct {
+	// Token is the mistyped word.
+	Token string `gorm:"primaryKey"`
+	// Repetitions is the number of reviews passed in a row.
+	Repetitions int
+	// Interval is the number of days between the last review and the next.
+	Interval int
+	// Ease is the factor the interval grows by with each passed review.
+	Ease float64
+	// Due is the time the word is up for review again.
+	Due time.Time `gorm:"index"`
+	// Lapses is the number of reviews failed.
+	Lapses int
+	// Mistakes is the total number of mistyped characters of the word.
+	Mistakes int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OpenResults opens the results database, creating it if it does not exist.
+func OpenResults() (*ResultStore, error) {
+	s := &ResultStore{path: config.ResultsDbPath()}
+
+	dsn := fmt.Sprintf("%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)",
+		s.path, busyTimeout)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		zap.S().Errorw("Failed to open results database",
+			"db_path", s.path,
+			"error", err)
+		return nil, ErrConn
+	}
+	s.db = db
+
+	if err := s.migrate(); err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+
+	zap.S().Infow("Opened results database",
+		"db_path", s.path)
+	return s, nil
+}
+
+// migrate creates or updates the database schema, holding a lease to avoid
+// racing other instances.
+func (s *ResultStore) migrate() error {
+	l, err := lease.Acquire(context.Background(), s.path+".lock", leaseTTL)
+	if err != nil {
+		zap.S().Errorw("Failed to acquire results database lease",
+			"db_path", s.path,
+			"error", err)
+		return ErrLock
+	}
+	defer l.Release() // nolint:errcheck
+
+	if err := s.db.AutoMigrate(&Result{}, &KeyStat{}, &Review{}); err != nil {
+		zap.S().Errorw("Failed to migrate or create results database schema",
+			"db_path", s.path,
+			"error", err)
+		return ErrQuery
+	}
+	return nil
+}
+
+// SaveResult stores the result of a round.
+func (s *ResultStore) SaveResult(result Result) error {
+	if err := s.db.Create(&result).Error; err != nil {
+		zap.S().Errorw("Failed to store result in database",
+			"challenge", result.Challenge,
+			"round", result.Round,
+			"error", err)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Stored result in database",
+		"challenge", result.Challenge,
+		"round", result.Round)
+	return nil
+}
+
+// GetResults retrieves the results of all challenges of the specified date,
+// in the order they were finished.
+func (s *ResultStore) GetResults(date string) ([]Result, error) {
+	var results []Result
+	if err := s.db.Where(&Result{Date: date}).Where("challenge <> ''").
+		Order("finished_at").Find(&results).Error; err != nil {
+		zap.S().Errorw("Failed to retrieve results from database",
+			"date", date,
+			"error", err)
+		return []Result{}, ErrQuery
+	}
+
+	zap.S().Debugw("Retrieved results from database",
+		"date", date,
+		"result_count", len(results))
+	return results, nil
+}
+
+// AddKeyStats adds the specified presses, errors and latencies to the stats
+// of their characters.
+func (s *ResultStore) AddKeyStats(stats []KeyStat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	result := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "char"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"presses":    gorm.Expr("presses + excluded.presses"),
+			"errors":     gorm.Expr("errors + excluded.errors"),
+			"latency_ms": gorm.Expr("latency_ms + excluded.latency_ms"),
+			"timed":      gorm.Expr("timed + excluded.timed"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).CreateInBatches(stats, batchSize)
+	if result.Error != nil {
+		zap.S().Errorw("Failed to store key stats in database",
+			"error", result.Error)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Stored key stats in database",
+		"char_count", len(stats))
+	return nil
+}
+
+// GetKeyStats retrieves the stats of all typed characters, ordered by
+// character.
+func (s *ResultStore) GetKeyStats() ([]KeyStat, error) {
+	var stats []KeyStat
+	if err := s.db.Order("char").Find(&stats).Error; err != nil {
+		zap.S().Errorw("Failed to retrieve key stats from database",
+			"error", err)
+		return []KeyStat{}, ErrQuery
+	}
+
+	zap.S().Debugw("Retrieved key stats from database",
+		"char_count", len(stats))
+	return stats, nil
+}
+
+// GetReviews retrieves the reviews of the specified tokens. Tokens not under
+// review are left out.
+func (s *ResultStore) GetReviews(tokens []string) ([]Review, error) {
+	if len(tokens) == 0 {
+		return []Review{}, nil
+	}
+
+	var reviews []Review
+	if err := s.db.Where("token IN ?", tokens).Find(&reviews).Error; err != nil {
+		zap.S().Errorw("Failed to retrieve reviews from database",
+			"token_count", len(tokens),
+			"error", err)
+		return []Review{}, ErrQuery
+	}
+	return reviews, nil
+}
+
+// GetDueReviews retrieves up to limit reviews due at the specified time, the
+// longest overdue first.
+func (s *ResultStore) GetDueReviews(now time.Time, limit int) ([]Review, error) {
+	var reviews []Review
+	if err := s.db.Where("due <= ?", now).
+		Order("due").Limit(limit).Find(&reviews).Error; err != nil {
+		zap.S().Errorw("Failed to retrieve due reviews from database",
+			"error", err)
+		return []Review{}, ErrQuery
+	}
+	return reviews, nil
+}
+
+// SaveReviews stores the specified reviews and deletes the reviews of the
+// specified graduated tokens in a single transaction.
+func (s *ResultStore) SaveReviews(reviews []Review, graduated []string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(reviews) > 0 {
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).
+				CreateInBatches(reviews, batchSize).Error; err != nil {
+				return err
+			}
+		}
+		if len(graduated) > 0 {
+			return tx.Where("token IN ?", graduated).Delete(&Review{}).Error
+		}
+		return nil
+	})
+	if err != nil {
+		zap.S().Errorw("Failed to store reviews in database",
+			"review_count", len(reviews),
+			"graduated_count", len(graduated),
+			"error", err)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Stored reviews in database",
+		"review_count", len(reviews),
+		"graduated_count", len(graduated))
+	return nil
+}
+
+// Close closes the database connection.
+func (s *ResultStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		zap.S().Errorw("Failed to get sql.DB from gorm.DB during teardown",
+			"error", err)
+		return ErrCleanup
+	}
+	if err := sqlDB.Close(); err != nil {
+		zap.S().Errorw("Failed to close results database",
+			"error", err)
+		return ErrCleanup
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Transition represents how often a token follows a pair of tokens in a file
+// of the database. Together, the transitions of a file form its trigrams, and
+// summed over the first token, its bigrams.
+type Transition struct {
+	// Path is the path to the file in which the transition occurs.
+	Path string `gorm:"primaryKey"`
+	// First is the token before Second. Empty at the start of the file.
+	First string `gorm:"primaryKey;index:idx_transitions_context,priority:2"`
+	// Second is the token Next follows.
+	Second string `gorm:"primaryKey;index:idx_transitions_context,priority:1"`
+	// Next is the token following First and Second.
+	Next string `gorm:"primaryKey"`
+	// Count is the number of times the transition occurs in the file.
+	Count int
+}
+
+// upsertTransitions inserts or updates the given transitions using the
+// specified connection or transaction.
+func upsertTransitions(tx *gorm.DB, transitions []Transition) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+
+	result := tx.Clauses(clause.OnConflict{UpdateAll: true}).
+		CreateInBatches(transitions, batchSize)
+	if result.Error != nil {
+		zap.S().Errorw("Failed to upsert transitions into database",
+			"error", result.Error)
+		return ErrQuery
+	}
+
+	zap.S().Debugw("Upserted transitions into database",
+		"transition_count", len(transitions),
+		"rows_affected", result.RowsAffected)
+	return nil
+}
+
+// deleteTransitionsOfFile removes all transitions occurring in a specific
+// file using the specified connection or transaction.
+func deleteTransitionsOfFile(tx *gorm.DB, path string) error {
+	if err := tx.
+		Where("path = ?", path).
+		Delete(&Transition{}).Error; err != nil {
+		zap.S().Errorw(
+			"Failed to delete transitions of file from database",
+			"file_path", path,
+			"error", err)
+		return ErrQuery
+	}
+	return nil
+}
+
+// NextTokens returns the tokens following second, along with how often they
+// do across all files. If first is not empty, only the tokens following first
+// and second in a row are counted.
+func (s *Store) NextTokens(first, second string) (map[string]int, error) {
+	query := s.db.Model(&Transition{}).Select("next, SUM(count) AS count").
+		Where("second = ?", second)
+	if first != "" {
+		query = query.Where("first = ?", first)
+	}
+
+	var rows []struct {
+		Next  string
+		Count int
+	}
+	if err := query.Group("next").Scan(&rows).Error; err != nil {
+		zap.S().Errorw("Failed to look up next tokens in database",
+			"first", first,
+			"second", second,
+			"error", err)
+		return nil, ErrQuery
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Next] = row.Count
+	}
+	return counts, nil
+}
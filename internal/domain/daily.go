@@ -0,0 +1,430 @@
+package domain
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/git"
+	"go.uber.org/zap"
+)
+
+// dateLayout is the format of challenge dates.
+const dateLayout = "2006-01-02"
+
+// Challenge is a daily challenge, a sequence of prompts shared by everyone
+// practicing on the same sources with the same options on the same day.
+type Challenge struct {
+	// ID identifies the challenge, e.g. "2026-10-18/5f0c2a9e1b7d".
+	ID string
+	// Date is the UTC date of the challenge in YYYY-MM-DD format.
+	Date string
+	// Label describes the sources of the challenge, e.g. the repository.
+	Label string
+	// Seed is the seed of prompt generation derived from the challenge.
+	Seed uint64
+	// Player is the name results of this instance are recorded under.
+	Player string
+}
+
+// Standing summarizes the results of a player in a challenge.
+type Standing struct {
+	// Player is the name of the player.
+	Player string
+	// Rounds is the number of rounds finished or failed.
+	Rounds int
+	// Failed is the number of rounds failed, see ModeSuddenDeath.
+	Failed int
+	// AvgWPM is the average typing speed in words per minute of the rounds
+	// finished.
+	AvgWPM int
+	// BestWPM is the highest typing speed of a single round finished.
+	BestWPM int
+	// AvgAccuracy is the average typing accuracy in percent of the rounds
+	// finished.
+	AvgAccuracy int
+}
+
+// ChallengeResults are the standings of the players of a challenge in a
+// mode, best first.
+type ChallengeResults struct {
+	// ID identifies the challenge.
+	ID string
+	// Label describes the sources of the challenge.
+	Label string
+	// Mode is the mode the rounds were typed in. Rounds of different modes
+	// are ranked separately.
+	Mode Mode
+	// Standings are the standings of the players, by fewest rounds failed,
+	// then descending average speed.
+	Standings []Standing
+}
+
+// resultsFile is the format of exported results.
+type resultsFile struct {
+	Date    string           `json:"date"`
+	Results []exportedResult `json:"results"`
+}
+
+// exportedResult is a result as stored in exported results.
+type exportedResult struct {
+	Challenge  string    `json:"challenge"`
+	Label      string    `json:"label"`
+	Player     string    `json:"player"`
+	Round      int       `json:"round"`
+	Prompt     string    `json:"prompt"`
+	WPM        int       `json:"wpm"`
+	Accuracy   int       `json:"accuracy"`
+	Mode       Mode      `json:"mode"`
+	Failed     bool      `json:"failed"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// DailyChallenge returns the challenge of the specified day for the specified
+// sources and options. Sources must be inside Git repositories, which are
+// identified by their origin remote, so clones of a repository share their
+// challenges. Filter, weight and history options are part of the challenge.
+//
+// Prompts only match if the sources contain the same text, e.g. if everyone
+// is on the same commit.
+func DailyChallenge(paths []string, opts Options, day time.Time) (Challenge, error) {
+	date := day.UTC().Format(dateLayout)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "date=%s\n", date)
+
+	var labels []string
+	var player string
+	for i, path := range paths {
+		src, err := resolveSource(path)
+		if err != nil {
+			return Challenge{}, err
+		}
+		if src.kind == sourceStdin {
+			zap.S().Errorw("Standard input cannot be used in daily challenges")
+			return Challenge{}, ErrNotRepository
+		}
+
+		identity, err := sourceIdentity(src.path)
+		if err != nil {
+			return Challenge{}, err
+		}
+		labels = append(labels, identity)
+		fmt.Fprintf(h, "source=%s\n", identity)
+		if len(opts.Weights) > 0 {
+			fmt.Fprintf(h, "weight=%g\n", opts.Weights[i])
+		}
+
+		if player == "" {
+			player, _ = git.UserName(src.path)
+		}
+	}
+
+	signature, err := opts.Filter.signature()
+	if err != nil {
+		return Challenge{}, err
+	}
+	fmt.Fprintf(h, "filter=%q\n", signature)
+	if o := opts.History; o != nil {
+		fmt.Fprintf(h, "history=%d %q %q %t\n",
+			o.MaxCommits, o.Base, o.Author, o.Hunks)
+	}
+	sum := h.Sum(nil)
+
+	if player == "" {
+		player = currentUserName()
+	}
+
+	c := Challenge{
+		ID:     date + "/" + hex.EncodeToString(sum[:6]),
+		Date:   date,
+		Label:  strings.Join(labels, ", "),
+		Seed:   binary.BigEndian.Uint64(sum[:8]) % maxRandomSeed,
+		Player: player,
+	}
+	zap.S().Infow("Derived daily challenge",
+		"challenge", c.ID,
+		"label", c.Label,
+		"seed", c.Seed,
+		"player", c.Player)
+	return c, nil
+}
+
+// sourceIdentity returns a name identifying the source at the specified
+// absolute path across clones of its repository, i.e. the repository's
+// identity followed by the path of the source inside the work tree.
+func sourceIdentity(path string) (string, error) {
+	identity, err := git.Identity(path)
+	if errors.Is(err, git.ErrNotRepository) {
+		zap.S().Errorw("Source is not inside a Git repository",
+			"path", path)
+		return "", ErrNotRepository
+	} else if err != nil {
+		zap.S().Errorw("Failed to identify Git repository",
+			"path", path,
+			"error", err)
+		return "", ErrFileOperation
+	}
+
+	workTree, err := git.WorkTree(path)
+	if err != nil {
+		zap.S().Errorw("Failed to locate Git work tree",
+			"path", path,
+			"error", err)
+		return "", ErrFileOperation
+	}
+	rel, err := filepath.Rel(workTree, path)
+	if err != nil {
+		zap.S().Errorw("Failed to get path relative to Git work tree",
+			"path", path,
+			"work_tree", workTree,
+			"error", err)
+		return "", ErrFileOperation
+	}
+
+	if rel == "." {
+		return identity, nil
+	}
+	return identity + ":" + filepath.ToSlash(rel), nil
+}
+
+// currentUserName returns the name of the operating system user, falling back
+// to a placeholder if it cannot be determined.
+func currentUserName() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "anonymous"
+}
+
+// ParseDate parses a challenge date in YYYY-MM-DD format.
+func ParseDate(s string) (time.Time, error) {
+	day, err := time.Parse(dateLayout, s)
+	if err != nil {
+		zap.S().Errorw("Invalid challenge date",
+			"date", s,
+			"error", err)
+		return time.Time{}, ErrInvalidDate
+	}
+	return day, nil
+}
+
+// RecordResult stores the result of a finished or failed round, along with
+// the mode it was typed in. Results of rounds of the daily challenge of the
+// engine are compared with those of others, see ExportResults. Results of
+// other rounds are kept apart, without a challenge.
+func (e *Engine) RecordResult(round Round, wpm, accuracy int, failed bool) error {
+	store, err := e.results()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result := data.Result{
+		Date:       now.UTC().Format(dateLayout),
+		Round:      round.Number,
+		Prompt:     round.Prompt,
+		WPM:        wpm,
+		Accuracy:   accuracy,
+		Mode:       string(round.Mode.orNormal()),
+		Failed:     failed,
+		FinishedAt: now,
+	}
+	if c := e.challenge; c != nil && round.Challenge == c.ID {
+		result.Challenge = c.ID
+		result.Date = c.Date
+		result.Label = c.Label
+		result.Player = c.Player
+	}
+	return store.SaveResult(result)
+}
+
+// ExportResults writes the locally recorded results of the challenges of the
+// specified day to w as JSON, to be compared by others with CompareResults.
+func ExportResults(w io.Writer, day time.Time) error {
+	date := day.UTC().Format(dateLayout)
+	local, err := localResults(date)
+	if err != nil {
+		return err
+	}
+
+	file := resultsFile{Date: date, Results: []exportedResult{}}
+	for _, r := range local {
+		file.Results = append(file.Results, exportedResult{
+			Challenge:  r.Challenge,
+			Label:      r.Label,
+			Player:     r.Player,
+			Round:      r.Round,
+			Prompt:     r.Prompt,
+			WPM:        r.WPM,
+			Accuracy:   r.Accuracy,
+			Mode:       Mode(r.Mode).orNormal(),
+			Failed:     r.Failed,
+			FinishedAt: r.FinishedAt,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(file); err != nil {
+		zap.S().Errorw("Failed to export results",
+			"date", date,
+			"error", err)
+		return ErrFileOperation
+	}
+	return nil
+}
+
+// CompareResults returns the standings of the challenges of the specified
+// day, combining locally recorded results with those of the exported results
+// files at the specified paths. Challenges are ordered by ID, then mode.
+func CompareResults(day time.Time, importPaths []string) ([]ChallengeResults, error) {
+	date := day.UTC().Format(dateLayout)
+	local, err := localResults(date)
+	if err != nil {
+		return nil, err
+	}
+
+	all := []exportedResult{}
+	for _, r := range local {
+		all = append(all, exportedResult{
+			Challenge: r.Challenge, Label: r.Label, Player: r.Player,
+			Round: r.Round, WPM: r.WPM, Accuracy: r.Accuracy,
+			Mode: Mode(r.Mode), Failed: r.Failed, FinishedAt: r.FinishedAt,
+		})
+	}
+	for _, path := range importPaths {
+		imported, err := readResultsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range imported.Results {
+			if strings.HasPrefix(r.Challenge, date+"/") {
+				all = append(all, r)
+			}
+		}
+	}
+
+	return standings(all), nil
+}
+
+// localResults retrieves the locally recorded results of the specified date.
+func localResults(date string) ([]data.Result, error) {
+	store, err := data.OpenResults()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close() // nolint:errcheck
+
+	return store.GetResults(date)
+}
+
+// readResultsFile reads a results file written by ExportResults.
+func readResultsFile(path string) (resultsFile, error) {
+	var file resultsFile
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		zap.S().Errorw("Failed to read results file",
+			"file_path", path,
+			"error", err)
+		return file, ErrFileOperation
+	}
+	if err := json.Unmarshal(contents, &file); err != nil {
+		zap.S().Errorw("Failed to parse results file",
+			"file_path", path,
+			"error", err)
+		return file, ErrInvalidResults
+	}
+
+	return file, nil
+}
+
+// standings groups results by challenge and mode and summarizes them per
+// player. Results present more than once, e.g. because a file exported from
+// this instance was imported, are counted once. Results without a mode were
+// typed in ModeNormal.
+func standings(all []exportedResult) []ChallengeResults {
+	type resultKey struct {
+		challenge, player string
+		round             int
+		finishedAt        int64
+	}
+	type groupKey struct {
+		challenge string
+		mode      Mode
+	}
+	seen := map[resultKey]bool{}
+
+	byGroup := map[groupKey]*ChallengeResults{}
+	byPlayer := map[groupKey]map[string][]exportedResult{}
+	for _, r := range all {
+		key := resultKey{r.Challenge, r.Player, r.Round, r.FinishedAt.Unix()}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		group := groupKey{r.Challenge, r.Mode.orNormal()}
+		if _, ok := byGroup[group]; !ok {
+			byGroup[group] = &ChallengeResults{
+				ID: r.Challenge, Label: r.Label, Mode: group.mode,
+			}
+			byPlayer[group] = map[string][]exportedResult{}
+		}
+		byPlayer[group][r.Player] = append(byPlayer[group][r.Player], r)
+	}
+
+	challenges := []ChallengeResults{}
+	for group, c := range byGroup {
+		for player, results := range byPlayer[group] {
+			s := Standing{Player: player, Rounds: len(results)}
+			wpmSum, accSum := 0, 0
+			for _, r := range results {
+				if r.Failed {
+					s.Failed++
+					continue
+				}
+				wpmSum += r.WPM
+				accSum += r.Accuracy
+				s.BestWPM = max(s.BestWPM, r.WPM)
+			}
+			if finished := s.Rounds - s.Failed; finished > 0 {
+				s.AvgWPM = wpmSum / finished
+				s.AvgAccuracy = accSum / finished
+			}
+			c.Standings = append(c.Standings, s)
+		}
+
+		slices.SortFunc(c.Standings, func(a, b Standing) int {
+			return cmp.Or(
+				cmp.Compare(a.Failed, b.Failed),
+				cmp.Compare(b.AvgWPM, a.AvgWPM),
+				cmp.Compare(b.AvgAccuracy, a.AvgAccuracy),
+				cmp.Compare(a.Player, b.Player))
+		})
+		challenges = append(challenges, *c)
+	}
+
+	slices.SortFunc(challenges, func(a, b ChallengeResults) int {
+		return cmp.Or(
+			cmp.Compare(a.ID, b.ID),
+			cmp.Compare(slices.Index(Modes(), a.Mode), slices.Index(Modes(), b.Mode)))
+	})
+	return challenges
+}
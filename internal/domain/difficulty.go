@@ -0,0 +1,241 @@
+package domain
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/random"
+	"go.uber.org/zap"
+)
+
+// Difficulty is a level of difficulty of prompts.
+type Difficulty string
+
+const (
+	// DifficultyEasy uses short, common words.
+	DifficultyEasy Difficulty = "easy"
+	// DifficultyNormal uses any words in lowercase. It is the default.
+	DifficultyNormal Difficulty = "normal"
+	// DifficultyHard uses longer, rarer words, some capitalized or
+	// punctuated.
+	DifficultyHard Difficulty = "hard"
+	// DifficultyExpert uses long, rare words, often capitalized, joined into
+	// camel case identifiers or surrounded by symbols of code.
+	DifficultyExpert Difficulty = "expert"
+)
+
+const (
+	// difficultyOversampling is the factor of tokens sampled beyond those
+	// needed for a prompt, to pick the ones fitting its difficulty.
+	difficultyOversampling = 4
+
+	// metaTokenLengths is the metadata key recording the range of token
+	// lengths indexed in the database. Databases cached by earlier versions
+	// lack the longer tokens of hard prompts.
+	metaTokenLengths = "token_lengths"
+)
+
+// difficultySettings are the properties of prompts of a difficulty.
+type difficultySettings struct {
+	// minLen and maxLen bound the length of tokens in characters.
+	minLen, maxLen int
+	// rarity favors rare tokens if positive and common tokens if negative.
+	rarity int
+	// capitalized is the share of words capitalized.
+	capitalized float64
+	// camelCase is the share of words joined with the next one in camel
+	// case.
+	camelCase float64
+	// punctuated is the share of words decorated with symbols.
+	punctuated float64
+	// symbols are the patterns of decorated words.
+	symbols []string
+}
+
+// hardSymbols are the patterns of punctuated words of hard prompts.
+var hardSymbols = []string{"%s,", "%s.", "%s;", "%s:", "(%s)"}
+
+// difficulties are the settings of each difficulty.
+var difficulties = map[Difficulty]difficultySettings{
+	DifficultyEasy:   {minLen: minTokenLen + 1, maxLen: 6, rarity: -1},
+	DifficultyNormal: {minLen: minTokenLen + 1, maxLen: 11},
+	DifficultyHard: {
+		minLen: 5, maxLen: 15, rarity: 1,
+		capitalized: 0.25, punctuated: 0.15, symbols: hardSymbols,
+	},
+	DifficultyExpert: {
+		minLen: 6, maxLen: maxTokenLen - 1, rarity: 1,
+		capitalized: 0.3, camelCase: 0.3, punctuated: 0.35,
+		symbols: append(slices.Clone(hardSymbols),
+			"%s()", "[%s]", "{%s}", `"%s"`, "'%s'", "<%s>", "!%s", "&%s",
+			"*%s", "#%s", "$%s", "%s?", "%s=", "%s->", "%s[]"),
+	},
+}
+
+// PromptSymbols are the characters other than letters and spaces that
+// prompts may contain, depending on their difficulty.
+var PromptSymbols = func() string {
+	var symbols []rune
+	for _, d := range difficulties {
+		for _, pattern := range d.symbols {
+			for _, c := range strings.ReplaceAll(pattern, "%s", "") {
+				symbols = append(symbols, c)
+			}
+		}
+	}
+	slices.Sort(symbols)
+	return string(slices.Compact(symbols))
+}()
+
+// Difficulties returns all difficulties, from the easiest.
+func Difficulties() []Difficulty {
+	return []Difficulty{
+		DifficultyEasy, DifficultyNormal, DifficultyHard, DifficultyExpert,
+	}
+}
+
+// ParseDifficulty returns the difficulty of the specified name, e.g. "hard".
+func ParseDifficulty(name string) (Difficulty, error) {
+	d := Difficulty(strings.ToLower(name))
+	if _, ok := difficulties[d]; !ok {
+		zap.S().Errorw("Unknown difficulty",
+			"difficulty", name)
+		return "", fmt.Errorf("%w: unknown difficulty %q", ErrInvalidPromptOptions, name)
+	}
+	return d, nil
+}
+
+// orNormal returns the difficulty, or DifficultyNormal if it is unset.
+func (d Difficulty) orNormal() Difficulty {
+	if d == "" {
+		return DifficultyNormal
+	}
+	return d
+}
+
+// Next returns the next harder difficulty, wrapping around to the easiest.
+func (d Difficulty) Next() Difficulty {
+	all := Difficulties()
+	return all[(slices.Index(all, d.orNormal())+1)%len(all)]
+}
+
+// HasSymbols reports whether prompts of the difficulty may contain
+// PromptSymbols.
+func (d Difficulty) HasSymbols() bool {
+	return len(difficulties[d.orNormal()].symbols) > 0
+}
+
+// settings returns the settings of the difficulty.
+func (d Difficulty) settings() difficultySettings {
+	return difficulties[d.orNormal()]
+}
+
+// fits returns the tokens within the length bounds of the difficulty. All
+// tokens are returned if none fit, as a prompt off the difficulty beats none.
+func (s difficultySettings) fits(tokens []string) []string {
+	fitting := slices.DeleteFunc(slices.Clone(tokens), func(t string) bool {
+		n := utf8.RuneCountInString(t)
+		return n < s.minLen || n > s.maxLen
+	})
+	if len(fitting) == 0 {
+		return tokens
+	}
+	return fitting
+}
+
+// applyTokenLengths makes sure the database indexes tokens of all lengths
+// used by the difficulties. Databases holding files indexed with another
+// range are cleared, so that the files are tokenized again rather than
+// skipped as unchanged.
+func applyTokenLengths(store *data.Store, dirPath string) error {
+	lengths := fmt.Sprintf("%d-%d", minTokenLen+1, maxTokenLen-1)
+	stored, _, err := store.GetMeta(metaTokenLengths)
+	if err != nil || stored == lengths {
+		return err
+	}
+
+	files, err := store.GetFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		zap.S().Infow("Token lengths changed, rebuilding database",
+			"dir_path", dirPath,
+			"previous_lengths", stored,
+			"lengths", lengths)
+		if err := store.Clear(); err != nil {
+			return err
+		}
+	}
+	return store.SetMeta(metaTokenLengths, lengths)
+}
+
+// byRarity orders the tokens by the number of files of all sources
+// containing them, from the rarest if rarity is positive or from the most
+// common otherwise. The order of tokens is kept among equal counts.
+func (e *Engine) byRarity(tokens []string, rarity int) ([]string, error) {
+	counts := map[string]int{}
+	for _, src := range e.sources {
+		srcCounts, err := src.store.CountTokens(tokens)
+		if err != nil {
+			return nil, err
+		}
+		for t, n := range srcCounts {
+			counts[t] += n
+		}
+	}
+
+	sorted := slices.Clone(tokens)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return rarity * cmp.Compare(counts[a], counts[b])
+	})
+	return sorted, nil
+}
+
+// decorate turns the shuffled tokens into the words of a prompt of the
+// difficulty, capitalizing, joining and punctuating them by chance. Tokens
+// are returned as they are for difficulties without decoration, without
+// drawing randomness from r.
+func (s difficultySettings) decorate(tokens []string, r *rand.Rand) []string {
+	if s.capitalized == 0 && s.camelCase == 0 && s.punctuated == 0 {
+		return tokens
+	}
+
+	var words []string
+	for i := 0; i < len(tokens); i++ {
+		word := tokens[i]
+		if chance(r, s.capitalized) {
+			word = capitalize(word)
+		}
+		if i+1 < len(tokens) && chance(r, s.camelCase) {
+			i++
+			word += capitalize(tokens[i])
+		}
+		if chance(r, s.punctuated) {
+			word = fmt.Sprintf(s.symbols[random.IntN(r, len(s.symbols))], word)
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// chance returns true with probability p, drawing randomness from r. No
+// randomness is drawn if p is zero.
+func chance(r *rand.Rand, p float64) bool {
+	if p == 0 {
+		return false
+	}
+	return float64(random.IntN(r, 1000)) < p*1000
+}
+
+// capitalize returns the word with its first letter in uppercase.
+func capitalize(word string) string {
+	first, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(first)) + word[size:]
+}
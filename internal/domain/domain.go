@@ -3,15 +3,22 @@ package domain
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
 	"math"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/alphabet"
 	"github.com/vupdivup/typomat/pkg/files"
 	"github.com/vupdivup/typomat/pkg/git"
 	"github.com/vupdivup/typomat/pkg/random"
@@ -20,20 +27,11 @@ import (
 	"go.uber.org/zap"
 )
 
-var (
-	// prompts is a channel for delivering generated prompts.
-	prompts chan fetchResult = make(chan fetchResult, promptBuf-1)
-
-	// progress indicates the progress of directory processing.
-	progress float64
-
-	// ctx is the domain-level context for managing graceful shutdowns.
-	ctx context.Context
-	// cancel is the cancel function for the domain-level context.
-	cancel context.CancelFunc
-)
-
 const (
+	// DefaultPromptLen is the default maximum length of a prompt in
+	// characters.
+	DefaultPromptLen = 128
+
 	// promptBuf is the size of the prompt buffer channel.
 	promptBuf = 3
 
@@ -43,8 +41,10 @@ const (
 
 	// minTokenLen is the minimum length of a token to be included.
 	minTokenLen = 2
-	// maxTokenLen is the maximum length of a token to be included.
-	maxTokenLen = 12
+	// maxTokenLen is the maximum length of a token to be included. Tokens
+	// are indexed up to the length of the hardest difficulty, shorter ones
+	// are picked per difficulty when building prompts.
+	maxTokenLen = 20
 
 	// maxWordLen is the maximum length of a word to be considered for
 	// tokenization.
@@ -54,9 +54,22 @@ const (
 	// the database.
 	tokenBufferSize = 10000
 
-	// maxErrors is the maximum number of errors allowed during file
-	// processing before aborting.
-	maxErrors = 16
+	// maxRandomSeed bounds randomly chosen seeds, keeping them short enough to
+	// share.
+	maxRandomSeed = 1_000_000
+
+	// defaultMaxErrors is the default number of failed files at which
+	// directory processing is aborted, see ErrorOptions.
+	defaultMaxErrors = 16
+
+	// metaIndexState is the metadata key recording the state of the last
+	// directory processing run.
+	metaIndexState = "index_state"
+	// indexStateInProgress marks a directory processing run that has started
+	// but not yet completed.
+	indexStateInProgress = "in_progress"
+	// indexStateComplete marks a completed directory processing run.
+	indexStateComplete = "complete"
 )
 
 // FileStatus represents the status of a file with respect to the database.
@@ -74,6 +87,98 @@ const (
 	FileStatusIneligible
 )
 
+// Options configures the sources of practice text.
+type Options struct {
+	// Cache enables storing processed data for subsequent runs.
+	Cache bool
+	// Stdin is the reader of the standard input source, see StdinPath. If
+	// nil, os.Stdin is used.
+	Stdin io.Reader
+	// Seed, if set, seeds prompt generation. Sessions with equal seeds and
+	// sources produce the same sequence of prompts, also when reconfigured
+	// after the same rounds. Otherwise, a random seed is used.
+	Seed *uint64
+	// Weights, if set, are the relative weights of the sources in prompts,
+	// in the order of the source paths. Otherwise, prompts are sampled from
+	// the union of all sources.
+	Weights []float64
+	// Filter restricts the files used as a source.
+	Filter Filter
+	// Errors controls how files that fail to process are handled.
+	Errors ErrorOptions
+	// History, if set, limits the source to files changed in the directory's
+	// Git history. See ProcessHistory.
+	History *HistoryOptions
+	// Challenge, if set, makes the session a daily challenge. Its seed takes
+	// precedence over Seed and results are recorded for comparison, see
+	// RecordResult.
+	Challenge *Challenge
+	// Watch keeps the tokens of directory sources in sync with changes to
+	// their files until the engine is closed. Prompts then depend on the
+	// changes, so seeded sessions are not reproducible. Watched directories
+	// are walked rather than listed from the Git index, so new files are
+	// included before they are added to the repository. Ignored for history
+	// sources.
+	Watch bool
+	// Drill, if set, biases prompts toward tokens typed with specific
+	// fingers, see PromptOptions.
+	Drill *Drill
+	// Review mixes words due for review into prompts, see PromptOptions.
+	Review bool
+	// Difficulty is the difficulty of prompts, see PromptOptions.
+	Difficulty Difficulty
+	// Markov strings the words of prompts into sequences as they occur in the
+	// sources, see PromptOptions.
+	Markov bool
+	// Mode is how strictly mistakes are handled while typing the prompts.
+	// Empty means ModeNormal. Rounds record it, see Round.
+	Mode Mode
+}
+
+// sourceKind is the kind of a source of practice text.
+type sourceKind int
+
+const (
+	// sourceDir is a directory source.
+	sourceDir sourceKind = iota
+	// sourceFile is a single file source.
+	sourceFile
+	// sourceStdin is the standard input source.
+	sourceStdin
+)
+
+// Round is a prompt to be typed, along with what is needed to reproduce it.
+type Round struct {
+	// Prompt is the text to be typed.
+	Prompt string
+	// Seed is the seed of the session the round belongs to.
+	Seed uint64
+	// Number is the position of the round within the session, starting at 1.
+	Number int
+	// Challenge is the ID of the daily challenge the round belongs to, if any.
+	Challenge string
+	// Difficulty is the difficulty the prompt was generated with.
+	Difficulty Difficulty
+	// Mode is how strictly mistakes are handled while typing the prompt.
+	Mode Mode
+	// Reviews are the words of the prompt put into it for being due for
+	// review. Only they are graded as reviews, see RecordWords.
+	Reviews []string
+}
+
+// source is a processed source of practice text.
+type source struct {
+	// path is the absolute path of the source, or StdinPath.
+	path string
+	// kind is the kind of the source.
+	kind sourceKind
+	// store is the database holding the tokens of the source.
+	store *data.Store
+	// weight is the relative weight of the source in prompts. Zero if
+	// sources are not weighted.
+	weight float64
+}
+
 // fileProcessingResult encapsulates the result of processing a file.
 type fileProcessingResult struct {
 	// file is the processed file metadata.
@@ -83,124 +188,462 @@ type fileProcessingResult struct {
 	// tokens are the unique tokens extracted from the file. Empty if file
 	// is unchanged or ineligible.
 	tokens []data.Token
+	// transitions are the token transitions of the file. Empty if file is
+	// unchanged or ineligible.
+	transitions []data.Transition
 	// err is any error encountered during processing.
 	err error
+	// warning is any problem that did not stop the file from being
+	// processed, e.g. lines skipped for not being valid UTF-8.
+	warning error
+}
+
+// Engine builds a corpus of practice text from sources and generates prompts
+// from it. Each engine owns its databases, context and prompt pipeline, so
+// several engines can be used side by side.
+//
+// An engine processes its sources once, see Setup, and should be closed when
+// no longer needed.
+type Engine struct {
+	// prompts is a channel for delivering generated prompts.
+	prompts chan fetchResult
+	// producer tracks the prompt producer goroutine.
+	producer sync.WaitGroup
+	// reconfigured signals the producer that the prompt options changed.
+	reconfigured chan struct{}
+
+	// promptMu guards the prompt options, round count, reviewed words and
+	// results database, which are changed while prompts are being generated.
+	promptMu sync.Mutex
+	// promptOpts are the options of generated prompts.
+	promptOpts PromptOptions
+	// generation counts changes of the prompt options. Prompts of earlier
+	// generations are discarded.
+	generation int
+	// generationRounds is the number of rounds returned by Prompt before the
+	// current generation.
+	generationRounds int
+	// rounds is the number of rounds returned by Prompt.
+	rounds int
+	// reviewed are the times words due for review were last put into a
+	// prompt.
+	reviewed map[string]time.Time
+	// watchers track the goroutines keeping watched directories in sync.
+	watchers sync.WaitGroup
+	// untracked lists files of directories by walking them rather than from
+	// the Git index, so that files not yet added to a repository are
+	// included. Set for watched directories, whose new files are untracked.
+	untracked bool
+
+	// seed is the seed of the random source of the session.
+	seed uint64
+	// mode is the mode rounds are typed in.
+	mode Mode
+
+	// sources are the sources prompts are generated from.
+	sources []source
+
+	// challenge is the daily challenge of the session, if any.
+	challenge *Challenge
+	// resultStore is the database the results of the daily challenge and
+	// key stats are recorded in. Opened on first use, except for daily
+	// challenges.
+	resultStore *data.ResultStore
+
+	// mu guards the progress fields, which are read and subscribed to while
+	// sources are being processed.
+	mu sync.Mutex
+	// state is the current progress of source processing.
+	state ProgressEvent
+	// tokenizeStart is the time tokenizing the current source started, used
+	// to estimate the remaining time.
+	tokenizeStart time.Time
+	// subscribers are the channels progress events are published to.
+	subscribers []chan ProgressEvent
+	// closed indicates that the engine is closed and publishes no more
+	// progress events.
+	closed bool
+	// failures are the files that failed to process during Setup.
+	failures []FileFailure
+	// warnings are the files processed only in part during Setup.
+	warnings []FileFailure
+
+	// ctx is the engine-level context for managing graceful shutdowns.
+	ctx context.Context
+	// cancel is the cancel function for the engine-level context.
+	cancel context.CancelFunc
 }
 
-func init() {
-	ctx, cancel = context.WithCancel(context.Background())
+// NewEngine creates an engine without sources.
+func NewEngine() *Engine {
+	e := &Engine{reconfigured: make(chan struct{}, 1)}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e
 }
 
-// Setup initializes the domain package with the specified directory path
-// and maximum prompt length.
+// Setup processes the specified sources and starts generating prompts of the
+// specified maximum length with the specified options. Sources are
+// directories, single text files or StdinPath for standard input. Each source
+// is processed into a database of its own. Directories are cached separately,
+// while files and standard input use temporary databases.
 //
-// This function should be called once at application startup.
-// Subsequent calls have no effect.
-func Setup(dirPath string, cache bool, maxLen int) error {
-	// Check if directory exists
-	dirExists, err := files.DirExists(dirPath)
-	if err != nil {
-		zap.S().Errorw("Failed to check if directory exists",
-			"dir_path", dirPath,
+// Setup should be called once per engine.
+func (e *Engine) Setup(paths []string, opts Options, maxLen int) error {
+	e.promptOpts = PromptOptions{
+		MaxLen: maxLen, Drill: opts.Drill, Review: opts.Review,
+		Difficulty: opts.Difficulty, Markov: opts.Markov,
+	}
+	if err := e.promptOpts.validate(); err != nil {
+		return err
+	}
+	e.mode = opts.Mode.orNormal()
+	if !slices.Contains(Modes(), e.mode) {
+		zap.S().Errorw("Unknown mode",
+			"mode", opts.Mode)
+		return ErrInvalidMode
+	}
+	if len(opts.Weights) > 0 {
+		if err := validateWeights(opts.Weights, len(paths)); err != nil {
+			return err
+		}
+	}
+
+	// Validate all sources before processing any
+	e.startSources(len(paths))
+	srcs := make([]source, len(paths))
+	for i, path := range paths {
+		src, err := resolveSource(path)
+		if err != nil {
+			return err
+		}
+		if src.kind == sourceStdin && i > slices.Index(paths, StdinPath) {
+			zap.S().Errorw("Standard input can only be used as a source once")
+			return ErrInvalidDirPath
+		}
+		if len(opts.Weights) > 0 {
+			src.weight = opts.Weights[i]
+		}
+		srcs[i] = src
+	}
+
+	e.untracked = opts.Watch && opts.History == nil
+	for _, src := range srcs {
+		e.beginSource(src.path)
+		store, err := e.setupSource(src, opts)
+		if store != nil {
+			src.store = store
+			e.sources = append(e.sources, src)
+		}
+		if err != nil {
+			return err
+		}
+
+		e.finishSource()
+	}
+
+	// Keep directories in sync with their files
+	if opts.Watch && opts.History == nil {
+		for _, src := range e.sources {
+			if src.kind != sourceDir {
+				continue
+			}
+			if err := e.startWatching(src, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Reviews are read while generating prompts, so open their database
+	// before starting the producer
+	if opts.Review || opts.Challenge != nil {
+		if _, err := e.results(); err != nil {
+			return err
+		}
+	}
+
+	// Seed prompt generation
+	e.challenge = opts.Challenge
+	if e.challenge != nil {
+		e.seed = e.challenge.Seed
+	} else if opts.Seed != nil {
+		e.seed = *opts.Seed
+	} else {
+		e.seed = rand.Uint64N(maxRandomSeed)
+	}
+	zap.S().Infow("Seeded prompt generation",
+		"seed", e.seed)
+
+	// Start prompt producer
+	e.prompts = make(chan fetchResult, promptBuf-1)
+	e.producer.Go(e.produce)
+
+	return nil
+}
+
+// resolveSource checks that the source exists and determines its kind and
+// absolute path.
+func resolveSource(path string) (source, error) {
+	if path == StdinPath {
+		return source{path: StdinPath, kind: sourceStdin}, nil
+	}
+
+	// Check if source exists
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		zap.S().Errorw("Source does not exist",
+			"path", path)
+		return source{}, ErrInvalidDirPath
+	} else if err != nil {
+		zap.S().Errorw("Failed to check if source exists",
+			"path", path,
 			"error", err)
-		return ErrFileOperation
+		return source{}, ErrFileOperation
 	}
-	if !dirExists {
-		zap.S().Errorw("Directory does not exist",
-			"dir_path", dirPath)
-		return ErrInvalidDirPath
+
+	kind := sourceDir
+	if !stat.IsDir() {
+		if !stat.Mode().IsRegular() {
+			zap.S().Errorw("Source is neither a directory nor a regular file",
+				"path", path)
+			return source{}, ErrInvalidDirPath
+		}
+		kind = sourceFile
 	}
 
 	// Normalize path
-	absPath, err := filepath.Abs(dirPath)
+	absPath, err := filepath.Abs(path)
 	if err != nil {
 		zap.S().Errorw("Failed to get absolute path",
-			"dir_path", dirPath,
+			"path", path,
 			"error", err)
-		return ErrInvalidDirPath
+		return source{}, ErrInvalidDirPath
+	}
+
+	return source{path: absPath, kind: kind}, nil
+}
+
+// setupSource opens the database of a source and processes the source into
+// it. The store is returned even if processing fails, so that it can be
+// closed on teardown.
+func (e *Engine) setupSource(src source, opts Options) (*data.Store, error) {
+	switch src.kind {
+	case sourceFile, sourceStdin:
+		// Files and standard input are cheap to process and not cached
+		store, err := data.Open(src.kind.dbIdPrefix()+src.path, false)
+		if err != nil {
+			zap.S().Errorw("Failed to setup database",
+				"path", src.path,
+				"error", err)
+			return nil, err
+		}
+
+		if src.kind == sourceStdin {
+			stdin := opts.Stdin
+			if stdin == nil {
+				stdin = os.Stdin
+			}
+			return store, e.ProcessReader(
+				store, StdinPath, stdin, opts.Filter.Alphabet)
+		}
+		return store, e.ProcessFile(store, src.path, opts.Filter.Alphabet)
+	}
+
+	absPath := src.path
+	if opts.History != nil {
+		// History selections move with every commit, so they are not cached
+		if opts.Cache {
+			zap.S().Warnw("Caching is not supported for history sources",
+				"dir_path", absPath)
+		}
+		dbId, err := opts.History.dbId(absPath, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		store, err := data.Open(dbId, false)
+		if err != nil {
+			zap.S().Errorw("Failed to setup database",
+				"dir_path", absPath,
+				"error", err)
+			return nil, err
+		}
+
+		return store, e.ProcessHistory(store, absPath, *opts.History, opts.Filter)
 	}
 
 	// Setup database
-	if err := data.Setup(absPath, cache); err != nil {
+	dbId, err := dirDbId(absPath, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	store, err := data.Open(dbId, opts.Cache)
+	if err != nil {
 		zap.S().Errorw("Failed to setup database",
 			"dir_path", absPath,
 			"error", err)
-		return err
+		return nil, err
 	}
 
 	// Tokenize directory
-	if err := ProcessDirectory(absPath); err != nil {
-		return err
+	summary, err := e.IndexDirectory(store, absPath, opts.Filter, opts.Errors)
+	e.addFailures(summary.Failures)
+	e.addWarnings(summary.Warnings)
+	return store, err
+}
+
+// dbIdPrefix returns the prefix of database identifiers of sources of the
+// kind, keeping them apart from directories of the same path.
+func (k sourceKind) dbIdPrefix() string {
+	switch k {
+	case sourceFile:
+		return "file\x00"
+	case sourceStdin:
+		return "stdin\x00"
+	default:
+		return ""
 	}
+}
 
-	// Start prompt producer
-	go produce(maxLen)
+// validateWeights checks that there is a non-negative weight for each source
+// and that at least one of them is positive.
+func validateWeights(weights []float64, numSources int) error {
+	if len(weights) != numSources {
+		zap.S().Errorw("Number of weights does not match number of sources",
+			"weight_count", len(weights),
+			"source_count", numSources)
+		return ErrInvalidWeights
+	}
+
+	total := 0.0
+	for _, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			zap.S().Errorw("Invalid source weight",
+				"weight", w)
+			return ErrInvalidWeights
+		}
+		total += w
+	}
+	if total == 0 {
+		zap.S().Errorw("All source weights are zero")
+		return ErrInvalidWeights
+	}
 
 	return nil
 }
 
-// Prompt generates a prompt of the maximum specified character length
-// from tokens of the specified directory. This is the main entry point of the
-// domain package.
+// Prompt returns the next prompt generated from the tokens of the engine's
+// sources, following the current prompt options, see Reconfigure. This is the
+// main entry point of an engine. ErrClosed is returned once the engine is
+// closed.
 //
-// The function pools prompts in the background for efficiency.
+// The engine pools prompts in the background for efficiency.
 //
 // If the TYPOMAT_PROMPT environment variable is set, its value is used
 // directly as the prompt, bypassing text generation.
-func Prompt() (string, error) {
+func (e *Engine) Prompt() (Round, error) {
 	// Check for prompt override via environment variable
 	if envPrompt := os.Getenv("TYPOMAT_PROMPT"); envPrompt != "" {
 		zap.S().Debugw("Using TYPOMAT_PROMPT environment variable as prompt")
-		return envPrompt, nil
+		return Round{Prompt: envPrompt, Seed: e.seed, Mode: e.mode}, nil
 	}
 
 	zap.S().Debugw("Generating prompt from directory text content")
 
-	result := <-prompts
-	return result.prompt, result.err
-}
+	for {
+		if e.ctx.Err() != nil {
+			return Round{}, ErrClosed
+		}
 
-// Progress returns the current progress of directory processing as a float
-// between 0 and 1.
-func Progress() float64 {
-	return progress
-}
+		var result fetchResult
+		select {
+		case result = <-e.prompts:
+		case <-e.ctx.Done():
+			return Round{}, ErrClosed
+		}
 
-// fetchResult encapsulates the result of a prompt generation.
-type fetchResult struct {
-	// prompt is the generated prompt string.
-	prompt string
-	// err is any error encountered during prompt generation.
-	err error
+		e.promptMu.Lock()
+		if result.generation != e.generation {
+			// Generated with options changed since
+			e.promptMu.Unlock()
+			continue
+		}
+		if result.err == nil {
+			e.rounds++
+			result.round.Number = e.rounds
+			result.round.Mode = e.mode
+		}
+		e.promptMu.Unlock()
+
+		// Only reviews handed out count as shown, not those of dropped prompts
+		e.markReviewed(result.round.Reviews)
+		return result.round, result.err
+	}
 }
 
-// produce continuously generates prompts and sends them to the prompts channel.
-// Run as a goroutine.
+// ProcessDirectory tokenizes all eligible files in the specified directory
+// that pass the filter and stores the tokens in the store.
+// It returns ErrTooManyErrors once the number of files failing to process
+// reaches the limit of the error options, unless they are skipped.
 //
-// In case of an error during prompt generation, the error is sent through the
-// channel and the goroutine exits.
-func produce(maxLen int) {
-	for {
-		prompt, err := generatePrompt(maxLen)
-		if err != nil {
-			zap.S().Errorw("Failed to generate prompt",
-				"error", err)
-			prompts <- fetchResult{prompt: "", err: err}
-			return
-		}
-		zap.S().Debugw("Generated new prompt",
-			"max_len", maxLen,
-			"prompt", prompt)
+// The filter is recorded in the database. Files stored with a different
+// filter are discarded, so a cached database never mixes both selections.
+// Stores opened by the ID of the directory and filter, see dirDbId, only
+// hold files of that filter.
+//
+// The database is locked for the duration of processing, so instances sharing
+// a cached database take turns rather than interleaving their changes.
+func (e *Engine) ProcessDirectory(
+	store *data.Store, dirPath string, filter Filter, errOpts ErrorOptions,
+) error {
+	_, err := e.IndexDirectory(store, dirPath, filter, errOpts)
+	return err
+}
 
-		prompts <- fetchResult{prompt: prompt, err: nil}
+// listFiles lists the files of the directory at the specified path, see
+// Engine.untracked.
+func (e *Engine) listFiles(dirPath string) ([]string, error) {
+	if e.untracked {
+		return git.WalkFiles(dirPath)
 	}
+	return git.LsFiles(dirPath)
 }
 
-// ProcessDirectory tokenizes all eligible files in the specified directory
-// and stores the tokens in the database.
-// It returns an error if a set number of errors occur during processing.
-func ProcessDirectory(dirPath string) error {
+// IndexDirectory works like ProcessDirectory and additionally returns a
+// summary of the changes made to the store, including the files that failed
+// to process. The summary covers the work done up to an error, if any.
+func (e *Engine) IndexDirectory(
+	store *data.Store, dirPath string, filter Filter, errOpts ErrorOptions,
+) (summary IndexSummary, err error) {
+	start := time.Now()
+	defer func() {
+		summary.Elapsed = time.Since(start)
+	}()
+
+	matcher, err := filter.compile(dirPath)
+	if err != nil {
+		return summary, err
+	}
+	signature, err := filter.signature()
+	if err != nil {
+		return summary, err
+	}
+
+	if err := store.Lock(); err != nil {
+		return summary, err
+	}
+	defer store.Unlock() // nolint:errcheck
+
+	if err := applyFilterSignature(store, dirPath, signature); err != nil {
+		return summary, err
+	}
+	if err := applyTransitions(store, dirPath); err != nil {
+		return summary, err
+	}
+	if err := applyTokenLengths(store, dirPath); err != nil {
+		return summary, err
+	}
+
 	var tokens []data.Token
+	var transitions []data.Transition
 	var changedFiles []data.File
 	var newFiles []data.File
 
@@ -208,59 +651,64 @@ func ProcessDirectory(dirPath string) error {
 	removedFiles := make(map[string]data.File)
 
 	// Populate DB file lookup and removed files lookup
-	dbFilesTmp, err := data.GetFiles()
+	dbFilesTmp, err := store.GetFiles()
 	if err != nil {
-		return err
+		return summary, err
 	}
 	for _, dbFile := range dbFilesTmp {
 		dbFiles[dbFile.Path] = dbFile
 		removedFiles[dbFile.Path] = dbFile
 	}
 
+	// flushTokens saves buffered files along with their tokens. Each flush is
+	// atomic, so an interrupted run leaves every recorded file complete and
+	// the next run resumes with the files that were not yet flushed.
 	flushTokens := func() error {
-		// Delete tokens of changed files for subsequent re-insertion
-		for _, file := range changedFiles {
-			if err := data.DeleteTokensOfFile(file.Path); err != nil {
-				return err
-			}
-		}
-
-		// Update changed files
-		if err := data.UpsertFiles(changedFiles); err != nil {
-			return err
-		}
-
-		// Upload new files
-		if err := data.UpsertFiles(newFiles); err != nil {
-			return err
-		}
-
-		// Flush tokens to database
-		if err := data.UpsertTokens(tokens); err != nil {
+		if err := store.SaveFiles(
+			slices.Concat(changedFiles, newFiles), tokens, transitions); err != nil {
 			return err
 		}
+		summary.Tokens += len(tokens)
 
 		changedFiles = nil
 		newFiles = nil
 		tokens = nil
+		transitions = nil
 		return nil
 	}
 
+	// Mark the run as in progress until it completes
+	state, _, err := store.GetMeta(metaIndexState)
+	if err != nil {
+		return summary, err
+	}
+	if state == indexStateInProgress {
+		zap.S().Infow("Resuming interrupted directory processing",
+			"dir_path", dirPath)
+	}
+	if err := store.SetMeta(metaIndexState, indexStateInProgress); err != nil {
+		return summary, err
+	}
+
 	// Get files in directory, recursively
-	paths, err := git.LsFiles(dirPath)
+	e.beginPhase(PhaseListing, 0)
+	paths, err := e.listFiles(dirPath)
 	if err != nil {
 		zap.S().Errorw("Failed to list files in directory",
 			"dir_path", dirPath,
 			"error", err)
-		return ErrFileOperation
+		return summary, ErrFileOperation
 	}
+	paths = matcher.filterPaths(paths)
+	summary.Files = len(paths)
 	if len(paths) == 0 {
 		zap.S().Errorw("No files found in directory",
 			"dir_path", dirPath)
-		return ErrEmptyDir
+		return summary, ErrEmptyDir
 	}
 
 	// Prepare for concurrent processing
+	e.beginPhase(PhaseTokenizing, len(paths))
 	maxWorkers := runtime.NumCPU()
 	// Use less workers if there are fewer files than CPUs
 	// Also ensure at least one batch
@@ -272,13 +720,13 @@ func ProcessDirectory(dirPath string) error {
 		"workers", maxWorkers)
 
 	// Process files concurrently
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := context.WithCancel(e.ctx)
 	defer cancel()
 	var wg sync.WaitGroup
 	results := make(chan fileProcessingResult)
 	for batch := range batches {
 		wg.Go(func() {
-			processFileBatch(batch, dbFiles, results, ctx)
+			processFileBatch(batch, dbFiles, filter.Alphabet, results, ctx)
 		})
 	}
 
@@ -289,25 +737,33 @@ func ProcessDirectory(dirPath string) error {
 	}()
 
 	// Receive file processed signals and flush tokens as needed
-	numErrs := 0
-	processed := 0
 	for result := range results {
-		// Update progress
-		processed++
-		progress = float64(processed) / float64(len(paths))
+		e.advance(result.file.Path)
 
-		// Check for processing error, abort if exceeding max allowed
+		// Check for processing error, abort if reaching the limit
 		if result.err != nil {
-			numErrs++
-			if numErrs >= maxErrors {
+			summary.Errors++
+			summary.Failures = append(summary.Failures,
+				newFileFailure(result.file.Path, result.err))
+			if !errOpts.Skip && summary.Errors >= errOpts.limit() {
 				cancel()
 				zap.S().Errorw("Too many errors during directory processing, aborting",
 					"dir_path", dirPath,
-					"max_errors", maxErrors)
-				return ErrTooManyErrors
+					"max_errors", errOpts.limit())
+
+				// Keep the work done so far for the next run
+				if err := flushTokens(); err != nil {
+					return summary, err
+				}
+				return summary, ErrTooManyErrors
 			}
 		}
 
+		if result.warning != nil {
+			summary.Warnings = append(summary.Warnings,
+				newFileFailure(result.file.Path, result.warning))
+		}
+
 		// File still exists, remove from removed files lookup
 		// Ineligible files are kept for deletion in case they were previously
 		// tokenized
@@ -315,6 +771,9 @@ func ProcessDirectory(dirPath string) error {
 			delete(removedFiles, result.file.Path)
 		}
 
+		if result.err == nil {
+			summary.count(result.status)
+		}
 		switch result.status {
 		case FileStatusIneligible:
 			zap.S().Debugw("Skipping ineligible file",
@@ -338,41 +797,47 @@ func ProcessDirectory(dirPath string) error {
 
 		// Add received tokens to token buffer and flush if needed
 		tokens = append(tokens, result.tokens...)
-		if len(tokens) >= tokenBufferSize {
+		transitions = append(transitions, result.transitions...)
+		if len(tokens)+len(transitions) >= tokenBufferSize {
 			if err := flushTokens(); err != nil {
-				return err
+				return summary, err
 			}
 		}
 	}
 
 	// Flush remaining tokens
+	e.beginPhase(PhaseFlushing, 0)
 	if err := flushTokens(); err != nil {
-		return err
+		return summary, err
 	}
 
 	// Delete tokens and entries of files that don't exist anymore
+	e.beginPhase(PhaseCleaningUp, 0)
 	for _, file := range removedFiles {
 		zap.S().Debugw("Deleting removed file from database",
 			"file_path", file.Path)
-		if err := data.DeleteFile(file, true); err != nil {
-			return err
+		if err := store.DeleteFile(file, true); err != nil {
+			return summary, err
 		}
+		summary.Removed++
 	}
 
-	progress = 100
+	if err := store.SetMeta(metaIndexState, indexStateComplete); err != nil {
+		return summary, err
+	}
 
-	return nil
+	return summary, nil
 }
 
 // processFileBatch processes a batch of files and sends the results to the
 // provided channel.
 func processFileBatch(
-	paths []string, dbFiles map[string]data.File,
+	paths []string, dbFiles map[string]data.File, letters alphabet.Alphabet,
 	results chan fileProcessingResult, ctx context.Context,
 ) {
 	for _, path := range paths {
 		// Process file
-		result := processFile(path, dbFiles)
+		result := processFile(path, dbFiles, letters)
 
 		// Check for context cancellation
 		select {
@@ -385,14 +850,14 @@ func processFileBatch(
 
 // processFile processes a single file and returns the processing results.
 func processFile(
-	path string, dbFiles map[string]data.File,
+	path string, dbFiles map[string]data.File, letters alphabet.Alphabet,
 ) fileProcessingResult {
 	// Exclude unwanted files
 	if isDesired, err := isFileEligible(path); err != nil {
 		zap.S().Errorw("Failed to check if file is eligible",
 			"file_path", path,
 			"error", err)
-		return fileProcessingResult{err: ErrFileOperation}
+		return fileProcessingResult{file: data.File{Path: path}, err: err}
 	} else if !isDesired {
 		return fileProcessingResult{
 			file: data.File{Path: path}, status: FileStatusIneligible,
@@ -405,7 +870,10 @@ func processFile(
 		zap.S().Errorw("Failed to stat file",
 			"file_path", path,
 			"error", err)
-		return fileProcessingResult{err: ErrFileOperation}
+		return fileProcessingResult{
+			file: data.File{Path: path},
+			err:  fmt.Errorf("%w: %w", ErrFileOperation, err),
+		}
 	}
 	size := int(stat.Size())
 	mtime := stat.ModTime()
@@ -429,30 +897,50 @@ func processFile(
 		return fileProcessingResult{file: file, status: fileStatus}
 	}
 
-	// Tokenize file and collect unique tokens
-	uniqueFileTokens, err := getUniqueTokensOfFile(path)
+	// Tokenize file and collect unique tokens and their transitions
+	uniqueFileTokens, transitions, err := getUniqueTokensOfFile(path, letters)
+	var warning error
+	if errors.Is(err, tokenizer.ErrInvalidUTF8) {
+		warning, err = err, nil
+	}
 	if err != nil {
-		return fileProcessingResult{err: err}
+		return fileProcessingResult{file: data.File{Path: path}, err: err}
 	}
 
 	return fileProcessingResult{
-		file: file, tokens: uniqueFileTokens, status: fileStatus,
+		file: file, tokens: uniqueFileTokens, transitions: transitions,
+		status: fileStatus, warning: warning,
 	}
 }
 
 // getUniqueTokensOfFile tokenizes the specified file and returns the unique
-// eligible tokens.
-func getUniqueTokensOfFile(path string) ([]data.Token, error) {
+// eligible tokens, along with their transitions.
+//
+// Lines that are not valid UTF-8 are skipped. The tokens of the other lines
+// are then returned along with an error wrapping tokenizer.ErrInvalidUTF8.
+func getUniqueTokensOfFile(
+	path string, letters alphabet.Alphabet,
+) ([]data.Token, []data.Transition, error) {
 	// Tokenize file
-	allTokens, err := tokenizer.TokenizeFile(path, isWordEligible)
-	if err != nil {
+	allTokens, err := tokenizer.TokenizeFile(path, letters, isWordEligible)
+	if errors.Is(err, tokenizer.ErrInvalidUTF8) {
+		zap.S().Warnw("Skipped lines of file that are not valid UTF-8",
+			"file_path", path,
+			"error", err)
+		err = fmt.Errorf("%w: %w", ErrTextProcessing, err)
+	} else if err != nil {
 		zap.S().Errorw("Failed to tokenize file",
 			"file_path", path,
 			"error", err)
-		return nil, ErrTextProcessing
+		return nil, nil, fmt.Errorf("%w: %w", ErrTextProcessing, err)
 	}
 
-	// Retrieve first occurrence of each token
+	return uniqueTokens(path, allTokens), tokenTransitions(path, allTokens), err
+}
+
+// uniqueTokens returns the first occurrence of each eligible token as
+// extracted from the file at the specified path.
+func uniqueTokens(path string, allTokens []string) []data.Token {
 	uniqueTokens := []data.Token{}
 	lookup := map[string]bool{}
 	for _, fileToken := range allTokens {
@@ -463,34 +951,84 @@ func getUniqueTokensOfFile(path string) ([]data.Token, error) {
 		}
 	}
 
-	return uniqueTokens, nil
+	return uniqueTokens
 }
 
-// generatePrompt creates a prompt of up to maxLen characters by randomly
-// sampling tokens from the database.
-func generatePrompt(maxLen int) (string, error) {
+// generatePrompt creates a prompt following the options by randomly
+// sampling tokens from the database, drawing randomness from r. The words due
+// for review put into the prompt are returned along with it.
+func (e *Engine) generatePrompt(
+	opts PromptOptions, r *rand.Rand,
+) (string, []string, error) {
+	maxLen := opts.MaxLen
+
 	// Estimate max number of words needed to reach maxLen
 	maxWordsNeeded := int(
 		math.Round(float64(maxLen+1) / float64((minTokenLen + 1))))
 
 	// Get random tokens, sample more than needed to account for length cutoff
-	tokenResults := lazy.Sample(data.IterUniqueTokens(), maxWordsNeeded)
-	tokens := []string{}
-	for _, tr := range tokenResults {
-		if tr.Err != nil {
-			return "", tr.Err
+	difficulty := opts.Difficulty.orNormal()
+	sampleSize := maxWordsNeeded * difficultyOversampling
+	if opts.Drill != nil {
+		sampleSize *= drillOversampling
+	}
+	tokens, err := e.sampleTokens(sampleSize, r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Keep the tokens of fitting length and rarity. Normal prompts draw no
+	// further randomness, as their tokens are sampled in random order.
+	settings := difficulty.settings()
+	if difficulty == DifficultyNormal {
+		tokens = settings.fits(tokens)
+	} else {
+		tokens = settings.fits(random.Shuffle(tokens, r))
+		if tokens, err = e.byRarity(tokens, settings.rarity); err != nil {
+			return "", nil, err
 		}
-		tokens = append(tokens, tr.Token.Value)
+	}
+	tokens = tokens[:min(sampleSize/difficultyOversampling, len(tokens))]
+
+	// Keep the tokens most typed with the drilled fingers
+	if opts.Drill != nil {
+		tokens = opts.Drill.pick(random.Shuffle(tokens, r), maxWordsNeeded)
+	}
+
+	// Mix in words due for review, which come first to make the cut
+	var reviews []string
+	if opts.Review {
+		reviews, err = e.dueReviewWords(maxWordsNeeded / reviewShare)
+		if err != nil {
+			// Reviews are a nicety, keep practicing on fresh words
+			zap.S().Warnw("Failed to get words due for review",
+				"error", err)
+		}
+		tokens = slices.DeleteFunc(tokens, func(t string) bool {
+			return slices.Contains(reviews, t)
+		})
 	}
 
 	// Check if any tokens were found
 	if len(tokens) == 0 {
 		zap.S().Errorw("No tokens found in database to generate prompt")
-		return "", ErrNoTokensFound
+		return "", nil, ErrNoTokensFound
 	}
 
-	// Shuffle tokens to ensure randomness
-	shuffled := random.Shuffle(tokens)
+	// Shuffle tokens to ensure randomness, or chain them into sequences
+	// starting with the shuffled tokens
+	ordered := random.Shuffle(tokens, r)
+	if opts.Markov {
+		if ordered, err = e.chainTokens(ordered, maxWordsNeeded, r); err != nil {
+			return "", nil, err
+		}
+	}
+	// Review words are decorated one by one, so each stays a word of its own
+	var shuffled []string
+	for _, review := range reviews {
+		shuffled = append(shuffled, settings.decorate([]string{review}, r)...)
+	}
+	shuffled = append(shuffled, settings.decorate(ordered, r)...)
 
 	// Select tokens in shuffle order until reaching maxLen
 	promptLen := 0
@@ -508,7 +1046,99 @@ func generatePrompt(maxLen int) (string, error) {
 		promptTokens = append(promptTokens, token)
 	}
 
-	return strings.Join(promptTokens, " "), nil
+	// Spread the review words across the prompt, unless that breaks up
+	// sequences
+	reviews = reviews[:min(len(reviews), len(promptTokens))]
+	if len(reviews) > 0 {
+		if !opts.Markov {
+			promptTokens = random.Shuffle(promptTokens, r)
+		}
+	}
+
+	return strings.Join(promptTokens, " "), reviews, nil
+}
+
+// sampleTokens returns up to k random distinct tokens across all sources,
+// drawing randomness from r.
+//
+// If sources are weighted, each token is drawn from a source picked according
+// to the weights. Otherwise, tokens are sampled uniformly from the union of
+// all sources.
+func (e *Engine) sampleTokens(k int, r *rand.Rand) ([]string, error) {
+	if len(e.sources) == 0 {
+		return nil, nil
+	}
+
+	var tokenResults []data.TokenResult
+	if e.sources[0].weight == 0 {
+		tokenResults = lazy.Sample(iterUnion(e.sources), k, r)
+	} else {
+		for i, n := range allocateSamples(e.sources, k, r) {
+			if n > 0 {
+				tokenResults = append(tokenResults,
+					lazy.Sample(e.sources[i].store.IterUniqueTokens(), n, r)...)
+			}
+		}
+	}
+
+	tokens := []string{}
+	seen := map[string]bool{}
+	for _, tr := range tokenResults {
+		if tr.Err != nil {
+			return nil, tr.Err
+		}
+		// Sources may share tokens
+		if !seen[tr.Token.Value] {
+			tokens = append(tokens, tr.Token.Value)
+			seen[tr.Token.Value] = true
+		}
+	}
+
+	return tokens, nil
+}
+
+// iterUnion returns an iterator over the distinct tokens of all sources.
+func iterUnion(sources []source) iter.Seq[data.TokenResult] {
+	if len(sources) == 1 {
+		return sources[0].store.IterUniqueTokens()
+	}
+
+	return func(yield func(data.TokenResult) bool) {
+		seen := map[string]bool{}
+		for _, src := range sources {
+			for tr := range src.store.IterUniqueTokens() {
+				if tr.Err == nil && seen[tr.Token.Value] {
+					continue
+				}
+				seen[tr.Token.Value] = true
+				if !yield(tr) || tr.Err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// allocateSamples distributes k samples among weighted sources by drawing a
+// source for each sample with probability proportional to its weight.
+func allocateSamples(sources []source, k int, r *rand.Rand) []int {
+	total := 0.0
+	for _, src := range sources {
+		total += src.weight
+	}
+
+	counts := make([]int, len(sources))
+	for range k {
+		x := random.Float64(r) * total
+		for i, src := range sources {
+			x -= src.weight
+			if x < 0 || i == len(sources)-1 {
+				counts[i]++
+				break
+			}
+		}
+	}
+	return counts
 }
 
 // isFileEligible returns true if the file should be included for tokenization.
@@ -518,7 +1148,7 @@ func isFileEligible(fpath string) (bool, error) {
 		zap.S().Errorw("Failed to stat file",
 			"file_path", fpath,
 			"error", err)
-		return false, ErrFileOperation
+		return false, fmt.Errorf("%w: %w", ErrFileOperation, err)
 	}
 
 	// Exclude non-regular files (e.g., directories, symlinks, devices)
@@ -531,7 +1161,7 @@ func isFileEligible(fpath string) (bool, error) {
 		zap.S().Errorw("Failed to determine if file is text",
 			"file_path", fpath,
 			"error", err)
-		return false, ErrFileOperation
+		return false, fmt.Errorf("%w: %w", ErrFileOperation, err)
 	}
 
 	return isTextFile && stat.Size() < maxFileSize, nil
@@ -549,8 +1179,23 @@ func isWordEligible(word string) bool {
 	return len(runes) < maxWordLen
 }
 
-// Teardown cleans up resources used by the domain package.
-func Teardown() error {
-	cancel()
-	return data.Teardown()
+// Close stops generating prompts and closes the databases of the engine.
+func (e *Engine) Close() error {
+	e.cancel()
+	// Stop generating prompts and refreshing watched directories before
+	// closing the stores they use
+	e.producer.Wait()
+	e.watchers.Wait()
+	e.closeSubscribers()
+
+	var errs []error
+	for _, src := range e.sources {
+		errs = append(errs, src.store.Close())
+	}
+	e.sources = nil
+	if e.resultStore != nil {
+		errs = append(errs, e.resultStore.Close())
+		e.resultStore = nil
+	}
+	return errors.Join(errs...)
 }
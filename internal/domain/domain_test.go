@@ -0,0 +1,1485 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/alphabet"
+	"github.com/vupdivup/typomat/pkg/layout"
+	"github.com/vupdivup/typomat/pkg/lease"
+	"github.com/vupdivup/typomat/pkg/random"
+	"github.com/vupdivup/typomat/pkg/tokenizer"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	// helperDirEnv instructs the test binary to run as an indexing process on
+	// the specified directory instead of running tests.
+	helperDirEnv = "TYPOMAT_TEST_INDEX_DIR"
+	// helperCacheEnv instructs the indexing process to use the cache.
+	helperCacheEnv = "TYPOMAT_TEST_INDEX_CACHE"
+	// helperExcludeEnv holds a comma-separated list of exclude patterns for
+	// the indexing process.
+	helperExcludeEnv = "TYPOMAT_TEST_INDEX_EXCLUDE"
+)
+
+func TestMain(m *testing.M) {
+	if dirPath := os.Getenv(helperDirEnv); dirPath != "" {
+		os.Exit(runIndexHelper(dirPath))
+	}
+	os.Exit(m.Run())
+}
+
+// runIndexHelper processes a directory like a typomat instance would and
+// returns the process exit code.
+func runIndexHelper(dirPath string) int {
+	if err := config.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var filter Filter
+	if exclude := os.Getenv(helperExcludeEnv); exclude != "" {
+		filter.Exclude = strings.Split(exclude, ",")
+	}
+	dbId, err := dirDbId(dirPath, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	store, err := data.Open(dbId, os.Getenv(helperCacheEnv) != "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = NewEngine().ProcessDirectory(store, dirPath, filter, ErrorOptions{})
+	if err := errors.Join(err, store.Close()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// setupCacheHome points the user cache directory to a temporary directory
+// and initializes the configuration there.
+func setupCacheHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", home)
+	t.Setenv("HOME", home)
+	t.Setenv("LocalAppData", home)
+	require.NoError(t, config.Init())
+}
+
+// writeCorpus creates a directory of text files with distinct words and
+// returns its absolute path.
+func writeCorpus(t *testing.T, numFiles int) string {
+	dirPath := t.TempDir()
+	for i := range numFiles {
+		content := fmt.Sprintf("package main\n\nfunc handle%c%cRequest() {}\n",
+			'A'+rune(i%26), 'a'+rune(i/26%26))
+		path := filepath.Join(dirPath, fmt.Sprintf("file%03d.go", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dirPath
+}
+
+// runIndexProcesses indexes the directory in the specified number of
+// parallel processes and waits for them to finish. Additional environment
+// variables are passed to the processes.
+func runIndexProcesses(
+	t *testing.T, dirPath string, cache bool, n int, env ...string,
+) {
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			cmd := exec.Command(os.Args[0], "-test.run=^$")
+			cmd.Env = append(os.Environ(), helperDirEnv+"="+dirPath)
+			if cache {
+				cmd.Env = append(cmd.Env, helperCacheEnv+"=1")
+			}
+			cmd.Env = append(cmd.Env, env...)
+			out, err := cmd.CombinedOutput()
+			assert.NoError(t, err, string(out))
+		})
+	}
+	wg.Wait()
+}
+
+// openDb opens the database at the specified path, closing it at the end of
+// the test.
+func openDb(t *testing.T, dbPath string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// cachedDbPath returns the path of the only cached database.
+func cachedDbPath(t *testing.T) string {
+	dbPaths, err := filepath.Glob(filepath.Join(config.CachedDbDir(), "*.db"))
+	require.NoError(t, err)
+	require.Len(t, dbPaths, 1)
+	return dbPaths[0]
+}
+
+// cachedDbPathOf returns the path of the cached database of the directory
+// for the filter.
+func cachedDbPathOf(t *testing.T, dirPath string, filter Filter) string {
+	id, err := dirDbId(dirPath, filter)
+	require.NoError(t, err)
+	hash := sha256.Sum256([]byte(id))
+	return filepath.Join(config.CachedDbDir(), hex.EncodeToString(hash[:])+".db")
+}
+
+// countRows opens the database at the specified path and returns the number
+// of file and token records in it.
+func countRows(t *testing.T, dbPath string) (int64, int64) {
+	db := openDb(t, dbPath)
+
+	var numFiles, numTokens int64
+	require.NoError(t, db.Model(&data.File{}).Count(&numFiles).Error)
+	require.NoError(t, db.Model(&data.Token{}).Count(&numTokens).Error)
+	return numFiles, numTokens
+}
+
+func TestProcessDirectoryConcurrentCache(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 64)
+
+	runIndexProcesses(t, dirPath, true, 2)
+
+	// Both instances share a single, complete cached database
+	numFiles, numTokens := countRows(t, cachedDbPath(t))
+	assert.Equal(t, int64(64), numFiles)
+	// Each file yields "package", "main", "func", "handle" and "request"
+	assert.Equal(t, int64(64*5), numTokens)
+
+	// Leases are released and no temporary databases are left behind
+	locks, err := filepath.Glob(filepath.Join(config.CachedDbDir(), "*.lock"))
+	require.NoError(t, err)
+	assert.Empty(t, locks)
+	entries, err := os.ReadDir(config.TempDbDir())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestProcessDirectoryConcurrentTemp(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 16)
+
+	// Simulate the temporary database of another live instance
+	otherDbPath := filepath.Join(config.TempDbDir(), "other.db")
+	require.NoError(t, os.WriteFile(otherDbPath, []byte{}, 0o644))
+
+	runIndexProcesses(t, dirPath, false, 2)
+
+	// Teardown only removes the databases of the exiting instances
+	entries, err := os.ReadDir(config.TempDbDir())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "other.db", entries[0].Name())
+}
+
+func TestStoreLockConcurrent(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := t.TempDir()
+	store, err := data.Open(dirPath, true)
+	require.NoError(t, err)
+	defer store.Close()
+
+	// Goroutines sharing a store take turns on its lease
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			for range 10 {
+				if assert.NoError(t, store.Lock()) {
+					assert.NoError(t, store.Unlock())
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// No lease is left behind for other instances to wait on
+	other, err := data.Open(dirPath, true)
+	require.NoError(t, err)
+	defer other.Close()
+	locked := make(chan error, 1)
+	go func() { locked <- other.Lock() }()
+	select {
+	case err := <-locked:
+		require.NoError(t, err)
+		assert.NoError(t, other.Unlock())
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease of the shared store was never released")
+	}
+}
+
+func TestProcessDirectoryStaleTemp(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 4)
+	past := time.Now().Add(-48 * time.Hour)
+
+	// Simulate a long-running live instance, whose database is old but whose
+	// owner lease is held
+	liveDbPath := filepath.Join(config.TempDbDir(), "live.db")
+	require.NoError(t, os.WriteFile(liveDbPath, []byte{}, 0o644))
+	require.NoError(t, os.Chtimes(liveDbPath, past, past))
+	owner, err := lease.Acquire(
+		context.Background(), liveDbPath+".owner", time.Minute)
+	require.NoError(t, err)
+	defer owner.Release() // nolint:errcheck
+
+	// Simulate a crashed instance, whose owner lease expired
+	deadDbPath := filepath.Join(config.TempDbDir(), "dead.db")
+	for _, path := range []string{
+		deadDbPath, deadDbPath + "-wal", deadDbPath + ".lock",
+		deadDbPath + ".owner",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte{}, 0o644))
+		require.NoError(t, os.Chtimes(path, past, past))
+	}
+
+	runIndexProcesses(t, dirPath, false, 1)
+
+	var names []string
+	entries, err := os.ReadDir(config.TempDbDir())
+	require.NoError(t, err)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"live.db", "live.db.owner"}, names)
+}
+
+func TestProcessDirectoryResume(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 32)
+
+	runIndexProcesses(t, dirPath, true, 1)
+
+	// Simulate a run interrupted before flushing the second half of files
+	dbPath := cachedDbPath(t)
+	db := openDb(t, dbPath)
+	var files []data.File
+	require.NoError(t, db.Order("path").Find(&files).Error)
+	for _, file := range files[16:] {
+		require.NoError(t, db.Delete(&file).Error)
+		require.NoError(t, db.Where("path = ?", file.Path).
+			Delete(&data.Token{}).Error)
+	}
+	require.NoError(t, db.Save(&data.Meta{
+		Key: metaIndexState, Value: indexStateInProgress}).Error)
+
+	runIndexProcesses(t, dirPath, true, 1)
+
+	numFiles, numTokens := countRows(t, dbPath)
+	assert.Equal(t, int64(32), numFiles)
+	assert.Equal(t, int64(32*5), numTokens)
+
+	var meta data.Meta
+	require.NoError(t, db.First(&meta, "key = ?", metaIndexState).Error)
+	assert.Equal(t, indexStateComplete, meta.Value)
+}
+
+func TestFilter(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "repo")
+	cases := []struct {
+		name   string
+		filter Filter
+		path   string
+		want   bool
+	}{
+		{"empty", Filter{}, "main.go", true},
+		{"include", Filter{Include: []string{"*.go"}}, "cmd/main.go", true},
+		{"include mismatch", Filter{Include: []string{"*.go"}}, "README.md", false},
+		{"exclude", Filter{Exclude: []string{"*_test.go"}}, "pkg/a_test.go", false},
+		{"exclude dir", Filter{Exclude: []string{"vendor/"}}, "vendor/x/y.go", false},
+		{"lang", Filter{Langs: []string{"go", "ts"}}, "web/app.tsx", true},
+		{"lang mismatch", Filter{Langs: []string{"go"}}, "web/app.tsx", false},
+		{
+			"include and lang",
+			Filter{Include: []string{"docs/**"}, Langs: []string{"go"}},
+			"docs/guide.md", true,
+		},
+		{
+			"exclude wins",
+			Filter{Langs: []string{"go"}, Exclude: []string{"internal/"}},
+			"internal/main.go", false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, err := c.filter.compile(root)
+			require.NoError(t, err)
+			path := filepath.Join(root, filepath.FromSlash(c.path))
+			assert.Equal(t, c.want, m.match(path))
+		})
+	}
+
+	// Equivalent filters share a signature
+	a, err := Filter{Langs: []string{"go"}, Exclude: []string{"b", "a"}}.signature()
+	require.NoError(t, err)
+	b, err := Filter{Include: []string{"*.go"}, Exclude: []string{"a", "b", "a"}}.signature()
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	empty, err := Filter{}.signature()
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	// Alphabets select different words
+	de, _ := alphabet.Preset("de")
+	german, err := Filter{Alphabet: de}.signature()
+	require.NoError(t, err)
+	assert.NotEqual(t, empty, german)
+
+	_, err = Filter{Langs: []string{"klingon"}}.compile(root)
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+	_, err = Filter{Include: []string{"/"}}.compile(root)
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestProcessDirectoryFilter(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 32)
+
+	runIndexProcesses(t, dirPath, true, 1)
+	numFiles, _ := countRows(t, cachedDbPath(t))
+	assert.Equal(t, int64(32), numFiles)
+
+	// Another filter gets a cached database of its own with matching files
+	runIndexProcesses(t, dirPath, true, 1, helperExcludeEnv+"=file00*.go")
+	filteredPath := cachedDbPathOf(t, dirPath,
+		Filter{Exclude: []string{"file00*.go"}})
+	numFiles, numTokens := countRows(t, filteredPath)
+	assert.Equal(t, int64(22), numFiles)
+	assert.Equal(t, int64(22*5), numTokens)
+
+	db := openDb(t, filteredPath)
+	var excluded int64
+	require.NoError(t, db.Model(&data.File{}).
+		Where("path LIKE ?", "%file00%").Count(&excluded).Error)
+	assert.Zero(t, excluded)
+
+	// The cache of the unfiltered directory is left alone
+	numFiles, numTokens = countRows(t, cachedDbPathOf(t, dirPath, Filter{}))
+	assert.Equal(t, int64(32), numFiles)
+	assert.Equal(t, int64(32*5), numTokens)
+}
+
+// setupSources processes directories with the specified contents into
+// temporary stores and returns an engine with them as prompt sources with the
+// specified weights, which may be nil.
+func setupSources(t *testing.T, contents []string, weights []float64) *Engine {
+	setupCacheHome(t)
+	e := NewEngine()
+	for i, content := range contents {
+		dirPath := t.TempDir()
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dirPath, "words.txt"), []byte(content), 0o644))
+
+		store, err := data.Open(dirPath, false)
+		require.NoError(t, err)
+		require.NoError(t, e.ProcessDirectory(store, dirPath, Filter{}, ErrorOptions{}))
+
+		src := source{path: dirPath, store: store}
+		if weights != nil {
+			src.weight = weights[i]
+		}
+		e.sources = append(e.sources, src)
+	}
+
+	t.Cleanup(func() {
+		assert.NoError(t, e.Close())
+	})
+	return e
+}
+
+func TestSampleTokensUnion(t *testing.T) {
+	e := setupSources(t, []string{
+		"alpha bravo charlie shared",
+		"delta echo shared",
+	}, nil)
+
+	tokens, err := e.sampleTokens(100, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t,
+		[]string{"alpha", "bravo", "charlie", "delta", "echo", "shared"}, tokens)
+}
+
+func TestSampleTokensWeighted(t *testing.T) {
+	e := setupSources(t, []string{
+		"alpha bravo charlie",
+		"delta echo foxtrot",
+	}, []float64{1, 0})
+
+	for range 10 {
+		tokens, err := e.sampleTokens(3, nil)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"alpha", "bravo", "charlie"}, tokens)
+	}
+}
+
+func TestAllocateSamples(t *testing.T) {
+	srcs := []source{{weight: 3}, {weight: 0}, {weight: 1}}
+
+	counts := allocateSamples(srcs, 4000, nil)
+	assert.Equal(t, 4000, counts[0]+counts[1]+counts[2])
+	assert.Zero(t, counts[1])
+	assert.InDelta(t, 3000, counts[0], 200)
+	assert.InDelta(t, 1000, counts[2], 200)
+}
+
+func TestValidateWeights(t *testing.T) {
+	assert.NoError(t, validateWeights([]float64{1, 0, 2.5}, 3))
+	assert.ErrorIs(t, validateWeights([]float64{1}, 2), ErrInvalidWeights)
+	assert.ErrorIs(t, validateWeights([]float64{1, -1}, 2), ErrInvalidWeights)
+	assert.ErrorIs(t, validateWeights([]float64{0, 0}, 2), ErrInvalidWeights)
+}
+
+func TestSetupFileAndStdin(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "notes.md")
+	require.NoError(t, os.WriteFile(filePath, []byte("# Release notes\n"), 0o644))
+
+	opts := Options{Stdin: strings.NewReader("diff --git a/main.go b/main.go\n")}
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{filePath, StdinPath}, opts, 64))
+	t.Cleanup(func() {
+		assert.NoError(t, e.Close())
+	})
+
+	require.Len(t, e.sources, 2)
+	assert.Equal(t, sourceFile, e.sources[0].kind)
+	assert.Equal(t, sourceStdin, e.sources[1].kind)
+
+	tokens, err := e.sampleTokens(100, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t,
+		[]string{"release", "notes", "diff", "git", "main"}, tokens)
+	assert.Equal(t, 1.0, e.Progress())
+}
+
+func TestProcessReaderIneligible(t *testing.T) {
+	setupCacheHome(t)
+	store, err := data.Open(StdinPath, false)
+	require.NoError(t, err)
+	defer store.Close() // nolint:errcheck
+
+	e := NewEngine()
+	err = e.ProcessReader(store, StdinPath, strings.NewReader(""), alphabet.English)
+	assert.ErrorIs(t, err, ErrIneligibleSource)
+	err = e.ProcessReader(store, StdinPath, strings.NewReader("\xff\xfe\x00"), alphabet.English)
+	assert.ErrorIs(t, err, ErrIneligibleSource)
+}
+
+func TestGeneratePromptSeeded(t *testing.T) {
+	e := setupSources(t, []string{
+		"alpha bravo charlie delta echo foxtrot golf hotel india juliett",
+		"kilo lima mike november oscar papa quebec romeo sierra tango",
+	}, nil)
+
+	// Equal seeds produce equal sequences of prompts
+	a, b := random.New(1234), random.New(1234)
+	for range 5 {
+		promptA, _, err := e.generatePrompt(PromptOptions{MaxLen: 32}, a)
+		require.NoError(t, err)
+		promptB, _, err := e.generatePrompt(PromptOptions{MaxLen: 32}, b)
+		require.NoError(t, err)
+		assert.Equal(t, promptA, promptB)
+	}
+}
+
+// writeRepo creates a directory resembling a clone of a Git repository with
+// the specified origin URL and user name, and returns its absolute path.
+func writeRepo(t *testing.T, originURL, userName string) string {
+	repoPath := t.TempDir()
+	gitDir := filepath.Join(repoPath, ".git")
+	require.NoError(t, os.MkdirAll(gitDir, 0o755))
+	contents := fmt.Sprintf("[remote \"origin\"]\n\turl = %s\n[user]\n\tname = %s\n",
+		originURL, userName)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(gitDir, "config"), []byte(contents), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(repoPath, "src"), 0o755))
+	return repoPath
+}
+
+func TestDailyChallenge(t *testing.T) {
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	day := time.Date(2026, 10, 18, 23, 30, 0, 0, time.UTC)
+
+	alice := writeRepo(t, "https://github.com/team/app.git", "Alice")
+	bob := writeRepo(t, "git@github.com:team/app.git", "Bob")
+	other := writeRepo(t, "https://github.com/team/other.git", "Alice")
+
+	challenge, err := DailyChallenge([]string{alice}, Options{}, day)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10-18", challenge.Date)
+	assert.Equal(t, "github.com/team/app", challenge.Label)
+	assert.Equal(t, "Alice", challenge.Player)
+	assert.Less(t, challenge.Seed, uint64(maxRandomSeed))
+
+	// Clones share the challenge regardless of time zone
+	bobChallenge, err := DailyChallenge([]string{bob}, Options{},
+		day.In(time.FixedZone("UTC+2", 2*60*60)))
+	require.NoError(t, err)
+	assert.Equal(t, challenge.ID, bobChallenge.ID)
+	assert.Equal(t, challenge.Seed, bobChallenge.Seed)
+	assert.Equal(t, "Bob", bobChallenge.Player)
+
+	// Other days, repositories, directories and options do not
+	variants := []struct {
+		paths []string
+		opts  Options
+		day   time.Time
+	}{
+		{[]string{alice}, Options{}, day.Add(time.Hour)},
+		{[]string{other}, Options{}, day},
+		{[]string{filepath.Join(alice, "src")}, Options{}, day},
+		{[]string{alice}, Options{Filter: Filter{Langs: []string{"go"}}}, day},
+		{[]string{alice}, Options{History: &HistoryOptions{}}, day},
+	}
+	for _, v := range variants {
+		c, err := DailyChallenge(v.paths, v.opts, v.day)
+		require.NoError(t, err)
+		assert.NotEqual(t, challenge.ID, c.ID)
+	}
+
+	_, err = DailyChallenge([]string{t.TempDir()}, Options{}, day)
+	assert.ErrorIs(t, err, ErrNotRepository)
+	_, err = DailyChallenge([]string{StdinPath}, Options{}, day)
+	assert.ErrorIs(t, err, ErrNotRepository)
+}
+
+func TestCompareResults(t *testing.T) {
+	setupCacheHome(t)
+	day := time.Now()
+
+	store, err := data.OpenResults()
+	require.NoError(t, err)
+	e := NewEngine()
+	e.resultStore = store
+	e.challenge = &Challenge{
+		ID: day.UTC().Format(dateLayout) + "/abc", Date: day.UTC().Format(dateLayout),
+		Label: "github.com/team/app", Player: "alice",
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, e.Close())
+	})
+
+	for i, wpm := range []int{60, 80} {
+		round := Round{Prompt: "alpha bravo", Number: i + 1, Challenge: e.challenge.ID}
+		require.NoError(t, e.RecordResult(round, wpm, 90, false))
+	}
+	// Rounds of other modes are ranked separately, failed ones apart
+	for i, failed := range []bool{false, true} {
+		round := Round{
+			Prompt: "delta echo", Number: i + 3, Challenge: e.challenge.ID,
+			Mode: ModeSuddenDeath,
+		}
+		require.NoError(t, e.RecordResult(round, 50-20*i, 100, failed))
+	}
+	// Rounds outside the challenge are recorded apart, with their mode
+	require.NoError(t, e.RecordResult(
+		Round{Prompt: "charlie", Number: 1, Mode: ModeStopOnWord}, 100, 100, false))
+	var other data.Result
+	require.NoError(t, openDb(t, config.ResultsDbPath()).
+		First(&other, "challenge = ?", "").Error)
+	assert.Equal(t, "charlie", other.Prompt)
+	assert.Equal(t, string(ModeStopOnWord), other.Mode)
+
+	// Export own results and craft those of a colleague from them
+	var exported strings.Builder
+	require.NoError(t, ExportResults(&exported, day))
+	var file resultsFile
+	require.NoError(t, json.Unmarshal([]byte(exported.String()), &file))
+	require.Len(t, file.Results, 4)
+
+	ownPath := filepath.Join(t.TempDir(), "alice.json")
+	require.NoError(t, os.WriteFile(ownPath, []byte(exported.String()), 0o644))
+
+	for i := range file.Results {
+		file.Results[i].Player = "bob"
+		file.Results[i].WPM += 20
+		file.Results[i].Failed = false
+	}
+	contents, err := json.Marshal(file)
+	require.NoError(t, err)
+	bobPath := filepath.Join(t.TempDir(), "bob.json")
+	require.NoError(t, os.WriteFile(bobPath, contents, 0o644))
+
+	// Importing own results does not count them twice
+	challenges, err := CompareResults(day, []string{ownPath, bobPath})
+	require.NoError(t, err)
+	require.Len(t, challenges, 2)
+	assert.Equal(t, e.challenge.ID, challenges[0].ID)
+	assert.Equal(t, ModeNormal, challenges[0].Mode)
+	assert.Equal(t, []Standing{
+		{Player: "bob", Rounds: 2, AvgWPM: 90, BestWPM: 100, AvgAccuracy: 90},
+		{Player: "alice", Rounds: 2, AvgWPM: 70, BestWPM: 80, AvgAccuracy: 90},
+	}, challenges[0].Standings)
+	assert.Equal(t, e.challenge.ID, challenges[1].ID)
+	assert.Equal(t, ModeSuddenDeath, challenges[1].Mode)
+	assert.Equal(t, []Standing{
+		{Player: "bob", Rounds: 2, AvgWPM: 60, BestWPM: 70, AvgAccuracy: 100},
+		{Player: "alice", Rounds: 2, Failed: 1, AvgWPM: 50, BestWPM: 50, AvgAccuracy: 100},
+	}, challenges[1].Standings)
+
+	// Results of other days are left out
+	challenges, err = CompareResults(day.AddDate(0, 0, -1), []string{bobPath})
+	require.NoError(t, err)
+	assert.Empty(t, challenges)
+
+	invalidPath := filepath.Join(t.TempDir(), "invalid.json")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("{"), 0o644))
+	_, err = CompareResults(day, []string{invalidPath})
+	assert.ErrorIs(t, err, ErrInvalidResults)
+}
+
+func TestPromptRounds(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(filePath,
+		[]byte("alpha bravo charlie delta echo foxtrot golf hotel"), 0o644))
+
+	seed := uint64(42)
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{filePath},
+		Options{Seed: &seed, Mode: ModeStopOnWord}, 24))
+
+	// Rounds are numbered in order and fit the maximum length
+	for number := 1; number <= 3; number++ {
+		round, err := e.Prompt()
+		require.NoError(t, err)
+		assert.Equal(t, number, round.Number)
+		assert.Equal(t, seed, round.Seed)
+		assert.Equal(t, ModeStopOnWord, round.Mode)
+		assert.NotEmpty(t, round.Prompt)
+		assert.LessOrEqual(t, len(round.Prompt), 24)
+	}
+
+	// Closed engines stop delivering prompts
+	require.NoError(t, e.Close())
+	_, err := e.Prompt()
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestIndex(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 4)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dirPath, "image.png"), []byte("\x89PNG\x00"), 0o644))
+
+	e := NewEngine()
+	summary, err := e.Index(dirPath, Filter{}, ErrorOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, summary.Files)
+	assert.Equal(t, 4, summary.New)
+	assert.Equal(t, 1, summary.Ineligible)
+	assert.Positive(t, summary.Tokens)
+
+	// Change one file, remove another
+	require.NoError(t, os.WriteFile(filepath.Join(dirPath, "file000.go"),
+		[]byte("package main\n\nfunc handleChangedRequest() {}\n"), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(dirPath, "file001.go")))
+
+	summary, err = e.Index(dirPath, Filter{}, ErrorOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, IndexSummary{
+		Files: 4, Changed: 1, Unchanged: 2, Ineligible: 1, Removed: 1,
+		Tokens: summary.Tokens, Elapsed: summary.Elapsed,
+	}, summary)
+	assert.Positive(t, summary.Tokens)
+
+	assert.Equal(t, 1.0, e.Progress())
+
+	_, err = e.Index(filepath.Join(dirPath, "file002.go"), Filter{}, ErrorOptions{})
+	assert.ErrorIs(t, err, ErrInvalidDirPath)
+}
+
+func TestProgressEvents(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 8)
+
+	e := NewEngine()
+	events := e.Subscribe()
+
+	var received []ProgressEvent
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for event := range events {
+			received = append(received, event)
+		}
+	})
+
+	_, err := e.Index(dirPath, Filter{}, ErrorOptions{})
+	require.NoError(t, err)
+	require.NoError(t, e.Close())
+	wg.Wait()
+
+	// Events may be skipped, but arrive in order and end with completion
+	require.NotEmpty(t, received)
+	for i := 1; i < len(received); i++ {
+		prev, cur := received[i-1], received[i]
+		assert.LessOrEqual(t, prev.Phase, cur.Phase)
+		assert.LessOrEqual(t, prev.Fraction(), cur.Fraction())
+	}
+	last := received[len(received)-1]
+	assert.Equal(t, PhaseDone, last.Phase)
+	assert.Equal(t, dirPath, last.Source)
+	assert.Equal(t, 1, last.SourcesDone)
+	assert.Equal(t, 1.0, last.Fraction())
+
+	// Subscribing to a closed engine yields the final state only
+	var late []ProgressEvent
+	for event := range e.Subscribe() {
+		late = append(late, event)
+	}
+	assert.Equal(t, []ProgressEvent{last}, late)
+}
+
+func TestProgressEventFraction(t *testing.T) {
+	tests := []struct {
+		name  string
+		event ProgressEvent
+		want  float64
+	}{
+		{"no sources", ProgressEvent{}, 0},
+		{"listing", ProgressEvent{NumSources: 2}, 0},
+		{"tokenizing", ProgressEvent{
+			Phase: PhaseTokenizing, NumSources: 2, SourcesDone: 1,
+			FilesDone: 1, NumFiles: 4}, 0.625},
+		{"done", ProgressEvent{Phase: PhaseDone}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.event.Fraction())
+		})
+	}
+}
+
+func TestProgressEventString(t *testing.T) {
+	tests := []struct {
+		name  string
+		event ProgressEvent
+		want  string
+	}{
+		{"listing", ProgressEvent{NumSources: 1}, "listing"},
+		{"tokenizing", ProgressEvent{
+			Phase: PhaseTokenizing, NumSources: 1,
+			FilesDone: 120, NumFiles: 900, ETA: 3400 * time.Millisecond},
+			"tokenizing 120/900 files, ~3s left"},
+		{"several sources", ProgressEvent{
+			Phase: PhaseFlushing, NumSources: 3, SourcesDone: 1},
+			"source 2/3, flushing"},
+		{"done", ProgressEvent{Phase: PhaseDone, NumSources: 3, SourcesDone: 3},
+			"done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.event.String())
+		})
+	}
+}
+
+func TestIndexFailures(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 4)
+
+	// Files without read permission fail to process
+	for _, name := range []string{"private.go", "secret.go"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dirPath, name),
+			[]byte("func handlePrivate() {}\n"), 0o000))
+	}
+
+	tests := []struct {
+		name    string
+		errOpts ErrorOptions
+		wantErr error
+	}{
+		{"default limit", ErrorOptions{}, nil},
+		{"limit reached", ErrorOptions{MaxErrors: 2}, ErrTooManyErrors},
+		{"skip errors", ErrorOptions{MaxErrors: 1, Skip: true}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := NewEngine().Index(dirPath, Filter{}, tt.errOpts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, 2, summary.Errors)
+			require.Len(t, summary.Failures, 2)
+			for _, f := range summary.Failures {
+				assert.Equal(t, FailurePermissionDenied, f.Reason)
+			}
+		})
+	}
+}
+
+func TestIndexInvalidUTF8(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 4)
+
+	// Files valid UTF-8 at first, but not on one line further on, keep the
+	// tokens of their valid lines
+	prefix := strings.Repeat("func handleValidPrefix() {}\n", 32)
+	for _, name := range []string{"latin1.go", "mixed.go"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dirPath, name),
+			[]byte(prefix+"// caf\xe9 ol\xe9\nfunc trailingSuffix() {}\n"), 0o644))
+	}
+
+	// Warnings do not count toward the error limit
+	e := NewEngine()
+	summary, err := e.Index(dirPath, Filter{}, ErrorOptions{MaxErrors: 1})
+	require.NoError(t, err)
+	require.NoError(t, e.Close())
+
+	assert.Equal(t, 0, summary.Errors)
+	assert.Empty(t, summary.Failures)
+	require.Len(t, summary.Warnings, 2)
+	for _, f := range summary.Warnings {
+		assert.Equal(t, FailureInvalidUTF8, f.Reason)
+		assert.Contains(t, f.Detail, "line 33")
+	}
+
+	db := openDb(t, cachedDbPath(t))
+	for _, want := range []string{"prefix", "trailing", "suffix"} {
+		var count int64
+		require.NoError(t, db.Model(&data.Token{}).
+			Where("path = ? AND value = ?",
+				filepath.Join(dirPath, "latin1.go"), want).
+			Count(&count).Error)
+		assert.Equal(t, int64(1), count, want)
+	}
+}
+
+func TestWriteFailureReport(t *testing.T) {
+	var buf strings.Builder
+	require.NoError(t, WriteFailureReport(&buf, []FileFailure{
+		{Path: "/b.go", Reason: FailureReadError, Detail: "i/o error"},
+		{Path: "/a.go", Reason: FailurePermissionDenied, Detail: "denied"},
+	}))
+	assert.Equal(t, "2 file(s) failed to process:\n"+
+		"  permission denied  /a.go  denied\n"+
+		"  read error         /b.go  i/o error\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteFailureReport(&buf, nil))
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, WriteWarningReport(&buf, []FileFailure{
+		{Path: "/a.go", Reason: FailureInvalidUTF8, Detail: "line 3"},
+	}))
+	assert.Equal(t, "1 file(s) processed with warnings:\n"+
+		"  invalid UTF-8  /a.go  line 3\n", buf.String())
+}
+
+func TestNewFileFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want FailureReason
+	}{
+		{"permission", fmt.Errorf("%w: %w", ErrFileOperation,
+			&fs.PathError{Op: "open", Path: "/a.go", Err: fs.ErrPermission}),
+			FailurePermissionDenied},
+		{"invalid utf-8", fmt.Errorf("%w: %w", ErrTextProcessing,
+			tokenizer.ErrInvalidUTF8), FailureInvalidUTF8},
+		{"other", fmt.Errorf("%w: %w", ErrFileOperation, io.ErrUnexpectedEOF),
+			FailureReadError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFileFailure("/a.go", tt.err)
+			assert.Equal(t, tt.want, f.Reason)
+			assert.Equal(t, "/a.go", f.Path)
+			assert.Equal(t, tt.err.Error(), f.Detail)
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	setupCacheHome(t)
+	dirPath := writeCorpus(t, 2)
+
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{dirPath}, Options{Watch: true}, 64))
+	t.Cleanup(func() { assert.NoError(t, e.Close()) })
+
+	tokens := func() []string {
+		var values []string
+		for tr := range e.sources[0].store.IterUniqueTokens() {
+			require.NoError(t, tr.Err)
+			values = append(values, tr.Token.Value)
+		}
+		return values
+	}
+	require.Contains(t, tokens(), "handle")
+
+	// Added files are tokenized, including those in new directories
+	subDir := filepath.Join(dirPath, "sub")
+	require.NoError(t, os.Mkdir(subDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(subDir, "added.go"),
+		[]byte("package sub\n\nfunc watchedNovelty() {}\n"), 0o644))
+	assert.Eventually(t, func() bool {
+		return slices.Contains(tokens(), "novelty")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Removed files are dropped
+	require.NoError(t, os.Remove(filepath.Join(subDir, "added.go")))
+	assert.Eventually(t, func() bool {
+		return !slices.Contains(tokens(), "novelty")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Removing the last files drops all tokens
+	entries, err := os.ReadDir(dirPath)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		require.NoError(t, os.RemoveAll(filepath.Join(dirPath, entry.Name())))
+	}
+	assert.Eventually(t, func() bool {
+		return len(tokens()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestWatchUntracked(t *testing.T) {
+	setupCacheHome(t)
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+
+	// Simulate a repository whose index tracks tracked.go only
+	repoPath := t.TempDir()
+	index, err := os.ReadFile("../../pkg/git/testdata/read_index/index_v2")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".git"), 0o755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(repoPath, ".git", "index"), index, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(repoPath, "tracked.go"),
+		[]byte("package main\n\nfunc trackedHandle() {}\n"), 0o644))
+
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{repoPath}, Options{Watch: true}, 64))
+	t.Cleanup(func() { assert.NoError(t, e.Close()) })
+
+	tokens := func() []string {
+		var values []string
+		for tr := range e.sources[0].store.IterUniqueTokens() {
+			require.NoError(t, tr.Err)
+			values = append(values, tr.Token.Value)
+		}
+		return values
+	}
+	require.Contains(t, tokens(), "handle")
+
+	// Files not yet added to the repository are tokenized too
+	require.NoError(t, os.WriteFile(filepath.Join(repoPath, "untracked.go"),
+		[]byte("package main\n\nfunc watchedNovelty() {}\n"), 0o644))
+	assert.Eventually(t, func() bool {
+		return slices.Contains(tokens(), "novelty")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestReconfigure(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(filePath,
+		[]byte("alpha bravo charlie delta echo foxtrot golf hotel"), 0o644))
+
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{filePath}, Options{}, 64))
+
+	round, err := e.Prompt()
+	require.NoError(t, err)
+	assert.Equal(t, 1, round.Number)
+
+	// Pooled prompts of the old length are discarded, numbering continues
+	require.NoError(t, e.Reconfigure(PromptOptions{MaxLen: 8}))
+	for number := 2; number <= 4; number++ {
+		round, err := e.Prompt()
+		require.NoError(t, err)
+		assert.Equal(t, number, round.Number)
+		assert.LessOrEqual(t, len(round.Prompt), 8)
+	}
+
+	assert.ErrorIs(t, e.Reconfigure(PromptOptions{}), ErrInvalidPromptOptions)
+
+	require.NoError(t, e.Close())
+	assert.ErrorIs(t, e.Reconfigure(PromptOptions{MaxLen: 8}), ErrClosed)
+}
+
+func TestReconfigureSeeded(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte(
+		"alpha bravo charlie delta echo foxtrot golf hotel india juliett"), 0o644))
+
+	// Sessions with equal seeds and actions get equal prompts, no matter how
+	// many prompts were pooled before reconfiguration
+	seed := uint64(1234)
+	prompts := func(pooled bool) []string {
+		e := NewEngine()
+		t.Cleanup(func() { assert.NoError(t, e.Close()) })
+		require.NoError(t, e.Setup([]string{filePath}, Options{Seed: &seed}, 32))
+
+		var prompts []string
+		for _, maxLen := range []int{32, 16, 24} {
+			if pooled {
+				require.Eventually(t, func() bool {
+					return len(e.prompts) == cap(e.prompts)
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+			round, err := e.Prompt()
+			require.NoError(t, err)
+			prompts = append(prompts, round.Prompt)
+			require.NoError(t, e.Reconfigure(PromptOptions{MaxLen: maxLen}))
+		}
+		return prompts
+	}
+	assert.Equal(t, prompts(true), prompts(false))
+}
+
+func TestProducerRecovers(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte("a b c"), 0o644))
+
+	e := NewEngine()
+	require.NoError(t, e.Setup([]string{filePath}, Options{}, 24))
+	t.Cleanup(func() { assert.NoError(t, e.Close()) })
+
+	_, err := e.Prompt()
+	assert.ErrorIs(t, err, ErrNoTokensFound)
+
+	// Tokens arriving later, e.g. in a watched directory, are picked up
+	store := e.sources[0].store
+	require.NoError(t, store.SaveFiles(
+		[]data.File{{Path: "later.txt", Size: 1, Mtime: time.Now()}},
+		[]data.Token{{Path: "later.txt", Value: "arrived"}}, nil))
+
+	round, err := e.Prompt()
+	require.NoError(t, err)
+	assert.Equal(t, 1, round.Number)
+	assert.Contains(t, round.Prompt, "arrived")
+}
+
+func TestAlphabet(t *testing.T) {
+	e := setupSources(t, []string{"die Größe der Übergabe"}, nil)
+	store := e.sources[0].store
+	tokens := func() []string {
+		var values []string
+		for tr := range store.IterUniqueTokens() {
+			require.NoError(t, tr.Err)
+			values = append(values, tr.Token.Value)
+		}
+		return values
+	}
+	assert.ElementsMatch(t, []string{"die", "der"}, tokens())
+
+	de, _ := alphabet.Preset("de")
+	dirPath := e.sources[0].path
+	require.NoError(t, e.ProcessDirectory(
+		store, dirPath, Filter{Alphabet: de}, ErrorOptions{}))
+	assert.ElementsMatch(t,
+		[]string{"die", "größe", "der", "übergabe"}, tokens())
+}
+
+func TestKeyStats(t *testing.T) {
+	setupCacheHome(t)
+	e := NewEngine()
+	t.Cleanup(func() { assert.NoError(t, e.Close()) })
+
+	// Two rounds typing "aj" on QWERTY, mistyping the first 'a'
+	for _, mistake := range []bool{true, false} {
+		require.NoError(t, e.RecordKeystrokes([]Keystroke{
+			{Expected: 'a', Mistake: mistake},
+			{Expected: 'j', Latency: 200 * time.Millisecond},
+			{Expected: 'ä', Latency: 400 * time.Millisecond},
+		}))
+	}
+
+	stats, err := e.LoadKeyStats()
+	require.NoError(t, err)
+	assert.Equal(t, map[rune]KeyStats{
+		'a': {Presses: 2, Errors: 1},
+		'j': {Presses: 2, Latency: 400 * time.Millisecond, Timed: 2},
+		'ä': {Presses: 2, Latency: 800 * time.Millisecond, Timed: 2},
+	}, stats)
+	assert.Equal(t, 200*time.Millisecond, stats['j'].AvgLatency())
+
+	qwerty, err := layout.Preset("qwerty")
+	require.NoError(t, err)
+
+	// Characters without a key in the layout are left out
+	byFinger := StatsByFinger(stats, qwerty)
+	assert.Equal(t, map[layout.Finger]KeyStats{
+		layout.LeftPinky:  {Presses: 2, Errors: 1},
+		layout.RightIndex: {Presses: 2, Latency: 400 * time.Millisecond, Timed: 2},
+	}, byFinger)
+	assert.Equal(t, 0.5, byFinger[layout.LeftPinky].ErrorRate())
+
+	// Both cases of a letter are typed with the same key
+	stats['A'] = KeyStats{Presses: 1, Errors: 1}
+	assert.Equal(t, KeyStats{Presses: 3, Errors: 2}, StatsByKey(stats, qwerty)['a'])
+
+	byRow := StatsByRow(stats, qwerty)
+	assert.Equal(t, 5, byRow[layout.HomeRow].Presses)
+}
+
+func TestWeakFingers(t *testing.T) {
+	qwerty, err := layout.Preset("qwerty")
+	require.NoError(t, err)
+
+	stats := map[rune]KeyStats{
+		'a': {Presses: 100, Errors: 10}, // left pinky
+		's': {Presses: 100, Errors: 30}, // left ring
+		'j': {Presses: 100, Errors: 0},  // right index
+		'l': {Presses: 10, Errors: 9},   // right ring, too few presses
+		' ': {Presses: 100, Errors: 50}, // thumb
+	}
+	assert.Equal(t,
+		[]layout.Finger{layout.LeftRing, layout.LeftPinky},
+		WeakFingers(stats, qwerty, 3))
+	assert.Equal(t,
+		[]layout.Finger{layout.LeftRing}, WeakFingers(stats, qwerty, 1))
+}
+
+func TestDrill(t *testing.T) {
+	e := setupSources(t, []string{
+		"sass lass was pool hook mill jump kill lulu noon",
+	}, nil)
+	qwerty, err := layout.Preset("qwerty")
+	require.NoError(t, err)
+
+	// Left hand tokens are preferred for a left pinky and ring drill
+	opts := PromptOptions{MaxLen: 9, Drill: &Drill{
+		Layout: qwerty, Fingers: []layout.Finger{layout.LeftPinky, layout.LeftRing},
+	}}
+	require.NoError(t, opts.validate())
+	prompt, _, err := e.generatePrompt(opts, random.New(1))
+	require.NoError(t, err)
+	assert.Subset(t, []string{"sass", "lass", "was"}, strings.Fields(prompt))
+	assert.NotEmpty(t, prompt)
+
+	assert.ErrorIs(t,
+		PromptOptions{MaxLen: 9, Drill: &Drill{Layout: qwerty}}.validate(),
+		ErrInvalidPromptOptions)
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// A mistyped word is relearned within the session
+	r, done := schedule(data.Review{Token: "alpha"}, quality(3), now)
+	assert.False(t, done)
+	assert.Equal(t, 0, r.Repetitions)
+	assert.Equal(t, 1, r.Lapses)
+	assert.Equal(t, now.Add(relearnDelay), r.Due)
+	assert.InDelta(t, 2.5-0.54, r.Ease, 1e-9)
+
+	// Passed reviews grow the interval, 1 and 6 days, then by the ease
+	cases := []struct {
+		mistakes     int
+		wantInterval int
+		wantDone     bool
+	}{
+		{1, 1, false},
+		{0, 6, false},
+		{0, 12, true},
+	}
+	for _, c := range cases {
+		r, done = schedule(r, quality(c.mistakes), now)
+		assert.Equal(t, c.wantInterval, r.Interval)
+		assert.Equal(t, now.AddDate(0, 0, c.wantInterval), r.Due)
+		assert.Equal(t, c.wantDone, done)
+	}
+
+	// The ease has a floor
+	r = data.Review{Ease: minEase}
+	r, _ = schedule(r, 0, now)
+	assert.Equal(t, minEase, r.Ease)
+}
+
+func TestReview(t *testing.T) {
+	e := setupSources(t, []string{
+		"alpha bravo charlie delta echo foxtrot golf hotel india juliett",
+	}, nil)
+	_, err := e.results()
+	require.NoError(t, err)
+
+	require.NoError(t, e.RecordWords(Round{}, []WordResult{
+		{Token: "alpha", Mistakes: 3},
+		{Token: "bravo"},
+		{Token: "unknown", Mistakes: 4},
+	}))
+
+	// Only mistyped words are scheduled
+	reviews, err := e.resultStore.GetReviews([]string{"alpha", "bravo", "unknown"})
+	require.NoError(t, err)
+	require.Len(t, reviews, 2)
+
+	// Pretend the relearning delay is over
+	require.NoError(t, e.resultStore.SaveReviews([]data.Review{
+		{Token: "alpha", Ease: initialEase, Due: time.Now().Add(-time.Minute)},
+		{Token: "unknown", Ease: initialEase, Due: time.Now().Add(-time.Minute)},
+	}, nil))
+
+	// Due words of the sources make it into the prompt, once
+	opts := PromptOptions{MaxLen: 40, Review: true}
+	prompt, due, err := e.generatePrompt(opts, random.New(1))
+	require.NoError(t, err)
+	words := strings.Fields(prompt)
+	assert.Contains(t, words, "alpha")
+	assert.NotContains(t, words, "unknown")
+	assert.Equal(t, []string{"alpha"}, due)
+
+	e.markReviewed(due)
+	prompt, _, err = e.generatePrompt(opts, random.New(1))
+	require.NoError(t, err)
+	assert.NotContains(t, strings.Fields(prompt), "alpha")
+
+	// Words under review are only graded when put into a prompt for review
+	require.NoError(t, e.RecordWords(Round{}, []WordResult{{Token: "alpha"}}))
+	reviews, err = e.resultStore.GetReviews([]string{"alpha"})
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	assert.Equal(t, 0, reviews[0].Repetitions)
+
+	// Typing a word cleanly three times in a row retires it
+	round := Round{Reviews: due}
+	for range reviewGraduation {
+		require.NoError(t, e.RecordWords(round, []WordResult{{Token: "alpha"}}))
+	}
+	reviews, err = e.resultStore.GetReviews([]string{"alpha"})
+	require.NoError(t, err)
+	assert.Empty(t, reviews)
+}
+
+func TestReviewReconfigure(t *testing.T) {
+	setupCacheHome(t)
+	filePath := filepath.Join(t.TempDir(), "words.txt")
+	require.NoError(t, os.WriteFile(filePath,
+		[]byte("alpha bravo charlie delta echo foxtrot golf hotel"), 0o644))
+
+	e := NewEngine()
+	t.Cleanup(func() { assert.NoError(t, e.Close()) })
+	store, err := e.results()
+	require.NoError(t, err)
+	require.NoError(t, store.SaveReviews([]data.Review{
+		{Token: "alpha", Ease: initialEase, Due: time.Now().Add(-time.Minute)},
+	}, nil))
+
+	opts := Options{Review: true}
+	require.NoError(t, e.Setup([]string{filePath}, opts, 64))
+	require.Eventually(t, func() bool {
+		return len(e.prompts) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Due words of prompts dropped unseen are still due
+	require.NoError(t, e.Reconfigure(PromptOptions{MaxLen: 40, Review: true}))
+	round, err := e.Prompt()
+	require.NoError(t, err)
+	assert.Contains(t, strings.Fields(round.Prompt), "alpha")
+	assert.Equal(t, []string{"alpha"}, round.Reviews)
+}
+
+func TestDifficulty(t *testing.T) {
+	d, err := ParseDifficulty("HARD")
+	require.NoError(t, err)
+	assert.Equal(t, DifficultyHard, d)
+	_, err = ParseDifficulty("insane")
+	assert.ErrorIs(t, err, ErrInvalidPromptOptions)
+
+	assert.Equal(t, DifficultyHard, Difficulty("").Next())
+	assert.Equal(t, DifficultyEasy, DifficultyExpert.Next())
+	assert.False(t, DifficultyNormal.HasSymbols())
+	assert.True(t, DifficultyExpert.HasSymbols())
+	assert.ErrorIs(t,
+		PromptOptions{MaxLen: 10, Difficulty: "insane"}.validate(),
+		ErrInvalidPromptOptions)
+
+	// Normal prompts are left as they are, without drawing randomness
+	tokens := []string{"alpha", "bravo", "charlie"}
+	assert.Equal(t, tokens, DifficultyNormal.settings().decorate(tokens, nil))
+
+	// Expert prompts keep the letters of their tokens in order
+	words := DifficultyExpert.settings().decorate(tokens, random.New(1))
+	letters := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(PromptSymbols, r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.Join(words, ""))
+	assert.Equal(t, "alphabravocharlie", letters)
+}
+
+func TestGeneratePromptDifficulty(t *testing.T) {
+	e := setupSources(t, []string{
+		"cat dog cat dog elephant giraffe",
+		"cat dog cat dog hippopotamus",
+	}, nil)
+
+	// Easy prompts favor short, common words
+	prompt, _, err := e.generatePrompt(
+		PromptOptions{MaxLen: 7, Difficulty: DifficultyEasy}, random.New(1))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"cat", "dog"}, strings.Fields(prompt))
+
+	// Hard prompts favor long, rare words
+	prompt, _, err = e.generatePrompt(
+		PromptOptions{MaxLen: 60, Difficulty: DifficultyHard}, random.New(1))
+	require.NoError(t, err)
+	assert.NotContains(t, strings.ToLower(prompt), "cat")
+	assert.Contains(t, strings.ToLower(prompt), "elephant")
+}
+
+func TestGeneratePromptLength(t *testing.T) {
+	e := setupSources(t, []string{
+		"cat dog fox owl notwithstanding internationalization",
+	}, nil)
+	longest := func(prompt string) int {
+		n := 0
+		for _, word := range strings.FieldsFunc(prompt, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}) {
+			n = max(n, len(word))
+		}
+		return n
+	}
+
+	// Normal prompts leave out words longer than 11 letters, which are
+	// indexed for harder difficulties
+	for seed := range uint64(8) {
+		prompt, _, err := e.generatePrompt(
+			PromptOptions{MaxLen: 60}, random.New(seed))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, longest(prompt), 11, prompt)
+	}
+
+	prompt, _, err := e.generatePrompt(
+		PromptOptions{MaxLen: 60, Difficulty: DifficultyExpert}, random.New(1))
+	require.NoError(t, err)
+	assert.Contains(t, strings.ToLower(prompt), "notwithstanding")
+	assert.Greater(t, longest(prompt), 11, prompt)
+}
+
+func TestTokenTransitions(t *testing.T) {
+	transitions := tokenTransitions("main.go",
+		[]string{"if", "err", "nil", "return", "err", "nil", "return"})
+	assert.ElementsMatch(t, []data.Transition{
+		{Path: "main.go", First: "", Second: "err", Next: "nil", Count: 1},
+		{Path: "main.go", First: "err", Second: "nil", Next: "return", Count: 2},
+		{Path: "main.go", First: "nil", Second: "return", Next: "err", Count: 1},
+		{Path: "main.go", First: "return", Second: "err", Next: "nil", Count: 1},
+	}, transitions)
+
+	assert.Empty(t, tokenTransitions("main.go", []string{"err"}))
+}
+
+func TestChainTokens(t *testing.T) {
+	e := setupSources(t, []string{"red blue green gold blue pink"}, nil)
+
+	tests := []struct {
+		name   string
+		starts []string
+		k      int
+		want   []string
+	}{
+		{"trigram", []string{"red"}, 3, []string{"red", "blue", "green"}},
+		{"other trigram", []string{"gold"}, 5, []string{"gold", "blue", "pink"}},
+		{"next start", []string{"pink", "gold"}, 3, []string{"pink", "gold", "blue"}},
+		{"no starts", nil, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The pair of tokens decides over the last token alone
+			for seed := range uint64(8) {
+				chain, err := e.chainTokens(tt.starts, tt.k, random.New(seed))
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, chain)
+			}
+		})
+	}
+}
+
+func TestGeneratePromptMarkov(t *testing.T) {
+	e := setupSources(t, []string{"alpha bravo charlie delta echo"}, nil)
+	next := map[string]string{
+		"alpha": "bravo", "bravo": "charlie", "charlie": "delta", "delta": "echo",
+	}
+
+	for seed := range uint64(8) {
+		prompt, _, err := e.generatePrompt(
+			PromptOptions{MaxLen: 80, Markov: true}, random.New(seed))
+		require.NoError(t, err)
+
+		// Sequences run to the end of the text before the next one begins
+		words := strings.Fields(prompt)
+		for i := 0; i+1 < len(words); i++ {
+			if words[i] != "echo" {
+				assert.Equal(t, next[words[i]], words[i+1], prompt)
+			}
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Mode
+		wantErr error
+	}{
+		{"normal", ModeNormal, nil},
+		{"stop-on-letter", ModeStopOnLetter, nil},
+		{"Stop-On-Word", ModeStopOnWord, nil},
+		{"sudden-death", ModeSuddenDeath, nil},
+		{"lenient", "", ErrInvalidMode},
+		{"", "", ErrInvalidMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMode(tt.name)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.True(t, ModeStopOnLetter.RequiresCorrection())
+	assert.True(t, ModeStopOnWord.RequiresCorrection())
+	assert.False(t, ModeSuddenDeath.RequiresCorrection())
+	assert.False(t, Mode("").RequiresCorrection())
+}
@@ -3,18 +3,46 @@ package domain
 import "errors"
 
 var (
-	// ErrInvalidDirPath indicates that the provided directory path is invalid.
-	ErrInvalidDirPath = errors.New("invalid directory path")
+	// ErrInvalidDirPath indicates that the provided source path is invalid.
+	ErrInvalidDirPath = errors.New("invalid source path")
 	// ErrFileOperation indicates a failure during file operations.
 	ErrFileOperation = errors.New("file operation failed")
 	// ErrTokenization indicates a failure during tokenization.
 	ErrTextProcessing = errors.New("text processing failed")
+	// ErrIneligibleSource indicates that a file or standard input source is
+	// not text or too large.
+	ErrIneligibleSource = errors.New("source is not an eligible text file")
 	// ErrEmptyDir indicates that no eligible files were found in the specified
 	// directory.
 	ErrEmptyDir = errors.New("no eligible files found in directory")
 	// ErrTooManyErrors indicates that too many errors occurred during directory
 	// processing, leading to an abort.
 	ErrTooManyErrors = errors.New("too many errors during directory processing")
+	// ErrInvalidFilter indicates that a file filter pattern or language is
+	// invalid.
+	ErrInvalidFilter = errors.New("invalid file filter")
+	// ErrInvalidWeights indicates that source weights are invalid or do not
+	// match the sources.
+	ErrInvalidWeights = errors.New("invalid source weights")
+	// ErrInvalidRevision indicates that a Git revision could not be resolved.
+	ErrInvalidRevision = errors.New("invalid git revision")
+	// ErrNoChanges indicates that the selected Git history contains no
+	// eligible file changes.
+	ErrNoChanges = errors.New("no eligible changes found in history")
+	// ErrNotRepository indicates that a source of a daily challenge is not
+	// inside a Git repository.
+	ErrNotRepository = errors.New("source is not inside a git repository")
+	// ErrInvalidDate indicates that a challenge date is malformed.
+	ErrInvalidDate = errors.New("invalid date, expected YYYY-MM-DD")
+	// ErrInvalidResults indicates that an exported results file is malformed.
+	ErrInvalidResults = errors.New("invalid results file")
+	// ErrInvalidPromptOptions indicates that prompt options are invalid, e.g.
+	// a non-positive maximum length.
+	ErrInvalidPromptOptions = errors.New("invalid prompt options")
+	// ErrInvalidMode indicates that a typing mode is unknown.
+	ErrInvalidMode = errors.New("invalid mode")
+	// ErrClosed indicates that an engine was closed.
+	ErrClosed = errors.New("engine closed")
 	// ErrNoTokensFound indicates that no tokens were found after processing the
 	// files in the specified directory.
 	ErrNoTokensFound = errors.New("no tokens found in directory")
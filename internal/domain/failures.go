@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/vupdivup/typomat/pkg/tokenizer"
+)
+
+// FailureReason classifies why a file failed to process.
+type FailureReason string
+
+const (
+	// FailurePermissionDenied indicates that the file could not be read for
+	// lack of permissions.
+	FailurePermissionDenied FailureReason = "permission denied"
+	// FailureInvalidUTF8 indicates that the file is text at first, but
+	// contains lines of invalid UTF-8 further on. The lines are skipped, so it
+	// is reported as a warning.
+	FailureInvalidUTF8 FailureReason = "invalid UTF-8"
+	// FailureReadError indicates any other failure to read the file.
+	FailureReadError FailureReason = "read error"
+)
+
+// FileFailure records a file that failed to process, or that was processed
+// only in part.
+type FileFailure struct {
+	// Path is the absolute path of the file.
+	Path string
+	// Reason classifies the failure.
+	Reason FailureReason
+	// Detail is the underlying error message.
+	Detail string
+}
+
+// newFileFailure classifies the error encountered processing the file at the
+// specified path.
+func newFileFailure(path string, err error) FileFailure {
+	reason := FailureReadError
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		reason = FailurePermissionDenied
+	case errors.Is(err, tokenizer.ErrInvalidUTF8):
+		reason = FailureInvalidUTF8
+	}
+	return FileFailure{Path: path, Reason: reason, Detail: err.Error()}
+}
+
+// ErrorOptions controls how failures of individual files are handled during
+// directory processing.
+type ErrorOptions struct {
+	// MaxErrors is the number of failed files at which processing is aborted
+	// with ErrTooManyErrors. Zero means the default of 16.
+	MaxErrors int
+	// Skip skips failed files without ever aborting, for large trees with
+	// many unreadable files.
+	Skip bool
+}
+
+// limit returns the number of failed files at which processing is aborted.
+func (o ErrorOptions) limit() int {
+	if o.MaxErrors <= 0 {
+		return defaultMaxErrors
+	}
+	return o.MaxErrors
+}
+
+// Failures returns the files that failed to process during Setup, across
+// all sources.
+func (e *Engine) Failures() []FileFailure {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.failures)
+}
+
+// addFailures records files that failed to process.
+func (e *Engine) addFailures(failures []FileFailure) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = append(e.failures, failures...)
+}
+
+// Warnings returns the files processed only in part during Setup, across all
+// sources.
+func (e *Engine) Warnings() []FileFailure {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.warnings)
+}
+
+// addWarnings records files processed only in part.
+func (e *Engine) addWarnings(warnings []FileFailure) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.warnings = append(e.warnings, warnings...)
+}
+
+// WriteFailureReport writes a report of the failed files to w, ordered by
+// reason and path. Nothing is written if there are no failures.
+func WriteFailureReport(w io.Writer, failures []FileFailure) error {
+	return writeFileReport(w, "%d file(s) failed to process:\n", failures)
+}
+
+// WriteWarningReport writes a report of the files processed only in part to
+// w, like WriteFailureReport.
+func WriteWarningReport(w io.Writer, warnings []FileFailure) error {
+	return writeFileReport(w, "%d file(s) processed with warnings:\n", warnings)
+}
+
+// writeFileReport writes a report of the files to w under a heading
+// formatted with their count.
+func writeFileReport(w io.Writer, heading string, failures []FileFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sorted := slices.Clone(failures)
+	slices.SortFunc(sorted, func(a, b FileFailure) int {
+		return cmp.Or(
+			cmp.Compare(a.Reason, b.Reason),
+			cmp.Compare(a.Path, b.Path))
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, heading, len(sorted))
+	for _, f := range sorted {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.Reason, f.Path, f.Detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/alphabet"
+	"github.com/vupdivup/typomat/pkg/files"
+	"github.com/vupdivup/typomat/pkg/tokenizer"
+	"go.uber.org/zap"
+)
+
+// StdinPath is the source path denoting standard input.
+const StdinPath = "-"
+
+// ProcessFile tokenizes a single text file into words of the alphabet and
+// stores the tokens in the store. Unlike ProcessDirectory, the file is used
+// regardless of filters.
+func (e *Engine) ProcessFile(
+	store *data.Store, path string, letters alphabet.Alphabet,
+) error {
+	file, err := os.Open(path)
+	if err != nil {
+		zap.S().Errorw("Failed to open file",
+			"file_path", path,
+			"error", err)
+		return ErrFileOperation
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		zap.S().Errorw("Failed to stat file",
+			"file_path", path,
+			"error", err)
+		return ErrFileOperation
+	}
+
+	return e.processReader(store, path, file, stat.ModTime(), letters)
+}
+
+// ProcessReader tokenizes text read from r, e.g. standard input, into words
+// of the alphabet and stores the tokens in the store under the specified
+// name.
+func (e *Engine) ProcessReader(
+	store *data.Store, name string, r io.Reader, letters alphabet.Alphabet,
+) error {
+	return e.processReader(store, name, r, time.Now(), letters)
+}
+
+// processReader reads and tokenizes text of a single source, recording it
+// with the specified modification time.
+func (e *Engine) processReader(
+	store *data.Store, name string, r io.Reader, mtime time.Time,
+	letters alphabet.Alphabet,
+) error {
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock() // nolint:errcheck
+
+	e.beginPhase(PhaseTokenizing, 1)
+
+	// Read one byte past the limit to detect oversized input
+	content, err := io.ReadAll(io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		zap.S().Errorw("Failed to read source",
+			"source", name,
+			"error", err)
+		return ErrFileOperation
+	}
+	if len(content) > maxFileSize || !files.IsTextContent(name, content) {
+		zap.S().Errorw("Source is not an eligible text file",
+			"source", name,
+			"size", len(content))
+		return ErrIneligibleSource
+	}
+
+	allTokens := tokenizeLines(strings.Split(string(content), "\n"), letters)
+	tokens := uniqueTokens(name, allTokens)
+	e.advance(name)
+	zap.S().Infow("Processed source",
+		"source", name,
+		"token_count", len(tokens))
+
+	e.beginPhase(PhaseFlushing, 0)
+	file := data.File{Path: name, Size: len(content), Mtime: mtime}
+	if err := store.SaveFiles(
+		[]data.File{file}, tokens, tokenTransitions(name, allTokens)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// tokenizeLines tokenizes lines of text into words of the alphabet, keeping
+// tokens in order.
+func tokenizeLines(lines []string, letters alphabet.Alphabet) []string {
+	var allTokens []string
+	for _, line := range lines {
+		allTokens = append(allTokens,
+			tokenizer.TokenizeString(line, letters, isWordEligible)...)
+	}
+	return allTokens
+}
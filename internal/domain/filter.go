@@ -0,0 +1,182 @@
+package domain
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/alphabet"
+	"github.com/vupdivup/typomat/pkg/files"
+	"github.com/vupdivup/typomat/pkg/glob"
+	"go.uber.org/zap"
+)
+
+// metaFilter is the metadata key recording the filter signature of the files
+// stored in the database.
+const metaFilter = "filter"
+
+// Filter restricts the files of a source directory that feed the corpus and
+// the letters of their words. Patterns follow .gitignore syntax and are
+// matched against paths relative to the directory. See glob.Pattern for
+// details.
+type Filter struct {
+	// Include, if not empty, limits files to those matching any of the
+	// patterns or languages.
+	Include []string
+	// Exclude rules out files matching any of the patterns.
+	Exclude []string
+	// Langs, if not empty, limits files to those with an extension of any of
+	// the languages, e.g. "go" or "ts". Combined with Include.
+	Langs []string
+	// Alphabet holds the letters words may consist of. Words with other
+	// letters are dropped. The zero value is the English alphabet.
+	Alphabet alphabet.Alphabet
+}
+
+// fileMatcher is a compiled Filter.
+type fileMatcher struct {
+	// root is the absolute path of the directory that paths are relative to.
+	root string
+	// include are the compiled include patterns, including languages.
+	include []*glob.Pattern
+	// exclude are the compiled exclude patterns.
+	exclude []*glob.Pattern
+}
+
+// includePatterns returns the include patterns of the filter, with languages
+// translated to extension patterns.
+func (f Filter) includePatterns() ([]string, error) {
+	patterns := slices.Clone(f.Include)
+	for _, lang := range f.Langs {
+		exts, ok := files.LanguageExtensions(lang)
+		if !ok {
+			zap.S().Errorw("Unknown language",
+				"lang", lang)
+			return nil, ErrInvalidFilter
+		}
+		for _, ext := range exts {
+			patterns = append(patterns, "*"+ext)
+		}
+	}
+	return patterns, nil
+}
+
+// signature returns a canonical representation of the filter, equal for
+// filters selecting the same files and letters. The signature of an empty
+// filter is empty.
+func (f Filter) signature() (string, error) {
+	include, err := f.includePatterns()
+	if err != nil {
+		return "", err
+	}
+	letters := f.Alphabet.Extra()
+	if len(include) == 0 && len(f.Exclude) == 0 && letters == "" {
+		return "", nil
+	}
+
+	slices.Sort(include)
+	exclude := slices.Sorted(slices.Values(f.Exclude))
+	signature := "include=" + strings.Join(slices.Compact(include), "\x00") +
+		"\nexclude=" + strings.Join(slices.Compact(exclude), "\x00")
+	if letters != "" {
+		// Kept out of signatures of English filters, so caches built
+		// before alphabets were configurable stay valid
+		signature += "\nletters=" + letters
+	}
+	return signature, nil
+}
+
+// compile compiles the filter for paths inside the specified root directory.
+func (f Filter) compile(root string) (*fileMatcher, error) {
+	include, err := f.includePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &fileMatcher{root: root}
+	compileAll := func(patterns []string) ([]*glob.Pattern, error) {
+		var compiled []*glob.Pattern
+		for _, pattern := range patterns {
+			p, err := glob.Compile(pattern)
+			if err != nil {
+				zap.S().Errorw("Invalid file filter pattern",
+					"pattern", pattern,
+					"error", err)
+				return nil, ErrInvalidFilter
+			}
+			compiled = append(compiled, p)
+		}
+		return compiled, nil
+	}
+	if m.include, err = compileAll(include); err != nil {
+		return nil, err
+	}
+	if m.exclude, err = compileAll(f.Exclude); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// match reports whether the file at the specified absolute path passes the
+// filter.
+func (m *fileMatcher) match(path string) bool {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	matchAny := func(patterns []*glob.Pattern) bool {
+		return slices.ContainsFunc(patterns, func(p *glob.Pattern) bool {
+			return p.Match(rel)
+		})
+	}
+
+	if len(m.include) > 0 && !matchAny(m.include) {
+		return false
+	}
+	return !matchAny(m.exclude)
+}
+
+// filterPaths returns the paths passing the filter.
+func (m *fileMatcher) filterPaths(paths []string) []string {
+	return slices.DeleteFunc(paths, func(path string) bool {
+		return !m.match(path)
+	})
+}
+
+// dirDbId returns the database ID of a directory source selected by the
+// filter. Each filter gets a database of its own, so sessions with different
+// filters do not rebuild each other's cache. Unfiltered directories keep the
+// directory path as their ID.
+func dirDbId(dirPath string, filter Filter) (string, error) {
+	signature, err := filter.signature()
+	if err != nil || signature == "" {
+		return dirPath, err
+	}
+	return dirPath + "\x00filter\x00" + signature, nil
+}
+
+// applyFilterSignature records the filter signature in the database. If the
+// database holds files selected by a different filter, they are cleared so
+// that the corpus is rebuilt rather than mixed with stale tokens.
+func applyFilterSignature(store *data.Store, dirPath, signature string) error {
+	stored, _, err := store.GetMeta(metaFilter)
+	if err != nil {
+		return err
+	}
+	if stored == signature {
+		return nil
+	}
+
+	zap.S().Infow("File filter changed, rebuilding database",
+		"dir_path", dirPath,
+		"previous_filter", stored,
+		"filter", signature)
+	if err := store.Clear(); err != nil {
+		return err
+	}
+	return store.SetMeta(metaFilter, signature)
+}
@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/files"
+	"github.com/vupdivup/typomat/pkg/git"
+	"go.uber.org/zap"
+)
+
+// HistoryOptions selects the changes of a directory's Git history to practice
+// on.
+type HistoryOptions struct {
+	git.HistoryOptions
+	// Hunks limits tokens to the lines added by the selected commits rather
+	// than the entire changed files.
+	Hunks bool
+}
+
+// dbId returns the database identifier of the history selection for the
+// specified directory and filter. Each selection gets a database of its own.
+func (o HistoryOptions) dbId(dirPath string, filter Filter) (string, error) {
+	signature, err := filter.signature()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\x00history\x00%d\x00%s\x00%s\x00%t\x00%s",
+		dirPath, o.MaxCommits, o.Base, o.Author, o.Hunks, signature), nil
+}
+
+// ProcessHistory tokenizes the text files changed by the selected commits of
+// the Git repository enclosing the specified directory and stores the tokens
+// in the store. Only files inside the directory passing the filter are
+// considered.
+//
+// The repository's object database is read directly, so files are tokenized
+// as of the most recent selected commit changing them, regardless of the
+// state of the work tree.
+func (e *Engine) ProcessHistory(
+	store *data.Store, dirPath string, opts HistoryOptions, filter Filter,
+) error {
+	matcher, err := filter.compile(dirPath)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Lock(); err != nil {
+		return err
+	}
+	defer store.Unlock() // nolint:errcheck
+
+	e.beginPhase(PhaseListing, 0)
+	changes, err := git.Changes(dirPath, opts.HistoryOptions)
+	if errors.Is(err, git.ErrNotRepository) {
+		zap.S().Errorw("Directory is not inside a Git repository",
+			"dir_path", dirPath)
+		return ErrInvalidDirPath
+	} else if errors.Is(err, git.ErrUnknownRevision) {
+		zap.S().Errorw("Failed to resolve base revision",
+			"dir_path", dirPath,
+			"base", opts.Base,
+			"error", err)
+		return ErrInvalidRevision
+	} else if err != nil {
+		zap.S().Errorw("Failed to read Git history",
+			"dir_path", dirPath,
+			"error", err)
+		return ErrFileOperation
+	}
+	zap.S().Infow("Starting history processing",
+		"dir_path", dirPath,
+		"max_commits", opts.MaxCommits,
+		"base", opts.Base,
+		"author", opts.Author,
+		"hunks", opts.Hunks,
+		"file_count", len(changes))
+
+	e.beginPhase(PhaseTokenizing, len(changes))
+	var fileRecords []data.File
+	var tokens []data.Token
+	var transitions []data.Transition
+	for _, change := range changes {
+		e.advance(change.Path)
+
+		if !matcher.match(change.Path) ||
+			!files.IsTextContent(change.Path, change.Content) ||
+			len(change.Content) >= maxFileSize {
+			zap.S().Debugw("Skipping ineligible file",
+				"file_path", change.Path)
+			continue
+		}
+
+		// Tokenize either the added lines or the whole file
+		lines := change.AddedLines
+		if !opts.Hunks {
+			lines = strings.Split(string(change.Content), "\n")
+		}
+		allTokens := tokenizeLines(lines, filter.Alphabet)
+		fileTokens := uniqueTokens(change.Path, allTokens)
+		if len(fileTokens) == 0 {
+			continue
+		}
+		zap.S().Debugw("Processing changed file",
+			"file_path", change.Path,
+			"token_count", len(fileTokens))
+
+		fileRecords = append(fileRecords, data.File{
+			Path: change.Path, Size: len(change.Content), Mtime: change.Time,
+		})
+		tokens = append(tokens, fileTokens...)
+		transitions = append(transitions, tokenTransitions(change.Path, allTokens)...)
+	}
+
+	if len(fileRecords) == 0 {
+		zap.S().Errorw("No eligible changes found in history",
+			"dir_path", dirPath)
+		return ErrNoChanges
+	}
+
+	e.beginPhase(PhaseFlushing, 0)
+	if err := store.SaveFiles(fileRecords, tokens, transitions); err != nil {
+		return err
+	}
+
+	return nil
+}
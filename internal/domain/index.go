@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"go.uber.org/zap"
+)
+
+// IndexSummary describes the changes made to a store by IndexDirectory.
+type IndexSummary struct {
+	// Files is the number of files found in the directory passing the filter.
+	Files int
+	// New is the number of files tokenized for the first time.
+	New int
+	// Changed is the number of files tokenized again because they changed.
+	Changed int
+	// Unchanged is the number of files skipped because they did not change.
+	Unchanged int
+	// Ineligible is the number of files skipped because they are not text or
+	// too large.
+	Ineligible int
+	// Removed is the number of files removed from the store because they no
+	// longer exist or pass the filter.
+	Removed int
+	// Tokens is the number of tokens stored for new and changed files.
+	Tokens int
+	// Errors is the number of files that failed to process.
+	Errors int
+	// Failures are the files that failed to process, with reasons.
+	Failures []FileFailure
+	// Warnings are the files processed in part, with reasons, e.g. because
+	// some of their lines are not valid UTF-8. They do not count as errors.
+	Warnings []FileFailure
+	// Elapsed is the duration of indexing.
+	Elapsed time.Duration
+}
+
+// count records a processed file of the specified status.
+func (s *IndexSummary) count(status FileStatus) {
+	switch status {
+	case FileStatusNew:
+		s.New++
+	case FileStatusChanged:
+		s.Changed++
+	case FileStatusUnchanged:
+		s.Unchanged++
+	case FileStatusIneligible:
+		s.Ineligible++
+	}
+}
+
+// Index builds or refreshes the cached database of the specified directory
+// with files passing the filter, and returns a summary of the changes. Its
+// progress is reported by Progress and to subscribers, see Subscribe. Files
+// failing to process are handled according to the error options.
+func (e *Engine) Index(
+	dirPath string, filter Filter, errOpts ErrorOptions,
+) (IndexSummary, error) {
+	src, err := resolveSource(dirPath)
+	if err != nil {
+		return IndexSummary{}, err
+	}
+	if src.kind != sourceDir {
+		zap.S().Errorw("Only directories can be indexed",
+			"path", dirPath)
+		return IndexSummary{}, ErrInvalidDirPath
+	}
+
+	dbId, err := dirDbId(src.path, filter)
+	if err != nil {
+		return IndexSummary{}, err
+	}
+
+	e.startSources(1)
+	e.beginSource(src.path)
+
+	store, err := data.Open(dbId, true)
+	if err != nil {
+		zap.S().Errorw("Failed to setup database",
+			"dir_path", src.path,
+			"error", err)
+		return IndexSummary{}, err
+	}
+
+	summary, err := e.IndexDirectory(store, src.path, filter, errOpts)
+	e.finishSource()
+	zap.S().Infow("Indexed directory",
+		"dir_path", src.path,
+		"summary", summary,
+		"error", err)
+	return summary, errors.Join(err, store.Close())
+}
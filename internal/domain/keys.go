@@ -0,0 +1,236 @@
+package domain
+
+import (
+	"cmp"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/layout"
+	"go.uber.org/zap"
+)
+
+const (
+	// minWeakFingerPresses is the number of presses a finger needs before its
+	// error rate is trusted to find weak fingers.
+	minWeakFingerPresses = 20
+	// drillOversampling is the factor of tokens sampled beyond those needed
+	// for a drill prompt, to pick the ones typed most by the drilled fingers.
+	drillOversampling = 4
+)
+
+// Keystroke is a key press typing a character of a prompt.
+type Keystroke struct {
+	// Expected is the character of the prompt the key press should type.
+	Expected rune
+	// Mistake indicates that another character was typed.
+	Mistake bool
+	// Latency is the time since the previous key press. Zero for the first
+	// key press of a round.
+	Latency time.Duration
+}
+
+// KeyStats summarizes how a group of keys, e.g. a character or the keys of a
+// finger, was typed.
+type KeyStats struct {
+	// Presses is the number of key presses.
+	Presses int
+	// Errors is the number of mistyped key presses.
+	Errors int
+	// Latency is the total latency of the timed key presses.
+	Latency time.Duration
+	// Timed is the number of key presses with a latency.
+	Timed int
+}
+
+// ErrorRate returns the share of mistyped key presses, zero if there are none.
+func (s KeyStats) ErrorRate() float64 {
+	if s.Presses == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Presses)
+}
+
+// AvgLatency returns the average latency of the timed key presses.
+func (s KeyStats) AvgLatency() time.Duration {
+	if s.Timed == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Timed)
+}
+
+// add adds the key presses of o.
+func (s *KeyStats) add(o KeyStats) {
+	s.Presses += o.Presses
+	s.Errors += o.Errors
+	s.Latency += o.Latency
+	s.Timed += o.Timed
+}
+
+// Drill biases prompts toward tokens typed with specific fingers.
+type Drill struct {
+	// Layout is the keyboard layout assigning characters to fingers.
+	Layout *layout.Layout
+	// Fingers are the fingers to practice.
+	Fingers []layout.Finger
+}
+
+// score returns the share of the characters of the token typed with the
+// drilled fingers.
+func (d *Drill) score(token string) float64 {
+	hits := 0
+	for _, c := range token {
+		if k, ok := d.Layout.Key(c); ok && slices.Contains(d.Fingers, k.Finger) {
+			hits++
+		}
+	}
+	return float64(hits) / float64(max(utf8.RuneCountInString(token), 1))
+}
+
+// pick returns the k tokens scoring highest, keeping the order of tokens
+// among equal scores.
+func (d *Drill) pick(tokens []string, k int) []string {
+	sorted := slices.Clone(tokens)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return cmp.Compare(d.score(b), d.score(a))
+	})
+	return sorted[:min(k, len(sorted))]
+}
+
+// RecordKeystrokes adds the key presses of a round to the key stats kept
+// across sessions, see Engine.LoadKeyStats.
+func (e *Engine) RecordKeystrokes(keystrokes []Keystroke) error {
+	if len(keystrokes) == 0 {
+		return nil
+	}
+
+	byChar := map[rune]*data.KeyStat{}
+	for _, k := range keystrokes {
+		stat, ok := byChar[k.Expected]
+		if !ok {
+			stat = &data.KeyStat{Char: string(k.Expected)}
+			byChar[k.Expected] = stat
+		}
+		stat.Presses++
+		if k.Mistake {
+			stat.Errors++
+		}
+		if k.Latency > 0 {
+			stat.LatencyMs += k.Latency.Milliseconds()
+			stat.Timed++
+		}
+	}
+	stats := make([]data.KeyStat, 0, len(byChar))
+	for _, stat := range byChar {
+		stats = append(stats, *stat)
+	}
+
+	store, err := e.results()
+	if err != nil {
+		return err
+	}
+	return store.AddKeyStats(stats)
+}
+
+// results returns the results database, opening it on first use. It is
+// shared by the daily challenge, key stats and reviews.
+func (e *Engine) results() (*data.ResultStore, error) {
+	e.promptMu.Lock()
+	defer e.promptMu.Unlock()
+
+	if e.resultStore == nil {
+		store, err := data.OpenResults()
+		if err != nil {
+			return nil, err
+		}
+		e.resultStore = store
+	}
+	return e.resultStore, nil
+}
+
+// LoadKeyStats retrieves the key stats recorded across sessions, by
+// character.
+func (e *Engine) LoadKeyStats() (map[rune]KeyStats, error) {
+	store, err := e.results()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := store.GetKeyStats()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[rune]KeyStats{}
+	for _, r := range records {
+		c, size := utf8.DecodeRuneInString(r.Char)
+		if size == 0 || size != len(r.Char) {
+			zap.S().Warnw("Skipping invalid key stat",
+				"char", r.Char)
+			continue
+		}
+		stats[c] = KeyStats{
+			Presses: r.Presses,
+			Errors:  r.Errors,
+			Latency: time.Duration(r.LatencyMs) * time.Millisecond,
+			Timed:   r.Timed,
+		}
+	}
+	return stats, nil
+}
+
+// StatsByKey sums the key stats per key of the layout, e.g. of both cases of
+// a letter, by the character of the key. Characters without a key in the
+// layout are left out.
+func StatsByKey(stats map[rune]KeyStats, l *layout.Layout) map[rune]KeyStats {
+	return groupStats(stats, l, func(k layout.Key) rune { return k.Char })
+}
+
+// StatsByFinger sums the key stats per finger of the layout. Characters
+// without a key in the layout are left out.
+func StatsByFinger(stats map[rune]KeyStats, l *layout.Layout) map[layout.Finger]KeyStats {
+	return groupStats(stats, l, func(k layout.Key) layout.Finger { return k.Finger })
+}
+
+// StatsByRow sums the key stats per row of the layout. Characters without a
+// key in the layout are left out.
+func StatsByRow(stats map[rune]KeyStats, l *layout.Layout) map[layout.Row]KeyStats {
+	return groupStats(stats, l, func(k layout.Key) layout.Row { return k.Row })
+}
+
+// groupStats sums the key stats per group of the keys of their characters.
+func groupStats[G comparable](
+	stats map[rune]KeyStats, l *layout.Layout, group func(layout.Key) G,
+) map[G]KeyStats {
+	groups := map[G]KeyStats{}
+	for c, s := range stats {
+		k, ok := l.Key(c)
+		if !ok {
+			continue
+		}
+		g := groups[group(k)]
+		g.add(s)
+		groups[group(k)] = g
+	}
+	return groups
+}
+
+// WeakFingers returns up to n fingers with the highest error rates, worst
+// first. Thumbs and fingers with too few presses to judge are left out.
+func WeakFingers(stats map[rune]KeyStats, l *layout.Layout, n int) []layout.Finger {
+	byFinger := StatsByFinger(stats, l)
+
+	var fingers []layout.Finger
+	for finger, s := range byFinger {
+		if finger != layout.Thumb && s.Presses >= minWeakFingerPresses && s.Errors > 0 {
+			fingers = append(fingers, finger)
+		}
+	}
+	slices.SortFunc(fingers, func(a, b layout.Finger) int {
+		return cmp.Or(
+			cmp.Compare(byFinger[b].ErrorRate(), byFinger[a].ErrorRate()),
+			cmp.Compare(a, b))
+	})
+	return fingers[:min(n, len(fingers))]
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"math/rand/v2"
+	"slices"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"github.com/vupdivup/typomat/pkg/random"
+	"go.uber.org/zap"
+)
+
+// metaTransitions is the metadata key recording that the token transitions
+// of the files are stored in the database. Databases cached by earlier
+// versions lack them.
+const metaTransitions = "transitions"
+
+// applyTransitions makes sure the database stores the token transitions of
+// its files. Databases holding files without them are cleared, so that the
+// files are tokenized again rather than skipped as unchanged.
+func applyTransitions(store *data.Store, dirPath string) error {
+	_, ok, err := store.GetMeta(metaTransitions)
+	if err != nil || ok {
+		return err
+	}
+
+	files, err := store.GetFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		zap.S().Infow("Database lacks token transitions, rebuilding database",
+			"dir_path", dirPath)
+		if err := store.Clear(); err != nil {
+			return err
+		}
+	}
+	return store.SetMeta(metaTransitions, "1")
+}
+
+// tokenTransitions counts the trigrams of the eligible tokens, in the order
+// extracted from the file at the specified path. The first trigram starts
+// with an empty token, so every bigram of the file is counted once.
+func tokenTransitions(path string, allTokens []string) []data.Transition {
+	type trigram struct{ first, second, next string }
+	counts := map[trigram]int{}
+	var order []trigram
+
+	var first, second string
+	for _, token := range allTokens {
+		if !isTokenEligible(token) {
+			continue
+		}
+		if second != "" {
+			t := trigram{first, second, token}
+			if counts[t] == 0 {
+				order = append(order, t)
+			}
+			counts[t]++
+		}
+		first, second = second, token
+	}
+
+	transitions := []data.Transition{}
+	for _, t := range order {
+		transitions = append(transitions, data.Transition{
+			Path: path, First: t.first, Second: t.second, Next: t.next,
+			Count: counts[t],
+		})
+	}
+	return transitions
+}
+
+// nextTokens returns the tokens following second across all sources, along
+// with how often they do. If first is not empty, only the tokens following
+// first and second in a row are counted.
+func (e *Engine) nextTokens(first, second string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, src := range e.sources {
+		srcCounts, err := src.store.NextTokens(first, second)
+		if err != nil {
+			return nil, err
+		}
+		for t, n := range srcCounts {
+			counts[t] += n
+		}
+	}
+	return counts, nil
+}
+
+// chainTokens strings up to k tokens into sequences as they occur in the
+// sources, drawing randomness from r. Each sequence begins with the next of
+// the starting tokens and continues with tokens drawn by how often they
+// follow the previous two tokens, or the previous one if the pair is not
+// followed by any, until no token follows.
+func (e *Engine) chainTokens(starts []string, k int, r *rand.Rand) ([]string, error) {
+	var chain []string
+	var prev, last string
+	for len(chain) < k {
+		// Begin a new sequence
+		if last == "" {
+			if len(starts) == 0 {
+				break
+			}
+			prev, last = "", starts[0]
+			starts = starts[1:]
+			chain = append(chain, last)
+			continue
+		}
+
+		counts, err := e.nextTokens(prev, last)
+		if err != nil {
+			return nil, err
+		}
+		if len(counts) == 0 && prev != "" {
+			if counts, err = e.nextTokens("", last); err != nil {
+				return nil, err
+			}
+		}
+		if len(counts) == 0 {
+			last = ""
+			continue
+		}
+
+		prev, last = last, pickWeighted(counts, r)
+		chain = append(chain, last)
+	}
+	return chain, nil
+}
+
+// pickWeighted returns one of the tokens with probability proportional to its
+// count, drawing randomness from r.
+func pickWeighted(counts map[string]int, r *rand.Rand) string {
+	// Map order is random, so sort to keep seeded prompts reproducible
+	tokens := make([]string, 0, len(counts))
+	total := 0
+	for t, n := range counts {
+		tokens = append(tokens, t)
+		total += n
+	}
+	slices.Sort(tokens)
+
+	x := random.IntN(r, total)
+	for _, t := range tokens {
+		x -= counts[t]
+		if x < 0 {
+			return t
+		}
+	}
+	return tokens[len(tokens)-1]
+}
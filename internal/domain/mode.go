@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Mode is how strictly mistakes are handled while typing a prompt.
+type Mode string
+
+const (
+	// ModeNormal marks mistakes and moves on. It is the default.
+	ModeNormal Mode = "normal"
+	// ModeStopOnLetter does not accept a wrong key, so the cursor only
+	// advances once the right one is pressed.
+	ModeStopOnLetter Mode = "stop-on-letter"
+	// ModeStopOnWord makes a word typed with a mistake be typed again from
+	// its start.
+	ModeStopOnWord Mode = "stop-on-word"
+	// ModeSuddenDeath fails the round on the first mistake.
+	ModeSuddenDeath Mode = "sudden-death"
+)
+
+// Modes returns all modes, from the most lenient.
+func Modes() []Mode {
+	return []Mode{ModeNormal, ModeStopOnLetter, ModeStopOnWord, ModeSuddenDeath}
+}
+
+// ParseMode returns the mode of the specified name, e.g. "sudden-death".
+func ParseMode(name string) (Mode, error) {
+	m := Mode(strings.ToLower(name))
+	if !slices.Contains(Modes(), m) {
+		zap.S().Errorw("Unknown mode",
+			"mode", name)
+		return "", fmt.Errorf("%w: %q", ErrInvalidMode, name)
+	}
+	return m, nil
+}
+
+// orNormal returns the mode, or ModeNormal if it is unset.
+func (m Mode) orNormal() Mode {
+	if m == "" {
+		return ModeNormal
+	}
+	return m
+}
+
+// RequiresCorrection reports whether mistakes must be corrected before
+// moving on, so that the typed text ends up matching the prompt. Accuracy is
+// then judged by the key presses rather than the typed text.
+func (m Mode) RequiresCorrection() bool {
+	return m == ModeStopOnLetter || m == ModeStopOnWord
+}
@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/vupdivup/typomat/pkg/random"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPromptAttempts is the number of attempts at generating a prompt
+	// before a transient error is passed on to Prompt.
+	maxPromptAttempts = 3
+	// promptRetryDelay is the delay before the first retry of a failed prompt
+	// generation. It doubles with each further attempt.
+	promptRetryDelay = 100 * time.Millisecond
+	// promptErrorDelay is the delay before generating prompts again after an
+	// error was passed on, e.g. until a watched directory has tokens.
+	promptErrorDelay = time.Second
+)
+
+// PromptOptions configures the prompts generated by an engine. They are set
+// by Setup and can be changed with Reconfigure.
+type PromptOptions struct {
+	// MaxLen is the maximum length of a prompt in characters.
+	MaxLen int
+	// Drill, if set, biases prompts toward tokens typed with specific
+	// fingers.
+	Drill *Drill
+	// Review mixes words due for review into prompts, see RecordWords.
+	// Prompts then depend on past sessions, so seeded sessions are not
+	// reproducible.
+	Review bool
+	// Difficulty is the difficulty of prompts. Empty means
+	// DifficultyNormal.
+	Difficulty Difficulty
+	// Markov strings the words of prompts into sequences following the
+	// token bigrams and trigrams of the sources, e.g. "err nil return err",
+	// rather than shuffling them. Difficulty and Drill only pick the first
+	// word of each sequence.
+	Markov bool
+}
+
+// validate checks that the prompt options can be used for generation.
+func (o PromptOptions) validate() error {
+	if o.MaxLen < 1 {
+		zap.S().Errorw("Invalid maximum prompt length",
+			"max_len", o.MaxLen)
+		return ErrInvalidPromptOptions
+	}
+	if d := o.Drill; d != nil && (d.Layout == nil || len(d.Fingers) == 0) {
+		zap.S().Errorw("Drill without layout or fingers")
+		return ErrInvalidPromptOptions
+	}
+	if _, ok := difficulties[o.Difficulty.orNormal()]; !ok {
+		zap.S().Errorw("Unknown difficulty",
+			"difficulty", o.Difficulty)
+		return ErrInvalidPromptOptions
+	}
+	return nil
+}
+
+// fetchResult encapsulates the result of a prompt generation.
+type fetchResult struct {
+	// round is the generated round, numbered by Prompt.
+	round Round
+	// err is any error encountered during prompt generation.
+	err error
+	// generation is the generation of the prompt options the round was
+	// generated with.
+	generation int
+}
+
+// Reconfigure changes the options of prompts generated from now on. Pooled
+// prompts are discarded, so rounds returned by Prompt after Reconfigure
+// returns follow the new options. Round numbers continue across changes.
+func (e *Engine) Reconfigure(opts PromptOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if e.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	e.promptMu.Lock()
+	e.promptOpts = opts
+	e.generation++
+	e.generationRounds = e.rounds
+	e.promptMu.Unlock()
+
+	// Wake the producer if it waits with a prompt of the old options
+	select {
+	case e.reconfigured <- struct{}{}:
+	default:
+	}
+
+	zap.S().Infow("Reconfigured prompt generation",
+		"max_len", opts.MaxLen,
+		"difficulty", opts.Difficulty.orNormal(),
+		"markov", opts.Markov)
+	return nil
+}
+
+// PromptOptions returns the options of prompts generated from now on.
+func (e *Engine) PromptOptions() PromptOptions {
+	opts, _, _ := e.currentPromptOptions()
+	return opts
+}
+
+// currentPromptOptions returns the current prompt options, their generation
+// and the number of rounds returned before it.
+func (e *Engine) currentPromptOptions() (PromptOptions, int, int) {
+	e.promptMu.Lock()
+	defer e.promptMu.Unlock()
+	return e.promptOpts, e.generation, e.generationRounds
+}
+
+// produce continuously generates prompts and sends them to the prompts
+// channel in order until the engine is closed. Run as a goroutine.
+//
+// Each prompt draws from a random source of its own, derived from the seed,
+// the generation and the number of the round it becomes. Seeded sessions thus
+// reproduce their prompts regardless of how many prompts were pooled and
+// dropped by reconfiguration.
+//
+// Failed generations are retried with increasing delays. Errors are passed on
+// once retries are exhausted, or right away if retrying is futile, after
+// which generation resumes with a delay. Reconfiguration drops the prompt
+// being sent, as it follows the old options.
+func (e *Engine) produce() {
+	attempt := 0
+	lastGeneration, produced := 0, 0
+	for {
+		opts, generation, rounds := e.currentPromptOptions()
+		if generation != lastGeneration {
+			lastGeneration, produced = generation, 0
+		}
+		number := rounds + produced + 1
+		r := random.NewStream(e.seed, uint64(generation)<<32|uint64(number))
+		prompt, reviews, err := e.generatePrompt(opts, r)
+		if err != nil {
+			attempt++
+			zap.S().Errorw("Failed to generate prompt",
+				"attempt", attempt,
+				"error", err)
+			if isTransient(err) && attempt < maxPromptAttempts {
+				if !e.pause(promptRetryDelay << (attempt - 1)) {
+					return
+				}
+				continue
+			}
+		}
+		attempt = 0
+
+		result := fetchResult{err: err, generation: generation}
+		if err == nil {
+			result.round = Round{
+				Prompt: prompt, Seed: e.seed,
+				Difficulty: opts.Difficulty.orNormal(), Reviews: reviews,
+			}
+			if e.challenge != nil {
+				result.round.Challenge = e.challenge.ID
+			}
+			zap.S().Debugw("Generated new prompt",
+				"max_len", opts.MaxLen,
+				"difficulty", result.round.Difficulty,
+				"prompt", prompt,
+				"seed", result.round.Seed)
+		}
+
+		select {
+		case e.prompts <- result:
+			if err == nil {
+				produced++
+			} else if !e.pause(promptErrorDelay) {
+				return
+			}
+		case <-e.reconfigured:
+		case <-e.ctx.Done():
+			return
+		}
+	}
+}
+
+// pause waits for the specified duration, returning early on
+// reconfiguration. It returns false if the engine was closed meanwhile.
+func (e *Engine) pause(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-e.reconfigured:
+	case <-e.ctx.Done():
+		return false
+	}
+	return true
+}
+
+// isTransient returns true if a failed prompt generation may succeed when
+// retried, e.g. after a database hiccup.
+func isTransient(err error) bool {
+	return !errors.Is(err, ErrNoTokensFound)
+}
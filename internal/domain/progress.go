@@ -0,0 +1,214 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase is a stage of processing a source.
+type Phase int
+
+const (
+	// PhaseListing is the listing of the files of a source, e.g. those of a
+	// directory or changed in Git history.
+	PhaseListing Phase = iota
+	// PhaseTokenizing is the tokenization of files.
+	PhaseTokenizing
+	// PhaseFlushing is the storing of the remaining tokens in the database.
+	PhaseFlushing
+	// PhaseCleaningUp is the removal of files that no longer exist from the
+	// database.
+	PhaseCleaningUp
+	// PhaseDone indicates that all sources are processed.
+	PhaseDone
+)
+
+// String returns the human-readable name of the phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseListing:
+		return "listing"
+	case PhaseTokenizing:
+		return "tokenizing"
+	case PhaseFlushing:
+		return "flushing"
+	case PhaseCleaningUp:
+		return "cleaning up"
+	case PhaseDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// ProgressEvent describes the progress of source processing at a point in
+// time.
+type ProgressEvent struct {
+	// Phase is the phase of processing the current source.
+	Phase Phase
+	// Source is the path of the source being processed.
+	Source string
+	// SourcesDone is the number of sources processed completely.
+	SourcesDone int
+	// NumSources is the total number of sources to process.
+	NumSources int
+	// FilesDone is the number of files of the current source tokenized so far.
+	FilesDone int
+	// NumFiles is the number of files of the current source to tokenize. Zero
+	// while listing.
+	NumFiles int
+	// File is the path of the file tokenized most recently.
+	File string
+	// ETA is the estimated time until the files of the current source are
+	// tokenized. Zero if unknown.
+	ETA time.Duration
+}
+
+// Fraction returns the overall progress as a fraction between 0 and 1.
+func (p ProgressEvent) Fraction() float64 {
+	if p.Phase == PhaseDone {
+		return 1
+	}
+	if p.NumSources == 0 {
+		return 0
+	}
+
+	current := 0.0
+	if p.NumFiles > 0 {
+		current = float64(p.FilesDone) / float64(p.NumFiles)
+	}
+	return min((float64(p.SourcesDone)+current)/float64(p.NumSources), 1)
+}
+
+// String describes the event for display, e.g. "tokenizing 120/900 files,
+// ~3s left". Sources are counted if there are several.
+func (p ProgressEvent) String() string {
+	desc := p.Phase.String()
+	if p.Phase == PhaseTokenizing && p.NumFiles > 0 {
+		desc += fmt.Sprintf(" %d/%d files", p.FilesDone, p.NumFiles)
+		if eta := p.ETA.Round(time.Second); eta > 0 {
+			desc += fmt.Sprintf(", ~%s left", eta)
+		}
+	}
+	if p.NumSources > 1 && p.Phase != PhaseDone {
+		desc = fmt.Sprintf("source %d/%d, %s",
+			min(p.SourcesDone+1, p.NumSources), p.NumSources, desc)
+	}
+	return desc
+}
+
+// Subscribe returns a channel delivering the progress events of the engine,
+// starting with the current state. A subscriber that falls behind misses
+// intermediate events, but always receives the latest one. The channel is
+// closed when the engine is closed.
+func (e *Engine) Subscribe() <-chan ProgressEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan ProgressEvent, 1)
+	ch <- e.state
+	if e.closed {
+		close(ch)
+	} else {
+		e.subscribers = append(e.subscribers, ch)
+	}
+	return ch
+}
+
+// Progress returns the current progress of source processing as a float
+// between 0 and 1.
+func (e *Engine) Progress() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state.Fraction()
+}
+
+// update applies a change to the progress state and publishes the result to
+// subscribers.
+func (e *Engine) update(change func(state *ProgressEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	change(&e.state)
+	for _, ch := range e.subscribers {
+		// Replace a pending event nobody received yet. Sending cannot block,
+		// as the lock makes this the only sender.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- e.state
+	}
+}
+
+// startSources resets progress for processing the specified number of
+// sources.
+func (e *Engine) startSources(n int) {
+	e.update(func(state *ProgressEvent) {
+		*state = ProgressEvent{NumSources: n}
+	})
+}
+
+// beginSource records the start of processing the source at the specified
+// path.
+func (e *Engine) beginSource(path string) {
+	e.update(func(state *ProgressEvent) {
+		state.Source = path
+		state.Phase = PhaseListing
+		state.FilesDone, state.NumFiles = 0, 0
+		state.File = ""
+		state.ETA = 0
+	})
+}
+
+// beginPhase records the start of a phase of processing the current source.
+// Starting to tokenize resets the file counts to the specified number of
+// files.
+func (e *Engine) beginPhase(phase Phase, numFiles int) {
+	e.update(func(state *ProgressEvent) {
+		state.Phase = phase
+		if phase == PhaseTokenizing {
+			state.FilesDone, state.NumFiles = 0, numFiles
+			state.ETA = 0
+			e.tokenizeStart = time.Now()
+		}
+	})
+}
+
+// advance records a file of the current source as tokenized and updates the
+// estimated remaining time.
+func (e *Engine) advance(path string) {
+	e.update(func(state *ProgressEvent) {
+		state.FilesDone++
+		state.File = path
+
+		elapsed := time.Since(e.tokenizeStart)
+		remaining := max(state.NumFiles-state.FilesDone, 0)
+		state.ETA = elapsed / time.Duration(state.FilesDone) *
+			time.Duration(remaining)
+	})
+}
+
+// finishSource records the current source as processed completely.
+func (e *Engine) finishSource() {
+	e.update(func(state *ProgressEvent) {
+		state.SourcesDone++
+		state.FilesDone, state.NumFiles = 0, 0
+		state.ETA = 0
+		if state.SourcesDone >= state.NumSources {
+			state.Phase = PhaseDone
+		}
+	})
+}
+
+// closeSubscribers closes the channels of all subscribers.
+func (e *Engine) closeSubscribers() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for _, ch := range e.subscribers {
+		close(ch)
+	}
+	e.subscribers = nil
+}
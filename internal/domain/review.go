@@ -0,0 +1,212 @@
+package domain
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/vupdivup/typomat/internal/data"
+	"go.uber.org/zap"
+)
+
+const (
+	// initialEase is the ease of words new to review, as in SM-2.
+	initialEase = 2.5
+	// minEase is the lowest ease, keeping intervals of hard words growing.
+	minEase = 1.3
+	// passQuality is the lowest quality of a review that counts as passed.
+	passQuality = 3
+	// cleanQuality is the quality of a word typed without mistakes.
+	cleanQuality = 5
+	// reviewGraduation is the number of reviews passed in a row after which
+	// typing a word cleanly retires it from review.
+	reviewGraduation = 3
+	// relearnDelay is the delay after which a failed word is up for review
+	// again, so it comes back in the same session.
+	relearnDelay = 10 * time.Minute
+	// reviewShare is the inverse of the largest share of the words of a
+	// prompt that are up for review.
+	reviewShare = 3
+	// reviewOversampling is the factor of due reviews fetched beyond those
+	// needed for a prompt, as some are not tokens of the sources.
+	reviewOversampling = 4
+)
+
+// WordResult is how a word of a prompt was typed.
+type WordResult struct {
+	// Token is the word.
+	Token string
+	// Mistakes is the number of mistyped characters of the word, including
+	// corrected ones.
+	Mistakes int
+}
+
+// quality grades how well a word was typed from 0 to 5, as in SM-2.
+func quality(mistakes int) int {
+	switch mistakes {
+	case 0:
+		return cleanQuality
+	case 1:
+		return passQuality
+	case 2:
+		return passQuality - 1
+	default:
+		return passQuality - 2
+	}
+}
+
+// schedule returns the review of a word after a review of the specified
+// quality, following SM-2. Failed words are relearned in the same session.
+// The second return value is true if the word graduates from review.
+func schedule(r data.Review, q int, now time.Time) (data.Review, bool) {
+	if r.Ease == 0 {
+		r.Ease = initialEase
+	}
+	d := float64(cleanQuality - q)
+	r.Ease = max(r.Ease+0.1-d*(0.08+d*0.02), minEase)
+
+	if q < passQuality {
+		r.Repetitions = 0
+		r.Interval = 0
+		r.Lapses++
+		r.Due = now.Add(relearnDelay)
+		return r, false
+	}
+
+	r.Repetitions++
+	switch r.Repetitions {
+	case 1:
+		r.Interval = 1
+	case 2:
+		r.Interval = 6
+	default:
+		r.Interval = int(math.Round(float64(r.Interval) * r.Ease))
+	}
+	r.Due = now.AddDate(0, 0, r.Interval)
+	return r, q == cleanQuality && r.Repetitions >= reviewGraduation
+}
+
+// RecordWords schedules the mistyped words of a round for review and grades
+// the words put into its prompt for review, see Round.Reviews, according to
+// how they were typed. Other words under review are left alone, as are words
+// typed cleanly that are not under review.
+func (e *Engine) RecordWords(round Round, words []WordResult) error {
+	if len(words) == 0 {
+		return nil
+	}
+	store, err := e.results()
+	if err != nil {
+		return err
+	}
+
+	// A word may occur several times in a round
+	mistakes := map[string]int{}
+	var tokens []string
+	for _, w := range words {
+		if _, ok := mistakes[w.Token]; !ok {
+			tokens = append(tokens, w.Token)
+		}
+		mistakes[w.Token] += w.Mistakes
+	}
+
+	existing, err := store.GetReviews(tokens)
+	if err != nil {
+		return err
+	}
+	reviews := map[string]data.Review{}
+	for _, r := range existing {
+		reviews[r.Token] = r
+	}
+
+	now := time.Now()
+	var updated []data.Review
+	var graduated []string
+	for _, token := range tokens {
+		r, ok := reviews[token]
+		graded := slices.Contains(round.Reviews, token)
+		if ok && !graded || !ok && mistakes[token] == 0 {
+			continue
+		}
+		r.Token = token
+		r.Mistakes += mistakes[token]
+
+		r, done := schedule(r, quality(mistakes[token]), now)
+		if done {
+			graduated = append(graduated, token)
+		} else {
+			updated = append(updated, r)
+		}
+	}
+
+	e.promptMu.Lock()
+	for _, token := range round.Reviews {
+		delete(e.reviewed, token)
+	}
+	e.promptMu.Unlock()
+
+	zap.S().Debugw("Recorded words for review",
+		"word_count", len(tokens),
+		"review_count", len(updated),
+		"graduated_count", len(graduated))
+	return store.SaveReviews(updated, graduated)
+}
+
+// dueReviewWords returns up to k words due for review that are tokens of the
+// sources, leaving out words already put into a prompt recently.
+func (e *Engine) dueReviewWords(k int) ([]string, error) {
+	e.promptMu.Lock()
+	store := e.resultStore
+	e.promptMu.Unlock()
+	if k <= 0 || store == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	due, err := store.GetDueReviews(now, k*reviewOversampling)
+	if err != nil {
+		return nil, err
+	}
+
+	e.promptMu.Lock()
+	var candidates []string
+	for _, r := range due {
+		if at, ok := e.reviewed[r.Token]; !ok || now.Sub(at) >= relearnDelay {
+			candidates = append(candidates, r.Token)
+		}
+	}
+	e.promptMu.Unlock()
+
+	// Words may come from sources of other sessions
+	var words []string
+	for _, src := range e.sources {
+		found, err := src.store.FilterTokens(candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range found {
+			if len(words) < k && !slices.Contains(words, w) {
+				words = append(words, w)
+			}
+		}
+	}
+	return words, nil
+}
+
+// markReviewed records that the words were put into a prompt handed out, so
+// they are not repeated in the next prompts before being typed.
+func (e *Engine) markReviewed(words []string) {
+	if len(words) == 0 {
+		return
+	}
+
+	e.promptMu.Lock()
+	defer e.promptMu.Unlock()
+
+	if e.reviewed == nil {
+		e.reviewed = map[string]time.Time{}
+	}
+	now := time.Now()
+	for _, w := range words {
+		e.reviewed[w] = now
+	}
+}
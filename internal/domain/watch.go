@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// watchDebounce is the quiet period after a change to a watched directory
+// before it is refreshed, so bursts of changes, e.g. a checkout, trigger a
+// single refresh.
+const watchDebounce = 500 * time.Millisecond
+
+// startWatching starts keeping the database of a directory source in sync
+// with the directory until the engine is closed.
+func (e *Engine) startWatching(src source, opts Options) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		zap.S().Errorw("Failed to create file system watcher",
+			"dir_path", src.path,
+			"error", err)
+		return ErrFileOperation
+	}
+
+	// Watch the directories containing listed files, which leaves out
+	// ignored directories and the .git directory
+	paths, err := e.listFiles(src.path)
+	if err != nil {
+		zap.S().Errorw("Failed to list files in directory",
+			"dir_path", src.path,
+			"error", err)
+		return errors.Join(ErrFileOperation, w.Close())
+	}
+	dirs := map[string]bool{src.path: true}
+	for _, path := range paths {
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			zap.S().Errorw("Failed to watch directory",
+				"dir_path", dir,
+				"error", err)
+			return errors.Join(ErrFileOperation, w.Close())
+		}
+	}
+
+	zap.S().Infow("Watching directory for changes",
+		"dir_path", src.path,
+		"watched_dir_count", len(dirs))
+	e.watchers.Go(func() {
+		defer w.Close() // nolint:errcheck
+		e.watch(w, src, opts)
+	})
+	return nil
+}
+
+// watch refreshes the database of the source whenever the watched
+// directories change. Run as a goroutine.
+func (e *Engine) watch(w *fsnotify.Watcher, src source, opts Options) {
+	// Refreshes report progress to an engine of their own, so the progress
+	// of Setup stays final
+	refresher := &Engine{ctx: e.ctx, untracked: e.untracked}
+
+	var refresh <-chan time.Time
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				watchNewDirs(w, event.Name)
+			}
+			refresh = time.After(watchDebounce)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			zap.S().Warnw("File system watcher error",
+				"dir_path", src.path,
+				"error", err)
+
+		case <-refresh:
+			refresh = nil
+			summary, err := refresher.IndexDirectory(
+				src.store, src.path, opts.Filter, opts.Errors)
+			if errors.Is(err, ErrEmptyDir) {
+				// The last files were removed, so must be their tokens
+				err = clearSource(src)
+			}
+			if err != nil {
+				// Keep watching, a later change may fix the directory
+				zap.S().Warnw("Failed to refresh watched directory",
+					"dir_path", src.path,
+					"error", err)
+				continue
+			}
+			zap.S().Infow("Refreshed watched directory",
+				"dir_path", src.path,
+				"new", summary.New,
+				"changed", summary.Changed,
+				"removed", summary.Removed,
+				"errors", summary.Errors)
+		}
+	}
+}
+
+// clearSource removes all files and tokens of a watched source, as none of its
+// files are left.
+func clearSource(src source) error {
+	if err := src.store.Lock(); err != nil {
+		return err
+	}
+	defer src.store.Unlock() // nolint:errcheck
+
+	zap.S().Infow("No files left in watched directory, clearing database",
+		"dir_path", src.path)
+	return src.store.Clear()
+}
+
+// watchNewDirs adds the directory at the specified path and its
+// subdirectories to the watcher. Paths of other files are ignored.
+func watchNewDirs(w *fsnotify.Watcher, path string) {
+	stat, err := os.Lstat(path)
+	if err != nil || !stat.IsDir() {
+		return
+	}
+
+	filepath.WalkDir(path, func(dir string, d fs.DirEntry, err error) error { // nolint:errcheck
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if err := w.Add(dir); err != nil {
+			zap.S().Warnw("Failed to watch new directory",
+				"dir_path", dir,
+				"error", err)
+		}
+		return nil
+	})
+}
@@ -47,12 +47,14 @@ var sessionKeys = sessionKeyMap{}
 
 // breakKeyMap defines key bindings for the break screen UI.
 type breakKeyMap struct {
-	Restart key.Binding
+	Restart    key.Binding
+	Keyboard   key.Binding
+	Difficulty key.Binding
 }
 
 // ShortHelp returns key bindings to be shown in the mini help view.
 func (k breakKeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{globalKeys.Quit, k.Restart}
+	return []key.Binding{globalKeys.Quit, k.Restart, k.Keyboard}
 }
 
 // FullHelp returns key bindings to be shown in the expanded help view.
@@ -66,4 +68,12 @@ var breakKeys = breakKeyMap{
 		key.WithKeys(" "),
 		key.WithHelp("space", "next"),
 	),
+	Keyboard: key.NewBinding(
+		key.WithKeys("k"),
+		key.WithHelp("k", "keys"),
+	),
+	Difficulty: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "difficulty"),
+	),
 }
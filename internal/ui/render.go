@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/lipgloss"
@@ -55,12 +56,36 @@ func renderStats(m model) string {
 
 	wpmStr := fmt.Sprintf("%d", int(m.wpm))
 	accStr := fmt.Sprintf("%d%%", int(m.accuracy))
+	// Accuracy would give mistakes away before the round is over
+	if m.blind && m.appState != StateBreak {
+		accStr = "--"
+	}
 
-	return accentStyle.Render(wpmStr) +
+	stats := accentStyle.Render(wpmStr) +
 		labelStyle.Render(" wpm") +
 		sepStyle.Render(" • ") +
 		accentStyle.Render(accStr) +
 		labelStyle.Render(" acc")
+
+	if m.appState == StateBreak && m.failed {
+		stats += sepStyle.Render(" • ") + errorStyle.Render("failed")
+	}
+
+	// Show how to reproduce the round between sessions
+	if m.appState == StateBreak && m.round.Challenge != "" {
+		date, _, _ := strings.Cut(m.round.Challenge, "/")
+		stats += sepStyle.Render(" • ") +
+			labelStyle.Render("daily ") +
+			accentStyle.Render(date) +
+			labelStyle.Render(fmt.Sprintf(" #%d", m.round.Number))
+	} else if m.appState == StateBreak && m.round.Number > 0 {
+		stats += sepStyle.Render(" • ") +
+			labelStyle.Render("seed ") +
+			accentStyle.Render(fmt.Sprintf("%d", m.round.Seed)) +
+			labelStyle.Render(fmt.Sprintf(" #%d", m.round.Number))
+	}
+
+	return stats
 }
 
 // renderStatusBar renders the status bar with help and stats.
@@ -98,7 +123,8 @@ func renderPrompt(m model) string {
 				}
 			default:
 				// In session state, style based on cursor and mistakes
-				// Corrected mistakes are shown in accent color
+				// Corrected mistakes are shown in accent color, unless
+				// blind
 				if pos > m.cursor() {
 					if promptChar == ' ' {
 						style = mutedStyle
@@ -106,9 +132,18 @@ func renderPrompt(m model) string {
 						style = bodyStyle
 					}
 				} else if pos == m.cursor() {
-					style = bodyStyle.Underline(true)
+					// Wrong keys leave the cursor in place when stopping on
+					// letters
+					if m.round.Mode == domain.ModeStopOnLetter && m.mistakes[pos] &&
+						!m.blind {
+						style = errorStyle.Underline(true)
+					} else {
+						style = bodyStyle.Underline(true)
+					}
 				} else {
-					if promptChar != inputRunes[pos] {
+					if m.blind {
+						style = mutedStyle
+					} else if promptChar != inputRunes[pos] {
 						style = errorStyle
 					} else if m.mistakes[pos] {
 						style = accentStyle
@@ -121,6 +156,10 @@ func renderPrompt(m model) string {
 			if promptChar == ' ' {
 				promptChar = '·'
 			}
+			// Characters left to type are hidden once memorized
+			if pos >= m.cursor() && m.promptHidden() {
+				promptChar = ' '
+			}
 
 			render += style.Inline(true).Render(string(promptChar))
 			pos++
@@ -134,13 +173,113 @@ func renderPrompt(m model) string {
 	return render
 }
 
-// renderLoad renders the loading indicator.
+// renderLoad renders the loading indicator along with the phase of source
+// processing.
 func renderLoad(m model) string {
 	// Normalize progress to percentage
-	progress := int(min(math.Round(domain.Progress()*100), 99))
+	progress := int(min(math.Round(m.progress.Fraction()*100), 99))
 
 	return m.spinner.View() + bodyStyle.Render(" Coming up with words... ") +
-		mutedStyle.Render(fmt.Sprintf("%2d%%", progress))
+		mutedStyle.Render(fmt.Sprintf("%2d%%", progress)) + "\n\n" +
+		mutedStyle.Render(m.progress.String())
+}
+
+// heatLevels are the styles of keys on the heatmap, from the best to the
+// worst third of the keys' range.
+var heatLevels = []lipgloss.Style{
+	lipgloss.NewStyle().Foreground(inverseColor).Background(okColor),
+	lipgloss.NewStyle().Foreground(inverseColor).Background(accentColor),
+	lipgloss.NewStyle().Foreground(inverseColor).Background(errorColor),
+}
+
+// minHeatmapPresses is the number of presses a key needs to be shaded on the
+// heatmap. Keys typed less often are shown as untyped.
+const minHeatmapPresses = 5
+
+// heatValue returns the value of the heatmap's metric for the key stats.
+func (m model) heatValue(s domain.KeyStats) float64 {
+	if m.heatmap == heatmapLatency {
+		return float64(s.AvgLatency().Milliseconds())
+	}
+	return s.ErrorRate()
+}
+
+// formatHeatValue formats a value of the heatmap's metric.
+func (m model) formatHeatValue(v float64) string {
+	if m.heatmap == heatmapLatency {
+		return fmt.Sprintf("%.0fms", v)
+	}
+	return fmt.Sprintf("%.0f%%", v*100)
+}
+
+// renderHeatmap renders the keys of the layout shaded by their error rate or
+// average latency, relative to the worst key, along with a legend.
+func renderHeatmap(m model) string {
+	// Find the worst key to shade the others relative to it
+	var worst rune
+	maxValue := 0.0
+	for c, s := range m.keyStats {
+		if c == ' ' || s.Presses < minHeatmapPresses {
+			continue
+		}
+		if v := m.heatValue(s); v > maxValue || v == maxValue && c < worst {
+			worst, maxValue = c, v
+		}
+	}
+
+	var rows []string
+	for i, row := range m.layout.Rows {
+		// Stagger the rows like on a keyboard
+		line := strings.Repeat(" ", i)
+		for j, c := range row {
+			if j > 0 {
+				line += " "
+			}
+			key := " " + string(c) + " "
+			s, ok := m.keyStats[unicode.ToLower(c)]
+			if !ok || s.Presses < minHeatmapPresses {
+				line += mutedStyle.Render(key)
+				continue
+			}
+			level := 0
+			if maxValue > 0 {
+				level = min(int(m.heatValue(s)/maxValue*float64(len(heatLevels))),
+					len(heatLevels)-1)
+			}
+			line += heatLevels[level].Render(key)
+		}
+		rows = append(rows, line)
+	}
+
+	title := "error rate"
+	if m.heatmap == heatmapLatency {
+		title = "time per key"
+	}
+	worstLine := mutedStyle.Render("not enough keys typed")
+	if maxValue > 0 {
+		worstLine = bodyStyle.Render("worst ") + accentStyle.Render(string(worst)) +
+			bodyStyle.Render(" "+m.formatHeatValue(maxValue))
+	}
+	legend := strings.Join([]string{
+		accentStyle.Render(title),
+		mutedStyle.Render(m.layout.Name),
+		heatLevels[0].Render(" low ") + heatLevels[1].Render(" mid ") +
+			heatLevels[2].Render(" high "),
+		worstLine,
+	}, "\n")
+
+	return lipgloss.JoinHorizontal(lipgloss.Top,
+		strings.Join(rows, "\n"), "   ", legend)
+}
+
+// renderDifficulty renders the difficulty of the next rounds and how to
+// change it.
+func renderDifficulty(m model) string {
+	if m.round.Challenge != "" {
+		return ""
+	}
+	return mutedStyle.Render("next rounds ") + accentStyle.Render(string(m.difficulty)) +
+		mutedStyle.Render(" • press d to change")
 }
 
 // renderCanvas renders the main canvas area based on the application state.
@@ -148,6 +287,11 @@ func renderCanvas(m model) string {
 	switch m.appState {
 	case StateLoading:
 		return canvasStyle.Render(renderLoad(m))
+	case StateBreak:
+		if m.heatmap != heatmapOff {
+			return canvasStyle.Render(renderHeatmap(m))
+		}
+		return canvasStyle.Render(renderPrompt(m) + "\n\n" + renderDifficulty(m))
 	default:
 		return canvasStyle.Render(renderPrompt(m))
 	}
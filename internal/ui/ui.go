@@ -3,8 +3,12 @@ package ui
 
 import (
 	"math"
+	"os"
 	"slices"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -12,9 +16,11 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/vupdivup/typomat/internal/domain"
-	"github.com/vupdivup/typomat/pkg/alphabet"
+	"github.com/vupdivup/typomat/pkg/layout"
 	"github.com/vupdivup/typomat/pkg/metrics"
+	"github.com/vupdivup/typomat/pkg/tokenizer"
 	"go.uber.org/zap"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
@@ -38,7 +44,7 @@ const (
 	canvasContentWidth = windowContentWidth - 2*canvasPaddingHorizontal
 
 	// maxPromptLen is the maximum length of a typing prompt.
-	maxPromptLen = 128
+	maxPromptLen = domain.DefaultPromptLen
 )
 
 var (
@@ -58,15 +64,28 @@ var (
 	bodyColor   = lipgloss.Color("7")
 	mutedColor  = lipgloss.Color("8")
 	errorColor  = lipgloss.Color("9")
+	// okColor and inverseColor shade the keys of the heatmap.
+	okColor      = lipgloss.Color("2")
+	inverseColor = lipgloss.Color("0")
 
 	accentStyle = lipgloss.NewStyle().Foreground(accentColor)
 	bodyStyle   = lipgloss.NewStyle().Foreground(bodyColor)
 	mutedStyle  = lipgloss.NewStyle().Foreground(mutedColor)
 	errorStyle  = lipgloss.NewStyle().Foreground(errorColor)
-
-	allowedInputRunes = append(alphabet.AllRunes, ' ')
 )
 
+// Options configures the TUI beyond the sources of practice text.
+type Options struct {
+	// Layout is the keyboard layout key stats are shown on.
+	Layout *layout.Layout
+	// Blind hides which characters are typed correctly until the round is
+	// over.
+	Blind bool
+	// Memory, if positive, is the time the prompt is shown before it is
+	// hidden, to be typed from memory.
+	Memory time.Duration
+}
+
 // AppState represents the current state of the application.
 type AppState int
 
@@ -83,20 +102,40 @@ const (
 
 // model defines the TUI state.
 type model struct {
-	// dirPath is the directory path for prompts.
-	dirPath string
-	// useCache indicates whether caching is enabled.
-	useCache bool
+	// engine generates prompts from the sources.
+	engine *domain.Engine
+	// paths are the source paths for prompts.
+	paths []string
+	// opts configures the source of practice text.
+	opts domain.Options
+	// layout is the keyboard layout shown by the heatmap.
+	layout *layout.Layout
+	// blind hides which characters are typed correctly during rounds.
+	blind bool
+	// memory is the time the prompt is shown before it is hidden, if
+	// positive.
+	memory time.Duration
 
 	// appState is the current application appState.
 	appState AppState
 
 	// prompt is the text prompt to type.
 	prompt string
+	// shownAt is the time the prompt was shown.
+	shownAt time.Time
+	// round is the current round, used to reproduce the prompt.
+	round domain.Round
 	// input is the current user input.
 	input string
 	// mistakes records the positions of mistakes made.
 	mistakes map[int]bool
+	// keystrokes are the key presses of the round, recorded for key stats.
+	keystrokes []domain.Keystroke
+	// lastKeyTime is the time of the previous key press of the round.
+	lastKeyTime time.Time
+	// failed indicates that the round was failed by a mistake, see
+	// domain.ModeSuddenDeath.
+	failed bool
 
 	// startTime is the time when the typing session started.
 	startTime time.Time
@@ -105,10 +144,23 @@ type model struct {
 	// accuracy is the current typing accuracy.
 	accuracy int
 
+	// heatmap is the metric the keyboard heatmap shows instead of the prompt
+	// between rounds, if any.
+	heatmap heatmapMetric
+	// difficulty is the difficulty of the prompts of the next rounds.
+	difficulty domain.Difficulty
+	// keyStats are the key stats of the layout shown by the heatmap, by
+	// character of the key.
+	keyStats map[rune]domain.KeyStats
+
 	// help is the help view model.
 	help help.Model
 	// spinner is the loading spinner view model.
 	spinner spinner.Model
+	// progressEvents delivers the progress of source processing.
+	progressEvents <-chan domain.ProgressEvent
+	// progress is the latest progress of source processing.
+	progress domain.ProgressEvent
 
 	// Err captures any error that occurs during TUI execution.
 	err error
@@ -122,26 +174,78 @@ func (m model) cursor() int {
 	return len([]rune(m.input))
 }
 
+// promptHidden reports whether the characters of the prompt not yet typed
+// are hidden, as the time to memorize them is up.
+func (m model) promptHidden() bool {
+	return m.memory > 0 && m.appState != StateBreak &&
+		m.frameTime.Sub(m.shownAt) >= m.memory
+}
+
+// hideMsg is a message indicating that the time to memorize a prompt may be
+// up.
+type hideMsg struct{}
+
+// hideCmd returns a command to hide the prompt once the time to memorize it
+// is up, if prompts are to be typed from memory.
+func (m model) hideCmd() tea.Cmd {
+	if m.memory <= 0 {
+		return nil
+	}
+	return tea.Tick(m.memory, func(time.Time) tea.Msg {
+		return hideMsg{}
+	})
+}
+
 // loadedMsg is a message indicating that the first prompt has been loaded.
 type loadedMsg struct {
-	prompt string
-	err    error
+	round domain.Round
+	err   error
 }
 
 // loadCmd returns a command to process the source directory and load a new
 // prompt.
 func (m model) loadCmd() tea.Cmd {
 	return func() tea.Msg {
-		if err := domain.Setup(m.dirPath, m.useCache, maxPromptLen); err != nil {
-			return loadedMsg{prompt: "", err: err}
+		if err := m.engine.Setup(m.paths, m.opts, maxPromptLen); err != nil {
+			return loadedMsg{round: domain.Round{}, err: err}
 		}
-		prompt, err := domain.Prompt()
-		return loadedMsg{prompt: prompt, err: err}
+		round, err := m.engine.Prompt()
+		return loadedMsg{round: round, err: err}
+	}
+}
+
+// progressMsg is a message carrying a progress event of source processing.
+type progressMsg struct {
+	event domain.ProgressEvent
+	// ok is false once the engine is closed and no more events follow.
+	ok bool
+}
+
+// waitProgressCmd returns a command to wait for the next progress event.
+func (m model) waitProgressCmd() tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-m.progressEvents
+		return progressMsg{event: event, ok: ok}
 	}
 }
 
+// heatmapMetric is the metric shaded on the keyboard heatmap.
+type heatmapMetric int
+
+const (
+	// heatmapOff shows the prompt instead of the heatmap.
+	heatmapOff heatmapMetric = iota
+	// heatmapErrors shades keys by their error rate.
+	heatmapErrors
+	// heatmapLatency shades keys by their average latency.
+	heatmapLatency
+)
+
 // initialModel creates the initial TUI model.
-func initialModel(dirPath string, useCache bool) model {
+func initialModel(
+	engine *domain.Engine, paths []string, opts domain.Options,
+	uiOpts Options,
+) model {
 	help := help.New()
 	help.Styles.ShortKey = accentStyle
 	help.Styles.ShortSeparator = mutedStyle
@@ -150,10 +254,15 @@ func initialModel(dirPath string, useCache bool) model {
 		spinner.WithSpinner(spinner.Dot), spinner.WithStyle(accentStyle))
 
 	m := model{
-		dirPath:  dirPath,
-		useCache: useCache,
-		help:     help,
-		spinner:  spinner,
+		engine:         engine,
+		paths:          paths,
+		opts:           opts,
+		layout:         uiOpts.Layout,
+		blind:          uiOpts.Blind,
+		memory:         uiOpts.Memory,
+		help:           help,
+		spinner:        spinner,
+		progressEvents: engine.Subscribe(),
 	}
 
 	return m
@@ -166,15 +275,25 @@ func (m model) load() model {
 }
 
 // ready sets up the model for a ready state with a new prompt.
-func (m model) ready(prompt string) model {
+func (m model) ready(round domain.Round) model {
 	m.mistakes = make(map[int]bool)
+	m.keystrokes = nil
+	m.failed = false
+	m.heatmap = heatmapOff
 	m.input = ""
 	m.wpm = 0
 	m.accuracy = 0.0
 	m.appState = StateReady
-	m.prompt = prompt
+	m.prompt = round.Prompt
+	m.shownAt = m.frameTime
+	m.round = round
+	m.difficulty = round.Difficulty
 	zap.S().Infow("Session ready",
-		"prompt", m.prompt)
+		"prompt", m.prompt,
+		"seed", m.round.Seed,
+		"round", m.round.Number,
+		"difficulty", m.round.Difficulty,
+		"mode", m.round.Mode)
 	return m
 }
 
@@ -190,12 +309,62 @@ func (m model) stop() model {
 	m.appState = StateBreak
 	zap.S().Infow("Session ended",
 		"prompt", m.prompt,
+		"seed", m.round.Seed,
+		"round", m.round.Number,
+		"difficulty", m.round.Difficulty,
+		"mode", m.round.Mode,
+		"failed", m.failed,
 		"input", m.input,
 		"wpm", m.wpm,
 		"accuracy", m.accuracy)
+
+	// A failure to record the result should not interrupt practice
+	if err := m.engine.RecordResult(
+		m.round, m.wpm, m.accuracy, m.failed); err != nil {
+		zap.S().Warnw("Failed to record result",
+			"challenge", m.round.Challenge,
+			"round", m.round.Number,
+			"error", err)
+	}
+	if err := m.engine.RecordKeystrokes(m.keystrokes); err != nil {
+		zap.S().Warnw("Failed to record key stats",
+			"round", m.round.Number,
+			"error", err)
+	}
+	if err := m.engine.RecordWords(m.round, m.wordResults()); err != nil {
+		zap.S().Warnw("Failed to record words for review",
+			"round", m.round.Number,
+			"error", err)
+	}
 	return m
 }
 
+// wordResults returns the number of mistakes made in each word of the
+// prompt, including corrected ones. Words decorated by the difficulty, e.g.
+// joined in camel case, count for each of their tokens. Words not typed to
+// the end, e.g. after a failed round, are left out.
+func (m model) wordResults() []domain.WordResult {
+	var words []domain.WordResult
+	start := 0
+	for _, word := range strings.Split(m.prompt, " ") {
+		if start+utf8.RuneCountInString(word) > m.cursor() {
+			break
+		}
+		mistakes := 0
+		for pos := range utf8.RuneCountInString(word) {
+			if m.mistakes[start+pos] {
+				mistakes++
+			}
+		}
+		for _, token := range tokenizer.TokenizeString(
+			word, m.opts.Filter.Alphabet, nil) {
+			words = append(words, domain.WordResult{Token: token, Mistakes: mistakes})
+		}
+		start += utf8.RuneCountInString(word) + 1
+	}
+	return words
+}
+
 // initMsg is the initial message to start the TUI.
 type initMsg struct{}
 
@@ -214,6 +383,19 @@ func (m model) Init() tea.Cmd {
 func (m model) updateMetrics() model {
 	elapsed := m.frameTime.Sub(m.startTime)
 	m.wpm = int(math.Round(metrics.WPM(m.input, elapsed)))
+
+	// Mistakes never remain in the input of modes requiring correction
+	if m.round.Mode.RequiresCorrection() {
+		mistakes := 0
+		for _, k := range m.keystrokes {
+			if k.Mistake {
+				mistakes++
+			}
+		}
+		m.accuracy = int(math.Round(
+			metrics.KeystrokeAccuracy(len(m.keystrokes), mistakes)))
+		return m
+	}
 	m.accuracy = int(
 		math.Round(metrics.Accuracy(m.prompt, m.input)))
 	return m
@@ -236,6 +418,160 @@ func (m model) handleBackspace() model {
 	return m
 }
 
+// isAllowedInput returns true if r can be typed, i.e. it is a space, a
+// letter of the alphabet of the sources or a symbol of the round's
+// difficulty.
+func (m model) isAllowedInput(r rune) bool {
+	if m.round.Difficulty.HasSymbols() && strings.ContainsRune(domain.PromptSymbols, r) {
+		return true
+	}
+	return r == ' ' || m.opts.Filter.Alphabet.Contains(r)
+}
+
+// cycleDifficulty switches prompts from the next round on to the next
+// difficulty. Daily challenges keep theirs, as everyone gets the same
+// prompts.
+func (m model) cycleDifficulty() model {
+	if m.round.Challenge != "" {
+		return m
+	}
+
+	opts := m.engine.PromptOptions()
+	opts.Difficulty = opts.Difficulty.Next()
+	if err := m.engine.Reconfigure(opts); err != nil {
+		zap.S().Warnw("Failed to change difficulty",
+			"difficulty", opts.Difficulty,
+			"error", err)
+		return m
+	}
+	m.difficulty = opts.Difficulty
+	return m
+}
+
+// handleCombining composes a combining character, e.g. an accent typed after
+// its letter, with the last typed letter. It has no effect if they do not
+// compose to a letter of the alphabet. Updates metrics as well.
+func (m model) handleCombining(mark string) model {
+	if m.appState != StateSession || m.cursor() == 0 {
+		return m
+	}
+
+	inputRunes := []rune(m.input)
+	pos := len(inputRunes) - 1
+	composed := []rune(norm.NFC.String(string(inputRunes[pos]) + mark))
+	if len(composed) != 1 || !m.isAllowedInput(composed[0]) {
+		return m
+	}
+
+	// The letter is judged as composed, not as typed before the mark
+	mistake := composed[0] != []rune(m.prompt)[pos]
+	if mistake && m.round.Mode == domain.ModeStopOnLetter {
+		return m
+	}
+	if mistake {
+		m.mistakes[pos] = true
+	} else {
+		delete(m.mistakes, pos)
+	}
+	if n := len(m.keystrokes); n > 0 {
+		m.keystrokes[n-1].Mistake = mistake
+	}
+	m.input = string(append(inputRunes[:pos], composed[0]))
+	m = m.updateMetrics()
+	zap.S().Debugw("Handled combining character",
+		"input", m.input,
+		"wpm", m.wpm,
+		"accuracy", m.accuracy)
+	if mistake && m.round.Mode == domain.ModeSuddenDeath {
+		m.failed = true
+		return m.stop()
+	}
+	return m.completeWord()
+}
+
+// completeWord judges the word and the round completed by the last letter of
+// the prompt, once the combining mark it awaits or another key press follows.
+func (m model) completeWord() model {
+	if m.round.Mode == domain.ModeStopOnWord {
+		m = m.retypeWord().updateMetrics()
+	}
+	last := len([]rune(m.prompt)) - 1
+	if m.round.Mode == domain.ModeSuddenDeath && m.mistakes[last] {
+		m.failed = true
+		return m.stop()
+	}
+	if m.cursor() > last {
+		return m.stop()
+	}
+	return m
+}
+
+// awaitsMark reports whether a typed letter is the base of the expected one,
+// which the combining mark typed next composes, e.g. "e" for "é".
+func awaitsMark(typed string, expected rune) bool {
+	decomposed := []rune(norm.NFD.String(string(expected)))
+	return len(decomposed) > 1 && string(decomposed[0]) == typed
+}
+
+// retypeWord makes the word just completed be typed again from its start if
+// it does not match the prompt. A word is completed by the space after it or
+// by the last character of the prompt.
+func (m model) retypeWord() model {
+	promptRunes := []rune(m.prompt)
+	inputRunes := []rune(m.input)
+	end := len(inputRunes)
+	if end == 0 || end < len(promptRunes) && promptRunes[end-1] != ' ' {
+		return m
+	}
+
+	start := end - 1
+	for start > 0 && promptRunes[start-1] != ' ' {
+		start--
+	}
+	if string(inputRunes[start:end]) == string(promptRunes[start:end]) {
+		return m
+	}
+
+	m.input = string(inputRunes[:start])
+	zap.S().Debugw("Mistyped word to be typed again",
+		"word", string(promptRunes[start:end]),
+		"input", string(inputRunes[start:end]))
+	return m
+}
+
+// recordKeystroke records a key press typing the expected character of the
+// prompt, timed since the previous key press of the round.
+func (m model) recordKeystroke(expected rune, mistake bool) model {
+	k := domain.Keystroke{Expected: expected, Mistake: mistake}
+	if len(m.keystrokes) > 0 {
+		k.Latency = m.frameTime.Sub(m.lastKeyTime)
+	}
+	m.keystrokes = append(m.keystrokes, k)
+	m.lastKeyTime = m.frameTime
+	return m
+}
+
+// toggleHeatmap switches between the prompt and the keyboard heatmap of
+// error rates and latencies. Key stats are loaded when the heatmap is shown,
+// so they include the round just finished.
+func (m model) toggleHeatmap() model {
+	m.heatmap = (m.heatmap + 1) % (heatmapLatency + 1)
+	if m.heatmap != heatmapErrors {
+		return m
+	}
+
+	stats, err := m.engine.LoadKeyStats()
+	if err != nil {
+		// The heatmap is a nicety, keep practicing without it
+		zap.S().Warnw("Failed to load key stats for heatmap",
+			"error", err)
+		m.heatmap = heatmapOff
+		return m
+	}
+	m.keyStats = domain.StatsByKey(stats, m.layout)
+	return m
+}
+
 // handleCtrlBackspace processes a Ctrl+Backspace key press.
 // Updates metrics as well.
 func (m model) handleCtrlBackspace() model {
@@ -265,7 +601,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case initMsg:
 		m = m.load()
-		return m, tea.Batch(m.spinner.Tick, m.loadCmd())
+		return m, tea.Batch(m.spinner.Tick, m.loadCmd(), m.waitProgressCmd())
 
 	case tea.KeyMsg:
 		if key.Matches(msg, globalKeys.Quit) {
@@ -277,13 +613,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if key.Matches(msg, breakKeys.Restart) {
 				// NOTE: no async load on subsequent prompts
 				// Domain-layer pooling should make this fast enough
-				prompt, err := domain.Prompt()
+				round, err := m.engine.Prompt()
 				if err != nil {
 					m.err = err
 					return m, tea.Quit
 				}
-				m = m.ready(prompt)
-				return m, nil
+				m = m.ready(round)
+				return m, m.hideCmd()
+			}
+			if key.Matches(msg, breakKeys.Keyboard) {
+				return m.toggleHeatmap(), nil
+			}
+			if key.Matches(msg, breakKeys.Difficulty) {
+				return m.cycleDifficulty(), nil
 			}
 
 		case StateSession, StateReady:
@@ -293,33 +635,61 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "ctrl+backspace", "ctrl+w":
 				return m.handleCtrlBackspace(), nil
 			default:
-				msgRunes := []rune(msg.String())
+				// Letters may arrive decomposed, e.g. from input methods
+				keyInput := norm.NFC.String(msg.String())
+				msgRunes := []rune(keyInput)
 				promptRunes := []rune(m.prompt)
 
+				// Combine a combining character with the previous letter
+				if len(msgRunes) == 1 && unicode.Is(unicode.Mn, msgRunes[0]) {
+					return m.handleCombining(keyInput), nil
+				}
+
 				// Ignore non-character keys or unsupported runes
-				if len(msgRunes) != 1 ||
-					!slices.Contains(allowedInputRunes, msgRunes[0]) {
+				if len(msgRunes) != 1 || !m.isAllowedInput(msgRunes[0]) {
 					return m, nil
 				}
 
+				// The last letter awaited its combining mark in vain
+				if m.cursor() >= len(promptRunes) {
+					return m.completeWord(), nil
+				}
+
 				// Start session on first valid input
 				if m.appState == StateReady {
 					m = m.start()
 				}
 
 				// Check for mistake
-				if msg.String() != string(promptRunes[m.cursor()]) {
+				mistake := keyInput != string(promptRunes[m.cursor()])
+				if mistake {
 					m.mistakes[m.cursor()] = true
 				}
+				m = m.recordKeystroke(promptRunes[m.cursor()], mistake)
+
+				// Accept input, unless the mode rejects mistakes
+				if !mistake || m.round.Mode != domain.ModeStopOnLetter {
+					m.input += keyInput
+				}
 
-				// Accept input
-				m.input += msg.String()
+				// The last letter of the prompt may await its combining mark
+				pending := mistake && m.cursor() == len(promptRunes) &&
+					awaitsMark(keyInput, promptRunes[len(promptRunes)-1])
+				if m.round.Mode == domain.ModeStopOnWord && !pending {
+					m = m.retypeWord()
+				}
 
 				// Update metrics
 				m = m.updateMetrics()
 
+				// Fail session on the first mistake in sudden death mode
+				if mistake && !pending && m.round.Mode == domain.ModeSuddenDeath {
+					m.failed = true
+					return m.stop(), nil
+				}
+
 				// End session if prompt completed
-				if m.cursor() >= len(promptRunes) {
+				if m.cursor() >= len(promptRunes) && !pending {
 					return m.stop(), nil
 				}
 			}
@@ -336,7 +706,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.err = msg.err
 			return m, tea.Quit
 		}
-		return m.ready(msg.prompt), nil
+		return m.ready(msg.round), m.hideCmd()
+
+	case hideMsg:
+		// Rendering with the new frame time hides the prompt if its time is
+		// up, stale messages of earlier prompts have no effect
+		return m, nil
+
+	case progressMsg:
+		if !msg.ok || m.appState != StateLoading {
+			return m, nil
+		}
+		m.progress = msg.event
+		return m, m.waitProgressCmd()
 
 	case spinner.TickMsg:
 		if m.appState != StateLoading {
@@ -360,14 +742,27 @@ func (m model) View() string {
 	return renderApp(m)
 }
 
-// Launch runs the TUI on the specified directory path, optionally using cached data.
+// Launch runs the TUI on the specified source paths with the specified source
+// and TUI options, using the engine.
 //
 // This function covers the entire lifecycle of the TUI, including setup and
-// teardown.
-func Launch(dirPath string, cache bool) error {
-	p := tea.NewProgram(initialModel(dirPath, cache))
+// teardown. The engine is set up by the TUI and closed once it exits.
+func Launch(
+	engine *domain.Engine, paths []string, opts domain.Options, uiOpts Options,
+) error {
+	// Read keys from the terminal if standard input is used as a source
+	var programOpts []tea.ProgramOption
+	if slices.Contains(paths, domain.StdinPath) {
+		programOpts = append(programOpts, tea.WithInputTTY())
+	}
+
+	p := tea.NewProgram(initialModel(engine, paths, opts, uiOpts), programOpts...)
 	m, runErr := p.Run()
-	teardownErr := domain.Teardown()
+	teardownErr := engine.Close()
+
+	// Report files that failed to process once the TUI is gone
+	domain.WriteWarningReport(os.Stderr, engine.Warnings()) // nolint:errcheck
+	domain.WriteFailureReport(os.Stderr, engine.Failures()) // nolint:errcheck
 
 	if runErr != nil {
 		return runErr
@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+	"github.com/vupdivup/typomat/pkg/alphabet"
+)
+
+// newTestModel returns a model ready to type the prompt in the mode. Results
+// are recorded in a temporary cache directory.
+func newTestModel(t *testing.T, prompt string, mode domain.Mode) model {
+	home := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", home)
+	t.Setenv("HOME", home)
+	t.Setenv("LocalAppData", home)
+	require.NoError(t, config.Init())
+
+	engine := domain.NewEngine()
+	t.Cleanup(func() { engine.Close() })
+	opts := domain.Options{
+		Filter: domain.Filter{Alphabet: alphabet.New("éè")},
+	}
+	m := initialModel(engine, nil, opts, Options{})
+	return m.ready(domain.Round{Prompt: prompt, Mode: mode})
+}
+
+// typeKeys sends each rune of keys to the model as a key press.
+func typeKeys(m model, keys string) model {
+	for _, r := range keys {
+		next, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = next.(model)
+	}
+	return m
+}
+
+func TestCombiningLastLetter(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     domain.Mode
+		keys     string
+		state    AppState
+		input    string
+		mistaken bool
+	}{
+		{
+			name:  "composed",
+			mode:  domain.ModeNormal,
+			keys:  "ok cafe\u0301",
+			state: StateBreak,
+			input: "ok café",
+		},
+		{
+			name:     "mark never typed",
+			mode:     domain.ModeNormal,
+			keys:     "ok cafex",
+			state:    StateBreak,
+			input:    "ok cafe",
+			mistaken: true,
+		},
+		{
+			name:  "stop on word composed",
+			mode:  domain.ModeStopOnWord,
+			keys:  "ok cafe\u0301",
+			state: StateBreak,
+			input: "ok café",
+		},
+		{
+			name:     "stop on word mistyped",
+			mode:     domain.ModeStopOnWord,
+			keys:     "ok cafe\u0300",
+			state:    StateSession,
+			input:    "ok ",
+			mistaken: true,
+		},
+		{
+			name:     "sudden death mistyped",
+			mode:     domain.ModeSuddenDeath,
+			keys:     "ok cafe\u0300",
+			state:    StateBreak,
+			input:    "ok cafè",
+			mistaken: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typeKeys(newTestModel(t, "ok café", tt.mode), tt.keys)
+			assert.Equal(t, tt.state, m.appState)
+			assert.Equal(t, tt.input, m.input)
+			assert.Equal(t, tt.mistaken, m.mistakes[6])
+		})
+	}
+}
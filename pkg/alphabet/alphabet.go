@@ -1,6 +1,15 @@
-// Package alphabet provides various rune slices for the English alphabet.
+// Package alphabet provides various rune slices for the English alphabet and
+// alphabets extending it with the letters of other languages.
 package alphabet
 
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
 // LowerCaseRunes contains all lowercase runes from the English alphabet.
 var LowerCaseRunes = []rune{
 	'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm',
@@ -16,3 +25,82 @@ var UpperCaseRunes = []rune{
 // AllRunes contains both lowercase and uppercase runes from the English
 // alphabet.
 var AllRunes = append(LowerCaseRunes, UpperCaseRunes...)
+
+// presets are the letters that the alphabets of languages add to the English
+// alphabet, in lowercase, by language code.
+var presets = map[string]string{
+	"en": "",
+	"de": "äöüß",
+	"es": "áéíñóúü",
+	"fr": "àâæçéèêëîïôœùûüÿ",
+	"hu": "áéíóöőúüű",
+	"pl": "ąćęłńóśźż",
+}
+
+// Alphabet is a set of letters that words may consist of. It always contains
+// the English alphabet. The zero value is the English alphabet.
+type Alphabet struct {
+	// extra are the letters beyond the English alphabet, in both cases,
+	// sorted and in NFC.
+	extra string
+}
+
+// English is the English alphabet.
+var English = Alphabet{}
+
+// New returns the English alphabet extended with the specified letters. Both
+// cases of each letter are added. Letters are normalized to NFC, so those
+// written with combining characters are added as precomposed letters. Other
+// runes than letters are ignored.
+func New(letters string) Alphabet {
+	var extra []rune
+	for _, r := range norm.NFC.String(letters) {
+		if !unicode.IsLetter(r) || English.Contains(r) {
+			continue
+		}
+		extra = append(extra, r, unicode.ToLower(r), unicode.ToUpper(r))
+	}
+	slices.Sort(extra)
+	return Alphabet{extra: string(slices.Compact(extra))}
+}
+
+// Preset returns the alphabet of the language with the specified code, e.g.
+// "de" for German. The second return value is false if the language is
+// unknown.
+func Preset(lang string) (Alphabet, bool) {
+	letters, ok := presets[strings.ToLower(lang)]
+	if !ok {
+		return Alphabet{}, false
+	}
+	return New(letters), true
+}
+
+// Presets returns the codes of the languages with a preset alphabet, sorted.
+func Presets() []string {
+	var langs []string
+	for lang := range presets {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// Union returns the alphabet containing the letters of both alphabets.
+func (a Alphabet) Union(b Alphabet) Alphabet {
+	return New(a.extra + b.extra)
+}
+
+// Contains reports whether r is a letter of the alphabet.
+func (a Alphabet) Contains(r rune) bool {
+	if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' {
+		return true
+	}
+	return r > unicode.MaxASCII && strings.ContainsRune(a.extra, r)
+}
+
+// Extra returns the letters of the alphabet beyond the English alphabet, in
+// both cases, sorted. It is empty for the English alphabet and identifies the
+// alphabet otherwise.
+func (a Alphabet) Extra() string {
+	return a.extra
+}
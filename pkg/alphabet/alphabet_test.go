@@ -0,0 +1,60 @@
+package alphabet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		letters string
+		want    string
+	}{
+		// English letters, digits and punctuation are ignored
+		{"abc123 -_", ""},
+
+		// both cases are added, sorted and deduplicated
+		{"éÉöé", "ÉÖéö"},
+
+		// combining characters are composed
+		{"é", "Éé"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, New(c.letters).Extra(), c.letters)
+	}
+}
+
+func TestContains(t *testing.T) {
+	hu, ok := Preset("hu")
+	assert.True(t, ok)
+	pl, ok := Preset("PL")
+	assert.True(t, ok)
+	both := hu.Union(pl)
+
+	cases := []struct {
+		alphabet Alphabet
+		r        rune
+		want     bool
+	}{
+		{English, 'a', true},
+		{English, 'Z', true},
+		{English, 'é', false},
+		{English, '1', false},
+		{hu, 'ő', true},
+		{hu, 'Ű', true},
+		{hu, 'ł', false},
+		{both, 'ł', true},
+		{both, 'Ő', true},
+		{both, '́', false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.alphabet.Contains(c.r), string(c.r))
+	}
+
+	_, ok = Preset("xx")
+	assert.False(t, ok)
+	assert.Contains(t, Presets(), "de")
+}
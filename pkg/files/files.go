@@ -10,19 +10,18 @@ import (
 	"unicode/utf8"
 )
 
+// textLookahead is the number of leading bytes checked for text validity.
+const textLookahead = 512
+
 // IsTextFile checks if the file at the given path is a text file by reading a
 // portion of its content.
 // Files with common binary file extensions are ruled out without reading.
 func IsTextFile(path string) (bool, error) {
 	// Exit fast for known binary file extensions
-	ext := filepath.Ext(path)
-	if slices.Contains(binaryExtensions, ext) {
+	if isBinaryExtension(path) {
 		return false, nil
 	}
 
-	// Number of bytes to read for checking text validity
-	lookahead := 512
-
 	file, err := os.Open(path)
 	if err != nil {
 		return false, err
@@ -31,7 +30,7 @@ func IsTextFile(path string) (bool, error) {
 	defer file.Close()
 
 	// Read a portion of the file to check if it's valid UTF-8
-	buf := make([]byte, lookahead)
+	buf := make([]byte, textLookahead)
 
 	if _, err := file.Read(buf); err == io.EOF {
 		// An empty file is considered not a text file
@@ -43,6 +42,21 @@ func IsTextFile(path string) (bool, error) {
 	return utf8.Valid(buf), nil
 }
 
+// IsTextContent checks if the content of a file at the given path, e.g. as
+// read from version control, is text. The same rules apply as for IsTextFile.
+func IsTextContent(path string, content []byte) bool {
+	if isBinaryExtension(path) || len(content) == 0 {
+		return false
+	}
+
+	return utf8.Valid(content[:min(len(content), textLookahead)])
+}
+
+// isBinaryExtension checks if the path has a common binary file extension.
+func isBinaryExtension(path string) bool {
+	return slices.Contains(binaryExtensions, filepath.Ext(path))
+}
+
 // DirExists checks if a directory exists at the given path.
 func DirExists(path string) (bool, error) {
 	info, err := os.Stat(path)
@@ -38,6 +38,24 @@ func TestIsTextFile(t *testing.T) {
 	}
 }
 
+func TestIsTextContent(t *testing.T) {
+	cases := []struct {
+		path    string
+		content []byte
+		want    bool
+	}{
+		{"main.go", []byte("package main\n"), true},
+		{"no_extension", []byte("plain text"), true},
+		{"empty.md", []byte{}, false},
+		{"binary.png", []byte("plain text"), false},
+		{"fake.txt", []byte{0xff, 0xfe, 0x00}, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, IsTextContent(c.path, c.content), c.path)
+	}
+}
+
 func TestDirExists(t *testing.T) {
 	cases := []struct {
 		relPath string
@@ -124,3 +142,20 @@ func TestRemoveChildren(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, entries)
 }
+
+func TestLanguageExtensions(t *testing.T) {
+	exts, ok := LanguageExtensions("go")
+	assert.True(t, ok)
+	assert.Equal(t, []string{".go"}, exts)
+
+	// Aliases and case variants resolve to the same extensions
+	ts, ok := LanguageExtensions("ts")
+	assert.True(t, ok)
+	typescript, ok := LanguageExtensions(" TypeScript ")
+	assert.True(t, ok)
+	assert.Equal(t, ts, typescript)
+	assert.Contains(t, ts, ".tsx")
+
+	_, ok = LanguageExtensions("klingon")
+	assert.False(t, ok)
+}
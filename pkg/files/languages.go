@@ -0,0 +1,52 @@
+package files
+
+import "strings"
+
+// languageExtensions maps programming and markup language names, including
+// common aliases, to the file extensions associated with them.
+var languageExtensions = map[string][]string{
+	"c":       {".c", ".h"},
+	"cpp":     {".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx", ".h"},
+	"cs":      {".cs"},
+	"css":     {".css", ".scss", ".sass", ".less"},
+	"dart":    {".dart"},
+	"elixir":  {".ex", ".exs"},
+	"go":      {".go"},
+	"haskell": {".hs"},
+	"html":    {".html", ".htm"},
+	"java":    {".java"},
+	"js":      {".js", ".jsx", ".mjs", ".cjs"},
+	"kotlin":  {".kt", ".kts"},
+	"lua":     {".lua"},
+	"md":      {".md", ".markdown"},
+	"php":     {".php"},
+	"py":      {".py", ".pyi"},
+	"rb":      {".rb"},
+	"rs":      {".rs"},
+	"scala":   {".scala"},
+	"sh":      {".sh", ".bash", ".zsh"},
+	"sql":     {".sql"},
+	"swift":   {".swift"},
+	"ts":      {".ts", ".tsx", ".mts", ".cts"},
+	"yaml":    {".yaml", ".yml"},
+	"zig":     {".zig"},
+
+	// Aliases
+	"javascript": {".js", ".jsx", ".mjs", ".cjs"},
+	"markdown":   {".md", ".markdown"},
+	"python":     {".py", ".pyi"},
+	"ruby":       {".rb"},
+	"rust":       {".rs"},
+	"shell":      {".sh", ".bash", ".zsh"},
+	"typescript": {".ts", ".tsx", ".mts", ".cts"},
+	"csharp":     {".cs"},
+	"c++":        {".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx", ".h"},
+}
+
+// LanguageExtensions returns the file extensions associated with a language,
+// e.g. ".ts" and ".tsx" for "ts" or "typescript". The lookup ignores case.
+// The boolean return value reports whether the language is known.
+func LanguageExtensions(lang string) ([]string, bool) {
+	exts, ok := languageExtensions[strings.ToLower(strings.TrimSpace(lang))]
+	return exts, ok
+}
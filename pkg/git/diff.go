@@ -0,0 +1,149 @@
+package git
+
+// maxDiffEdits is the maximum number of line edits computed exactly. Beyond
+// this, added lines are approximated to bound time and memory usage.
+const maxDiffEdits = 1024
+
+// addedLines returns the indices of lines in b that were added when turning
+// a into b, i.e. those not part of a longest common subsequence of both.
+// Lines are compared using Myers' difference algorithm.
+func addedLines(a, b []string) []int {
+	// Intern lines so that comparisons are cheap
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		result := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			result[i] = id
+		}
+		return result
+	}
+	x, y := intern(a), intern(b)
+
+	// Strip common prefix and suffix
+	start := 0
+	for start < len(x) && start < len(y) && x[start] == y[start] {
+		start++
+	}
+	endX, endY := len(x), len(y)
+	for endX > start && endY > start && x[endX-1] == y[endY-1] {
+		endX--
+		endY--
+	}
+
+	added, ok := myersAdded(x[start:endX], y[start:endY])
+	if !ok {
+		added = approxAdded(x[start:endX], y[start:endY])
+	}
+
+	for i := range added {
+		added[i] += start
+	}
+	return added
+}
+
+// myersAdded returns the indices of elements in b not matched with a by a
+// shortest edit script. The boolean return value is false if the script
+// exceeds maxDiffEdits.
+func myersAdded(a, b []int) ([]int, bool) {
+	n, m := len(a), len(b)
+	if m == 0 {
+		return []int{}, true
+	}
+
+	maxD := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k
+	offset := maxD + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v on diagonals -d..d before step d
+	var trace [][]int
+
+	for d := 0; d <= maxD; d++ {
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackAdded(trace, n, m), true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// backtrackAdded walks the edit graph back from (n, m) using the recorded
+// trace and collects insertions.
+func backtrackAdded(trace [][]int, n, m int) []int {
+	added := []int{}
+	x, y := n, m
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		// Skip matching lines on the diagonal
+		for x > prevX && y > prevY {
+			x--
+			y--
+		}
+
+		if x == prevX {
+			// Downward move: line y-1 of b was inserted
+			added = append(added, y-1)
+		}
+		x, y = prevX, prevY
+	}
+
+	// Reverse to ascending order
+	for i, j := 0, len(added)-1; i < j; i, j = i+1, j-1 {
+		added[i], added[j] = added[j], added[i]
+	}
+	return added
+}
+
+// approxAdded approximates added elements as those in b occurring more often
+// than in a.
+func approxAdded(a, b []int) []int {
+	counts := map[int]int{}
+	for _, id := range a {
+		counts[id]++
+	}
+
+	added := []int{}
+	for i, id := range b {
+		if counts[id] > 0 {
+			counts[id]--
+			continue
+		}
+		added = append(added, i)
+	}
+	return added
+}
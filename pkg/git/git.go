@@ -4,44 +4,106 @@ package git
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/sabhiram/go-gitignore"
+	"github.com/vupdivup/typomat/pkg/files"
 )
 
-// getGitignore locates the first .gitignore file in specified repository path,
-// returning an error if no such file is found.
-func getGitignore(repoPath string) (string, error) {
-	files, err := filepath.Glob(filepath.Join(repoPath, "*.gitignore"))
-	if err != nil {
-		return "", err
-	}
-
-	if len(files) == 0 {
-		return "", os.ErrNotExist
-	}
-
-	return files[0], nil
-}
-
 // LsFiles lists absolute file paths of all files in the specified repository
-// path, excluding those ignored by .gitignore. Only .gitignore files in the
-// root of the repository are considered. Symlinks are listed as files.
+// path. Symlinks are listed as files.
+//
+// If the path is inside a Git repository with an index, the files tracked in
+// the index are listed, without requiring the git binary. Untracked files are
+// left out, while tracked files are listed even if they match ignore rules,
+// matching what Git considers part of the repository.
+//
+// Otherwise, the directory is walked, excluding files ignored by Git. Ignore
+// rules are evaluated the way Git does: .gitignore files are honored in
+// every directory, as well as $GIT_DIR/info/exclude and the user's
+// core.excludesFile. If the path is a subdirectory of a repository, the rules
+// of its parent directories apply too.
 //
 // Note that the target directory is scanned regardless of whether it contains a
 // .git subdirectory. This means LsFiles returns files for any directory, not
-// just Git repositories.
+// just Git repositories. Outside a repository, only .gitignore files are
+// considered.
 func LsFiles(rootPath string) ([]string, error) {
-	// Check for .gitignore file
-	gitignorePath, err := getGitignore(rootPath)
-	hasGitignore := err == nil
-
-	// Compile .gitignore if it exists
-	var gitignore *ignore.GitIgnore
-	if hasGitignore {
-		gitignore, err = ignore.CompileIgnoreFile(gitignorePath)
+	absRoot, err := filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(absRoot); err != nil {
+		return nil, err
+	}
+
+	repo, err := findRepository(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if repo != nil {
+		// Prefer the index of tracked files over walking the directory
+		hasIndex, err := files.FileExists(filepath.Join(repo.gitDir, "index"))
 		if err != nil {
 			return nil, err
 		}
+		if hasIndex {
+			return lsIndexFiles(repo, absRoot)
+		}
+	}
+
+	return walkFiles(absRoot, repo)
+}
+
+// WalkFiles lists absolute file paths of all files in the specified directory
+// that are not ignored by Git, like LsFiles does for directories outside a
+// repository. Unlike LsFiles, the index is not consulted, so files not yet
+// added to the repository are listed too.
+func WalkFiles(rootPath string) ([]string, error) {
+	absRoot, err := filepath.Abs(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(absRoot); err != nil {
+		return nil, err
+	}
+
+	repo, err := findRepository(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	return walkFiles(absRoot, repo)
+}
+
+// walkFiles walks the directory at the absolute path, listing the files not
+// ignored by Git. Ignore rules of the enclosing repository apply, if any.
+func walkFiles(absRoot string, repo *repository) ([]string, error) {
+	// Use the enclosing repository's work tree for ignore rules, if any
+	workTree := absRoot
+	if repo != nil {
+		workTree = repo.workTree
+	}
+
+	ig, err := newIgnorer(workTree, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load .gitignore files of directories between the work tree root and
+	// the target directory
+	rootRel, err := relSlash(workTree, absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := ig.loadDir(""); err != nil {
+		return nil, err
+	}
+	if rootRel != "" {
+		parts := strings.Split(rootRel, "/")
+		for i := range parts {
+			if err := ig.loadDir(strings.Join(parts[:i+1], "/")); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	files := []string{}
@@ -50,33 +112,48 @@ func LsFiles(rootPath string) ([]string, error) {
 			return err
 		}
 
-		// Skip .git directory
-		if d.IsDir() && d.Name() == ".git" {
-			return filepath.SkipDir
-		} else if !d.IsDir() {
-			relPath, err := filepath.Rel(rootPath, path)
-			if err != nil {
-				return err
+		relPath, err := relSlash(workTree, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path == absRoot {
+				return nil
 			}
 
-			if !hasGitignore || !gitignore.MatchesPath(relPath) {
-				// Append absolute path
-				absPath, err := filepath.Abs(filepath.Join(rootPath, relPath))
-				if err != nil {
-					return err
-				}
-				files = append(files, absPath)
+			// Skip .git directory and ignored directories along with their
+			// contents
+			if d.Name() == gitDirName || ig.isIgnored(relPath, true) {
+				return filepath.SkipDir
 			}
+
+			return ig.loadDir(relPath)
 		}
 
+		if !ig.isIgnored(relPath, false) {
+			files = append(files, path)
+		}
 		return nil
 	}
 
 	// Walk the repository directory
-	err = filepath.WalkDir(rootPath, walk)
-	if err != nil {
+	if err := filepath.WalkDir(absRoot, walk); err != nil {
 		return nil, err
 	}
 
 	return files, nil
 }
+
+// relSlash returns the slash-separated path of target relative to base, or an
+// empty string if they are the same.
+func relSlash(base, target string) (string, error) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
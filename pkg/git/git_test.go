@@ -1,34 +1,544 @@
 package git
 
 import (
+	"io/fs"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestLsFiles(t *testing.T) {
-	expectedFiles := []string{
-		"testdata/.gitignore",
-		"testdata/README.md",
-		"testdata/config.json",
-		"testdata/main.go",
-		"testdata/src/app.go",
-		"testdata/src/utils.go",
+// fixtureRenames maps fixture file names to their names on disk. Git metadata
+// is stored under placeholder names so it does not affect this repository.
+var fixtureRenames = map[string]string{
+	"_git":       ".git",
+	"_gitignore": ".gitignore",
+}
+
+// isolateGitConfig points the user's Git configuration to an empty temporary
+// home directory and returns its path.
+func isolateGitConfig(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GIT_CONFIG_GLOBAL", "")
+	return home
+}
+
+// copyFixture copies a fixture directory to a temporary directory, restoring
+// the names of placeholder files, and returns the path of the copy.
+func copyFixture(t *testing.T, src string) string {
+	dst := t.TempDir()
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		for i, part := range parts {
+			if renamed, ok := fixtureRenames[part]; ok {
+				parts[i] = renamed
+			}
+		}
+		target := filepath.Join(dst, filepath.Join(parts...))
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, contents, 0o644)
+	})
+	require.NoError(t, err)
+
+	return dst
+}
+
+// absPaths joins the slash-separated relative paths to the base directory.
+func absPaths(t *testing.T, base string, relPaths []string) []string {
+	paths := []string{}
+	for _, p := range relPaths {
+		absPath, err := filepath.Abs(filepath.Join(base, filepath.FromSlash(p)))
+		require.NoError(t, err)
+		paths = append(paths, absPath)
 	}
+	return paths
+}
+
+func TestLsFiles(t *testing.T) {
+	isolateGitConfig(t)
 
-	absPaths := []string{}
-	for _, f := range expectedFiles {
-		absPath, err := filepath.Abs(f)
-		assert.NoError(t, err)
-		absPaths = append(absPaths, absPath)
+	expectedFiles := []string{
+		".gitignore",
+		"README.md",
+		"config.json",
+		"main.go",
+		"src/app.go",
+		"src/utils.go",
 	}
 
-	files, err := LsFiles("testdata")
+	// Copy fixture out of this repository so that its index does not apply
+	fixture := copyFixture(t, "testdata/ls_files")
+	files, err := LsFiles(fixture)
 	assert.NoError(t, err)
-	assert.ElementsMatch(t, absPaths, files)
+	assert.ElementsMatch(t, absPaths(t, fixture, expectedFiles), files)
 
 	// Test with non-existent directory
 	_, err = LsFiles("testdata/nonexistent")
 	assert.Error(t, err)
 }
+
+func TestLsFilesNested(t *testing.T) {
+	home := isolateGitConfig(t)
+	fixture := copyFixture(t, "testdata/ls_files_nested")
+	repoPath := filepath.Join(fixture, "repo")
+
+	// Configure global exclude file
+	globalConfig := "[core]\n\texcludesFile = " +
+		filepath.ToSlash(filepath.Join(fixture, "global_ignore")) + "\n"
+	require.NoError(t, os.WriteFile(
+		filepath.Join(home, ".gitconfig"), []byte(globalConfig), 0o644))
+
+	cases := []struct {
+		name     string
+		rootPath string
+		want     []string
+	}{
+		{
+			name:     "work tree root",
+			rootPath: repoPath,
+			want: []string{
+				".gitignore",
+				"main.go",
+				// Re-included by negation in the same file
+				"important.log",
+				// Anchored pattern only matches at the root
+				"docs/build/page.md",
+				"src/.gitignore",
+				"src/app.go",
+				// Deeper .gitignore overrides its parent
+				"src/debug.log",
+				"src/pkg/.gitignore",
+				"src/pkg/types.gen.go",
+				// .gitignore overrides info/exclude
+				"src/pkg/keep.bak",
+			},
+		},
+		{
+			name:     "subdirectory",
+			rootPath: filepath.Join(repoPath, "src"),
+			want: []string{
+				"src/.gitignore",
+				"src/app.go",
+				"src/debug.log",
+				"src/pkg/.gitignore",
+				"src/pkg/types.gen.go",
+				"src/pkg/keep.bak",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			files, err := LsFiles(c.rootPath)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, absPaths(t, repoPath, c.want), files)
+		})
+	}
+}
+
+func TestLsFilesNestedWithoutRepository(t *testing.T) {
+	isolateGitConfig(t)
+	fixture := copyFixture(t, "testdata/ls_files_nested")
+	repoPath := filepath.Join(fixture, "repo")
+	require.NoError(t, os.RemoveAll(filepath.Join(repoPath, ".git")))
+
+	// Only .gitignore files apply outside a repository
+	want := []string{
+		".gitignore",
+		"main.go",
+		"important.log",
+		"secret.txt",
+		"notes.swp",
+		"docs/build/page.md",
+		"src/.gitignore",
+		"src/app.go",
+		"src/debug.log",
+		"src/pkg/.gitignore",
+		"src/pkg/types.gen.go",
+		"src/pkg/backup.bak",
+		"src/pkg/keep.bak",
+	}
+
+	files, err := LsFiles(repoPath)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, absPaths(t, repoPath, want), files)
+}
+
+func TestCompileIgnorePattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// basename patterns match at any depth
+		{"*.log", "app.log", false, true},
+		{"*.log", "a/b/app.log", false, true},
+		{"*.log", "app.log.txt", false, false},
+		// patterns with a separator are anchored
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"doc/*.html", "doc/index.html", false, true},
+		{"doc/*.html", "doc/api/index.html", false, false},
+		// directory-only patterns
+		{"out/", "out", true, true},
+		{"out/", "out", false, false},
+		// double asterisks
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "xabc/y", false, false},
+		// single character wildcards and brackets
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "file10.txt", false, false},
+		{"[abc].go", "b.go", false, true},
+		{"[!abc].go", "b.go", false, false},
+		{"[!abc].go", "d.go", false, true},
+		{"[a-c].go", "b.go", false, true},
+		// escapes
+		{`\#hash`, "#hash", false, true},
+		{`\!bang`, "!bang", false, true},
+		{`trailing\ `, "trailing ", false, true},
+		{"trailing  ", "trailing", false, true},
+	}
+
+	for _, c := range cases {
+		p, ok := compileIgnorePattern(c.pattern)
+		require.True(t, ok, c.pattern)
+		list := ignoreList{patterns: []ignorePattern{p}}
+		matched, ignored := list.match(c.path, c.isDir)
+		assert.Equal(t, c.want, matched && ignored,
+			"pattern %q, path %q", c.pattern, c.path)
+	}
+
+	// Blank lines and comments are skipped
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		_, ok := compileIgnorePattern(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	// All fixtures track the same entries, skip-worktree is set in version 3
+	want := []IndexEntry{
+		{Path: ".gitignore", Mode: 0o100644, Size: 7},
+		{Path: "build/generated.go", Mode: 0o100644, Size: 14},
+		{Path: "deleted.go", Mode: 0o100644, Size: 13},
+		{Path: "src/tracked.go", Mode: 0o100644, Size: 12},
+		{Path: "tracked.go", Mode: 0o100644, Size: 13},
+		{Path: "vendor/sub", Mode: 0o160000, Size: 0},
+	}
+
+	for _, version := range []string{"v2", "v3", "v4"} {
+		t.Run(version, func(t *testing.T) {
+			entries, err := ReadIndex("testdata/read_index/index_"+version, 20)
+			require.NoError(t, err)
+
+			wantVersion := slices.Clone(want)
+			if version == "v3" {
+				wantVersion[3].SkipWorktree = true
+			}
+			assert.Equal(t, wantVersion, entries)
+		})
+	}
+
+	// Malformed and missing index files
+	_, err := ReadIndex("testdata/ls_files/README.md", 20)
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+	_, err = ReadIndex("testdata/nonexistent", 20)
+	assert.Error(t, err)
+}
+
+func TestLsFilesIndex(t *testing.T) {
+	isolateGitConfig(t)
+
+	for _, version := range []string{"v2", "v4"} {
+		t.Run(version, func(t *testing.T) {
+			repoPath := copyFixture(t, "testdata/ls_files_index/repo")
+			index, err := os.ReadFile("testdata/read_index/index_" + version)
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".git"), 0o755))
+			require.NoError(t, os.WriteFile(
+				filepath.Join(repoPath, ".git", "index"), index, 0o644))
+
+			// Untracked, deleted and submodule entries are left out, while
+			// tracked files are listed even if ignored
+			want := []string{
+				".gitignore",
+				"build/generated.go",
+				"src/tracked.go",
+				"tracked.go",
+			}
+			files, err := LsFiles(repoPath)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, absPaths(t, repoPath, want), files)
+
+			// Only files inside the target directory are listed
+			files, err = LsFiles(filepath.Join(repoPath, "src"))
+			assert.NoError(t, err)
+			assert.ElementsMatch(t,
+				absPaths(t, repoPath, []string{"src/tracked.go"}), files)
+		})
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	isolateGitConfig(t)
+	repoPath := copyFixture(t, "testdata/ls_files_index/repo")
+	index, err := os.ReadFile("testdata/read_index/index_v2")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(repoPath, ".git"), 0o755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(repoPath, ".git", "index"), index, 0o644))
+
+	// The index is not consulted, so untracked files are listed while
+	// ignored files are left out even if tracked
+	want := []string{
+		".gitignore",
+		"src/tracked.go",
+		"tracked.go",
+		"untracked.txt",
+	}
+	files, err := WalkFiles(repoPath)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, absPaths(t, repoPath, want), files)
+}
+
+func TestAddedLines(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b []string
+		want []int
+	}{
+		{"empty", nil, nil, []int{}},
+		{"all added", nil, []string{"a", "b"}, []int{0, 1}},
+		{"all deleted", []string{"a", "b"}, nil, []int{}},
+		{"unchanged", []string{"a", "b"}, []string{"a", "b"}, []int{}},
+		{"insert middle", []string{"a", "c"}, []string{"a", "b", "c"}, []int{1}},
+		{"replace", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []int{1}},
+		{
+			"interleaved",
+			[]string{"a", "b", "c", "a", "b", "b", "a"},
+			[]string{"c", "b", "a", "b", "a", "c"},
+			[]int{1, 5},
+		},
+		{"duplicate lines", []string{"x", "x"}, []string{"x", "x", "x"}, []int{2}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, addedLines(tc.a, tc.b))
+		})
+	}
+}
+
+func TestResolveRevision(t *testing.T) {
+	isolateGitConfig(t)
+	repoPath := copyFixture(t, "testdata/changes/repo")
+
+	repo, err := findRepository(repoPath)
+	require.NoError(t, err)
+	store, err := newObjectStore(repo)
+	require.NoError(t, err)
+	defer store.close() // nolint:errcheck
+
+	const (
+		feature = "08a9c1d4f5ceba2a848e7f6e55cf9afc77c92256"
+		main    = "b639a18ef34f61de4e74ee1b4d1c10d9ca26c37f"
+	)
+	cases := []struct {
+		rev  string
+		want Hash
+	}{
+		{"HEAD", feature},
+		{"feature", feature},
+		{"refs/heads/feature", feature},
+		{"main", main},
+		// Annotated tags are peeled to the tagged commit
+		{"v1", main},
+		{"tags/v1", main},
+		{"7a3e6350b521a5e29a5b714e249c783c4cf5d55a", "7a3e6350b521a5e29a5b714e249c783c4cf5d55a"},
+	}
+	for _, tc := range cases {
+		h, err := repo.resolveRevision(store, tc.rev)
+		assert.NoError(t, err, tc.rev)
+		assert.Equal(t, tc.want, h, tc.rev)
+	}
+
+	for _, rev := range []string{"unknown", "heads", "0000000000000000000000000000000000000000"} {
+		_, err := repo.resolveRevision(store, rev)
+		assert.Error(t, err, rev)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	isolateGitConfig(t)
+	repoPath := copyFixture(t, "testdata/changes/repo")
+
+	// The fixture's feature branch has five commits, three on main:
+	//  1. Alice adds main.go, src/helpers.go, src/old.go and image.bin
+	//  2. Bob changes main.go and adds src/config.go
+	//  3. Alice deletes src/old.go, changes src/helpers.go and image.bin
+	//  4. Bob adds src/widget.go
+	//  5. Alice changes src/helpers.go
+	cases := []struct {
+		name  string
+		path  string
+		opts  HistoryOptions
+		want  []string
+		added map[string][]string
+	}{
+		{
+			name: "all commits",
+			opts: HistoryOptions{},
+			// Binary and deleted files are left out
+			want: []string{"src/helpers.go", "src/widget.go", "main.go", "src/config.go"},
+		},
+		{
+			name: "recent commits",
+			opts: HistoryOptions{MaxCommits: 2},
+			want: []string{"src/helpers.go", "src/widget.go"},
+			added: map[string][]string{
+				"src/helpers.go": {"func helper5() int { return 5 + offset }"},
+				"src/widget.go":  {"package src", "", "func renderWidget() {}"},
+			},
+		},
+		{
+			name: "branch",
+			opts: HistoryOptions{Base: "main"},
+			want: []string{"src/helpers.go", "src/widget.go"},
+		},
+		{
+			name: "branch and recent commits",
+			opts: HistoryOptions{Base: "v1", MaxCommits: 1},
+			want: []string{"src/helpers.go"},
+		},
+		{
+			name: "author",
+			opts: HistoryOptions{Author: "BOB"},
+			want: []string{"src/widget.go", "main.go", "src/config.go"},
+			added: map[string][]string{
+				"main.go": {"\tlistenForever()"},
+			},
+		},
+		{
+			name: "author and subdirectory",
+			path: "src",
+			opts: HistoryOptions{Author: "alice@example.com", MaxCommits: 2},
+			// Most recent change first, as of that commit
+			want: []string{"src/helpers.go"},
+			added: map[string][]string{
+				"src/helpers.go": {
+					"func helper5() int { return 5 + offset }",
+					"func helper20() int { return 20 * multiplier }",
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := Changes(filepath.Join(repoPath, tc.path), tc.opts)
+			require.NoError(t, err)
+
+			paths := []string{}
+			for _, c := range changes {
+				paths = append(paths, c.Path)
+			}
+			assert.Equal(t, absPaths(t, repoPath, tc.want), paths)
+
+			for _, c := range changes {
+				rel, err := filepath.Rel(repoPath, c.Path)
+				require.NoError(t, err)
+				if want, ok := tc.added[filepath.ToSlash(rel)]; ok {
+					assert.Equal(t, want, c.AddedLines, rel)
+				}
+			}
+		})
+	}
+
+	// Content is taken from the most recent commit changing the file
+	changes, err := Changes(repoPath, HistoryOptions{Author: "bob"})
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t,
+		"package main\n\nfunc main() {\n\tstartServer()\n\tlistenForever()\n}\n",
+		string(changes[1].Content))
+
+	_, err = Changes(repoPath, HistoryOptions{Base: "unknown"})
+	assert.ErrorIs(t, err, ErrUnknownRevision)
+	_, err = Changes(t.TempDir(), HistoryOptions{})
+	assert.ErrorIs(t, err, ErrNotRepository)
+}
+
+func TestIdentity(t *testing.T) {
+	isolateGitConfig(t)
+	repoPath := copyFixture(t, "testdata/changes/repo")
+
+	// Without an origin remote, the root commit identifies the repository
+	id, err := Identity(filepath.Join(repoPath, "src"))
+	require.NoError(t, err)
+	assert.Equal(t, "7a3e6350b521a5e29a5b714e249c783c4cf5d55a", id)
+
+	workTree, err := WorkTree(filepath.Join(repoPath, "src"))
+	require.NoError(t, err)
+	assert.Equal(t, repoPath, workTree)
+
+	config := filepath.Join(repoPath, ".git", "config")
+	f, err := os.OpenFile(config, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString("[remote \"origin\"]\n\turl = git@GitHub.com:user/repo.git\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	id, err = Identity(repoPath)
+	require.NoError(t, err)
+	assert.Equal(t, "github.com/user/repo", id)
+
+	_, err = Identity(t.TempDir())
+	assert.ErrorIs(t, err, ErrNotRepository)
+}
+
+func TestNormalizeRemoteURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/user/repo.git", "github.com/user/repo"},
+		{"https://token@GitHub.com:443/user/repo/", "github.com/user/repo"},
+		{"ssh://git@github.com/user/repo.git", "github.com/user/repo"},
+		{"git@github.com:user/repo.git", "github.com/user/repo"},
+		{"github.com:user/repo", "github.com/user/repo"},
+		{"/srv/git/repo.git", "srv/git/repo"},
+		{`C:\git\repo`, `C:\git\repo`},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, normalizeRemoteURL(tc.url), tc.url)
+	}
+}
@@ -0,0 +1,356 @@
+package git
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// binaryCheckLen is the number of leading bytes inspected for NUL bytes to
+// detect binary blobs, as Git does.
+const binaryCheckLen = 8000
+
+// ErrNotRepository indicates that a path is not inside a Git repository.
+var ErrNotRepository = errors.New("not a git repository")
+
+// HistoryOptions selects the commits whose changes are collected by Changes.
+// Selection starts at HEAD and proceeds from the most recent commit.
+type HistoryOptions struct {
+	// MaxCommits limits the selection to the specified number of most recent
+	// commits. Zero means no limit.
+	MaxCommits int
+	// Base, if set, limits the selection to commits reachable from HEAD but
+	// not from Base, i.e. the commits of the current branch.
+	Base string
+	// Author, if set, limits the selection to commits whose author name or
+	// email contains it, ignoring case.
+	Author string
+}
+
+// FileChange describes the changes made to a text file by the selected
+// commits.
+type FileChange struct {
+	// Path is the absolute path of the file in the work tree.
+	Path string
+	// Content is the content of the file as of the most recent selected commit
+	// changing it.
+	Content []byte
+	// AddedLines are the lines added to the file by the selected commits,
+	// most recent commit first.
+	AddedLines []string
+	// Time is the commit time of the most recent selected commit changing the
+	// file.
+	Time time.Time
+}
+
+// Changes returns the text files changed by the selected commits of the
+// repository enclosing the specified path, limited to files inside the path.
+// The object database is read directly, without requiring the git binary.
+//
+// Merge commits are skipped, as with git log. Files deleted by a more recent
+// selected commit are left out.
+func Changes(path string, opts HistoryOptions) ([]FileChange, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := findRepository(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		return nil, ErrNotRepository
+	}
+
+	store, err := newObjectStore(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer store.close() // nolint:errcheck
+
+	rootRel, err := relSlash(repo.workTree, absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, err := selectCommits(repo, store, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FileChange{}
+	byPath := map[string]int{}
+	deleted := map[string]bool{}
+
+	for _, commit := range commits {
+		var parentTree Hash
+		if len(commit.Parents) == 1 {
+			parent, err := store.readCommit(commit.Parents[0])
+			if err != nil {
+				return nil, err
+			}
+			parentTree = parent.Tree
+		}
+
+		diffs, err := diffTrees(store, "", parentTree, commit.Tree)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range diffs {
+			if rootRel != "" && !strings.HasPrefix(d.path, rootRel+"/") {
+				continue
+			}
+
+			// The most recent change decides whether the file still exists
+			i, seen := byPath[d.path]
+			if !seen && d.newBlob == "" {
+				deleted[d.path] = true
+			}
+			if deleted[d.path] || d.newBlob == "" {
+				continue
+			}
+
+			newData, err := store.readTyped(d.newBlob, ObjectBlob)
+			if err != nil {
+				return nil, err
+			}
+			if isBinary(newData) {
+				continue
+			}
+			var oldData []byte
+			if d.oldBlob != "" {
+				if oldData, err = store.readTyped(d.oldBlob, ObjectBlob); err != nil {
+					return nil, err
+				}
+			}
+
+			newLines := splitLines(newData)
+			var added []string
+			for _, idx := range addedLines(splitLines(oldData), newLines) {
+				added = append(added, newLines[idx])
+			}
+
+			if !seen {
+				byPath[d.path] = len(changes)
+				changes = append(changes, FileChange{
+					Path:    filepath.Join(repo.workTree, filepath.FromSlash(d.path)),
+					Content: newData,
+					Time:    commit.CommitTime,
+				})
+				i = len(changes) - 1
+			}
+			changes[i].AddedLines = append(changes[i].AddedLines, added...)
+		}
+	}
+
+	return changes, nil
+}
+
+// selectCommits returns the non-merge commits selected by the options, most
+// recent first.
+func selectCommits(
+	repo *repository, store *objectStore, opts HistoryOptions,
+) ([]*Commit, error) {
+	head, err := repo.resolveRevision(store, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude everything reachable from the base
+	excluded := map[Hash]bool{}
+	if opts.Base != "" {
+		base, err := repo.resolveRevision(store, opts.Base)
+		if err != nil {
+			return nil, err
+		}
+		pending := []Hash{base}
+		for len(pending) > 0 {
+			h := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if excluded[h] {
+				continue
+			}
+			excluded[h] = true
+
+			c, err := store.readCommit(h)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, c.Parents...)
+		}
+	}
+
+	author := strings.ToLower(opts.Author)
+	selected := []*Commit{}
+	visited := map[Hash]bool{head: true}
+	queue := &commitQueue{}
+
+	if !excluded[head] {
+		c, err := store.readCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(queue, c)
+	}
+
+	// Walk history in reverse chronological order
+	for queue.Len() > 0 {
+		if opts.MaxCommits > 0 && len(selected) >= opts.MaxCommits {
+			break
+		}
+
+		c := heap.Pop(queue).(*Commit)
+		for _, p := range c.Parents {
+			if visited[p] || excluded[p] {
+				continue
+			}
+			visited[p] = true
+			parent, err := store.readCommit(p)
+			if err != nil {
+				return nil, err
+			}
+			heap.Push(queue, parent)
+		}
+
+		if len(c.Parents) > 1 {
+			continue
+		}
+		if author != "" && !strings.Contains(strings.ToLower(c.Author), author) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+
+	return selected, nil
+}
+
+// commitQueue is a priority queue of commits, most recently committed first.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].CommitTime.After(q[j].CommitTime)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// blobDiff describes a file that differs between two trees.
+type blobDiff struct {
+	// path is the slash-separated path of the file.
+	path string
+	// oldBlob is the name of the old content, empty if the file was added.
+	oldBlob Hash
+	// newBlob is the name of the new content, empty if the file was deleted.
+	newBlob Hash
+}
+
+// diffTrees returns the regular files that differ between two trees. Either
+// tree may be empty to list all files of the other.
+func diffTrees(store *objectStore, prefix string, oldTree, newTree Hash) ([]blobDiff, error) {
+	if oldTree == newTree {
+		return nil, nil
+	}
+
+	readEntries := func(h Hash) ([]treeEntry, map[string]treeEntry, error) {
+		if h == "" {
+			return nil, map[string]treeEntry{}, nil
+		}
+		list, err := store.readTree(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		byName := make(map[string]treeEntry, len(list))
+		for _, e := range list {
+			byName[e.name] = e
+		}
+		return list, byName, nil
+	}
+
+	oldList, oldEntries, err := readEntries(oldTree)
+	if err != nil {
+		return nil, err
+	}
+	newList, newEntries, err := readEntries(newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []blobDiff
+	visit := func(name string, oldEntry, newEntry treeEntry) error {
+		path := prefix + name
+
+		// Split entries into subtree and blob parts to handle type changes
+		var oldSub, newSub, oldBlob, newBlob Hash
+		if oldEntry.isTree() {
+			oldSub = oldEntry.hash
+		} else if oldEntry.isBlob() {
+			oldBlob = oldEntry.hash
+		}
+		if newEntry.isTree() {
+			newSub = newEntry.hash
+		} else if newEntry.isBlob() {
+			newBlob = newEntry.hash
+		}
+
+		if oldSub != newSub {
+			sub, err := diffTrees(store, path+"/", oldSub, newSub)
+			if err != nil {
+				return err
+			}
+			diffs = append(diffs, sub...)
+		}
+		if oldBlob != newBlob {
+			diffs = append(diffs, blobDiff{path: path, oldBlob: oldBlob, newBlob: newBlob})
+		}
+		return nil
+	}
+
+	// Visit entries in tree order for deterministic results
+	for _, newEntry := range newList {
+		if err := visit(newEntry.name, oldEntries[newEntry.name], newEntry); err != nil {
+			return nil, err
+		}
+	}
+	for _, oldEntry := range oldList {
+		if _, ok := newEntries[oldEntry.name]; !ok {
+			if err := visit(oldEntry.name, oldEntry, treeEntry{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return diffs, nil
+}
+
+// isBinary reports whether blob content is binary, judging by the presence of
+// NUL bytes at its start.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binaryCheckLen)], 0) >= 0
+}
+
+// splitLines splits content into lines without their line terminators.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,107 @@
+package git
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Identity returns a name identifying the repository enclosing the specified
+// path, equal across clones of the repository. It is the normalized URL of the
+// origin remote, e.g. "github.com/user/repo", or, if no origin is configured,
+// the name of the root commit reached from HEAD via first parents.
+func Identity(path string) (string, error) {
+	repo, err := findRepository(path)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil {
+		return "", ErrNotRepository
+	}
+
+	if remote, ok := repo.config.get("remote", "origin", "url"); ok {
+		if normalized := normalizeRemoteURL(remote); normalized != "" {
+			return normalized, nil
+		}
+	}
+
+	store, err := newObjectStore(repo)
+	if err != nil {
+		return "", err
+	}
+	defer store.close() // nolint:errcheck
+
+	h, err := repo.resolveRevision(store, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for {
+		c, err := store.readCommit(h)
+		if err != nil {
+			return "", err
+		}
+		if len(c.Parents) == 0 {
+			return string(h), nil
+		}
+		h = c.Parents[0]
+	}
+}
+
+// WorkTree returns the absolute path of the work tree root of the repository
+// enclosing the specified path.
+func WorkTree(path string) (string, error) {
+	repo, err := findRepository(path)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil {
+		return "", ErrNotRepository
+	}
+	return repo.workTree, nil
+}
+
+// UserName returns the user name configured for the repository enclosing the
+// specified path, or an empty string if none is configured.
+func UserName(path string) (string, error) {
+	repo, err := findRepository(path)
+	if err != nil {
+		return "", err
+	}
+	if repo == nil {
+		return "", ErrNotRepository
+	}
+
+	name, _ := repo.config.get("user", "", "name")
+	return name, nil
+}
+
+// normalizeRemoteURL reduces a remote URL to its host and path, so that the
+// HTTPS and SSH URLs of a repository compare equal. Host names are
+// lowercased; credentials, ports, a trailing slash and a .git suffix are
+// dropped.
+func normalizeRemoteURL(remote string) string {
+	remote = strings.TrimSpace(remote)
+
+	var host, path string
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if before, after, ok := strings.Cut(remote, ":"); ok &&
+		len(before) > 1 && !strings.ContainsAny(before, `/\`) {
+		// SCP-like syntax, e.g. git@github.com:user/repo.git. Single letters
+		// are Windows drive letters.
+		_, host, _ = strings.Cut(before, "@")
+		if host == "" {
+			host = before
+		}
+		path = after
+	} else {
+		// Local path
+		path = remote
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	path = strings.Trim(path, "/")
+	if host == "" {
+		return path
+	}
+	return strings.ToLower(host) + "/" + path
+}
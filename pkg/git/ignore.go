@@ -0,0 +1,237 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/vupdivup/typomat/pkg/glob"
+)
+
+// ignoreFileName is the name of per-directory ignore files.
+const ignoreFileName = ".gitignore"
+
+// ignorePattern is a single compiled pattern of an ignore file.
+type ignorePattern struct {
+	// re matches the pattern against a slash-separated path.
+	re *regexp.Regexp
+	// negate indicates the pattern re-includes matching paths.
+	negate bool
+	// dirOnly indicates the pattern only matches directories.
+	dirOnly bool
+	// basename indicates the pattern is matched against the last path
+	// component only, at any depth.
+	basename bool
+}
+
+// ignoreList is a list of patterns read from a single source.
+type ignoreList struct {
+	// base is the slash-separated directory the patterns are relative to,
+	// relative to the work tree root. Empty for the root itself.
+	base string
+	// patterns are the patterns in the order of definition.
+	patterns []ignorePattern
+}
+
+// match reports whether the list has an opinion on the path and, if so,
+// whether the path is ignored. As in Git, the last matching pattern decides.
+func (l *ignoreList) match(relPath string, isDir bool) (matched, ignored bool) {
+	if l.base != "" {
+		relPath = strings.TrimPrefix(relPath, l.base+"/")
+	}
+	name := path.Base(relPath)
+
+	for _, p := range slices.Backward(l.patterns) {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		subject := relPath
+		if p.basename {
+			subject = name
+		}
+		if p.re.MatchString(subject) {
+			return true, !p.negate
+		}
+	}
+
+	return false, false
+}
+
+// ignorer evaluates ignore rules with the same precedence as Git. From highest
+// to lowest precedence, these are:
+//
+//  1. .gitignore files, deeper directories taking precedence over their
+//     parents
+//  2. $GIT_DIR/info/exclude
+//  3. the file configured via core.excludesFile
+type ignorer struct {
+	// root is the absolute path of the work tree root.
+	root string
+	// dirLists holds patterns of .gitignore files keyed by the slash-separated
+	// directory they reside in, relative to root.
+	dirLists map[string]*ignoreList
+	// fileLists holds patterns of repository-wide exclude files, in
+	// precedence order.
+	fileLists []*ignoreList
+}
+
+// newIgnorer creates an ignorer for the work tree at root. If repo is not
+// nil, the repository's exclude files are taken into account as well.
+func newIgnorer(root string, repo *repository) (*ignorer, error) {
+	ig := &ignorer{root: root, dirLists: map[string]*ignoreList{}}
+
+	if repo == nil {
+		return ig, nil
+	}
+
+	excludeFiles := []string{filepath.Join(repo.commonDir, "info", "exclude")}
+	if excludesFile := repo.excludesFile(); excludesFile != "" {
+		excludeFiles = append(excludeFiles, excludesFile)
+	}
+
+	for _, f := range excludeFiles {
+		list, err := readIgnoreFile(f, "")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ig.fileLists = append(ig.fileLists, list)
+	}
+
+	return ig, nil
+}
+
+// loadDir reads the .gitignore file of the specified directory, if any.
+// relDir is slash-separated and relative to the work tree root.
+func (ig *ignorer) loadDir(relDir string) error {
+	if _, ok := ig.dirLists[relDir]; ok {
+		return nil
+	}
+
+	f := filepath.Join(ig.root, filepath.FromSlash(relDir), ignoreFileName)
+	list, err := readIgnoreFile(f, relDir)
+	if errors.Is(err, os.ErrNotExist) {
+		list = &ignoreList{base: relDir}
+	} else if err != nil {
+		return err
+	}
+
+	ig.dirLists[relDir] = list
+	return nil
+}
+
+// isIgnored reports whether the path is ignored. relPath is slash-separated
+// and relative to the work tree root. The .gitignore files of all parent
+// directories must have been loaded via loadDir.
+//
+// Note that the contents of an ignored directory are not checked against
+// the directory itself; callers are expected not to descend into ignored
+// directories, as Git does.
+func (ig *ignorer) isIgnored(relPath string, isDir bool) bool {
+	dir := relPath
+	for dir != "" {
+		dir = parentDir(dir)
+		if list, ok := ig.dirLists[dir]; ok {
+			if matched, ignored := list.match(relPath, isDir); matched {
+				return ignored
+			}
+		}
+	}
+
+	for _, list := range ig.fileLists {
+		if matched, ignored := list.match(relPath, isDir); matched {
+			return ignored
+		}
+	}
+
+	return false
+}
+
+// parentDir returns the parent of a slash-separated relative path, or an
+// empty string for top-level paths.
+func parentDir(relPath string) string {
+	if i := strings.LastIndexByte(relPath, '/'); i >= 0 {
+		return relPath[:i]
+	}
+	return ""
+}
+
+// readIgnoreFile compiles the patterns of an ignore file residing in base.
+func readIgnoreFile(f string, base string) (*ignoreList, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &ignoreList{base: base}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if p, ok := compileIgnorePattern(scanner.Text()); ok {
+			list.patterns = append(list.patterns, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// compileIgnorePattern compiles a single line of an ignore file. The boolean
+// return value is false for blank lines, comments and invalid patterns.
+//
+// See https://git-scm.com/docs/gitignore#_pattern_format for the syntax.
+func compileIgnorePattern(line string) (ignorePattern, bool) {
+	var p ignorePattern
+
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns without a separator match at any depth, others are anchored
+	// to the directory of the ignore file
+	if !strings.Contains(line, "/") {
+		p.basename = true
+	}
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return p, false
+	}
+
+	re, err := regexp.Compile("^" + glob.ToRegexp(line) + "$")
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+
+	return p, true
+}
+
+// trimTrailingSpaces removes trailing spaces that are not escaped with a
+// backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,223 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// indexSignature is the magic number at the start of an index file.
+	indexSignature = "DIRC"
+
+	// indexStatSize is the size of the stat data at the start of each index
+	// entry, from ctime up to and including the file size.
+	indexStatSize = 40
+
+	// indexFlagExtended marks an index entry with extended flags.
+	indexFlagExtended = 0x4000
+	// indexFlagStageMask masks the merge stage in the flags of an index entry.
+	indexFlagStageMask = 0x3000
+	// indexFlagNameMask masks the name length in the flags of an index entry.
+	indexFlagNameMask = 0x0fff
+	// indexExtFlagSkipWorktree marks an index entry whose file is not checked
+	// out, as in sparse checkouts.
+	indexExtFlagSkipWorktree = 0x4000
+
+	// modeTypeMask masks the object type of a file mode.
+	modeTypeMask = 0o170000
+	// modeGitlink is the object type of submodule entries.
+	modeGitlink = 0o160000
+	// modeDir is the object type of sparse directory entries.
+	modeDir = 0o040000
+)
+
+// ErrInvalidIndex indicates that an index file is malformed or uses an
+// unsupported format.
+var ErrInvalidIndex = errors.New("invalid git index")
+
+// IndexEntry represents a file tracked in a repository's index.
+type IndexEntry struct {
+	// Path is the slash-separated path of the file relative to the work tree
+	// root.
+	Path string
+	// Mode is the file mode as recorded by Git, e.g. 0o100644.
+	Mode uint32
+	// Size is the size of the file in bytes as of the last index update.
+	Size uint32
+	// SkipWorktree indicates the file is excluded from the work tree by a
+	// sparse checkout.
+	SkipWorktree bool
+}
+
+// IsRegular reports whether the entry refers to a regular file or symlink, as
+// opposed to a submodule or a sparse directory.
+func (e IndexEntry) IsRegular() bool {
+	t := e.Mode & modeTypeMask
+	return t != modeGitlink && t != modeDir
+}
+
+// ReadIndex reads the entries of the index file at the specified path.
+// Versions 2, 3 and 4 of the index format are supported. Entries in a merge
+// conflict are listed once. Extensions are ignored.
+//
+// hashSize is the size of object names in bytes, i.e. 20 for SHA-1 and 32 for
+// SHA-256 repositories.
+func ReadIndex(path string, hashSize int) ([]IndexEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	// Parse header
+	var header struct {
+		Signature [4]byte
+		Version   uint32
+		Count     uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+	}
+	if string(header.Signature[:]) != indexSignature {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIndex)
+	}
+	if header.Version < 2 || header.Version > 4 {
+		return nil, fmt.Errorf("%w: unsupported version %d",
+			ErrInvalidIndex, header.Version)
+	}
+
+	entries := make([]IndexEntry, 0, header.Count)
+	prevPath := ""
+	fixed := make([]byte, indexStatSize+hashSize+2)
+
+	for range header.Count {
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+		}
+		entrySize := len(fixed)
+
+		entry := IndexEntry{
+			Mode: binary.BigEndian.Uint32(fixed[24:28]),
+			Size: binary.BigEndian.Uint32(fixed[36:40]),
+		}
+		flags := binary.BigEndian.Uint16(fixed[indexStatSize+hashSize:])
+
+		if flags&indexFlagExtended != 0 && header.Version >= 3 {
+			var extFlags uint16
+			if err := binary.Read(r, binary.BigEndian, &extFlags); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+			}
+			entry.SkipWorktree = extFlags&indexExtFlagSkipWorktree != 0
+			entrySize += 2
+		}
+
+		// Parse path
+		if header.Version == 4 {
+			// Path is prefix-compressed against the previous entry
+			strip, err := readIndexVarint(r)
+			if err != nil || strip > uint64(len(prevPath)) {
+				return nil, fmt.Errorf("%w: bad path prefix", ErrInvalidIndex)
+			}
+			suffix, err := r.ReadString(0)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+			}
+			entry.Path = prevPath[:len(prevPath)-int(strip)] +
+				strings.TrimSuffix(suffix, "\x00")
+		} else {
+			// Path is NUL-padded so that the entry size is a multiple of 8
+			nameLen := int(flags & indexFlagNameMask)
+			name, err := r.ReadBytes(0)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+			}
+			entry.Path = string(bytes.TrimSuffix(name, []byte{0}))
+			if nameLen < indexFlagNameMask && len(entry.Path) != nameLen {
+				return nil, fmt.Errorf("%w: bad path length", ErrInvalidIndex)
+			}
+
+			consumed := entrySize + len(name)
+			padding := (8 - consumed%8) % 8
+			if _, err := r.Discard(padding); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidIndex, err)
+			}
+		}
+		prevPath = entry.Path
+
+		// Entries in a merge conflict appear once per stage
+		stage := flags & indexFlagStageMask
+		if stage != 0 && len(entries) > 0 &&
+			entries[len(entries)-1].Path == entry.Path {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// readIndexVarint reads a variable-length integer with the offset encoding
+// used for path prefixes in version 4 index files.
+func readIndexVarint(r io.ByteReader) (uint64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	val := uint64(c & 0x7f)
+	for c&0x80 != 0 {
+		c, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		val = ((val + 1) << 7) | uint64(c&0x7f)
+	}
+
+	return val, nil
+}
+
+// lsIndexFiles lists absolute paths of files tracked in the repository's index
+// that reside in the specified directory and exist in the work tree.
+func lsIndexFiles(repo *repository, absRoot string) ([]string, error) {
+	entries, err := ReadIndex(filepath.Join(repo.gitDir, "index"), repo.hashSize())
+	if err != nil {
+		return nil, err
+	}
+
+	rootRel, err := relSlash(repo.workTree, absRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsRegular() || entry.SkipWorktree {
+			continue
+		}
+		if rootRel != "" && !strings.HasPrefix(entry.Path, rootRel+"/") {
+			continue
+		}
+
+		// Skip files deleted from the work tree but not from the index
+		path := filepath.Join(repo.workTree, filepath.FromSlash(entry.Path))
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		files = append(files, path)
+	}
+
+	return files, nil
+}
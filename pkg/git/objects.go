@@ -0,0 +1,216 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ObjectType is the type of a Git object.
+type ObjectType int
+
+const (
+	// ObjectCommit is the type of commit objects.
+	ObjectCommit ObjectType = 1
+	// ObjectTree is the type of tree objects.
+	ObjectTree ObjectType = 2
+	// ObjectBlob is the type of blob objects, i.e. file contents.
+	ObjectBlob ObjectType = 3
+	// ObjectTag is the type of annotated tag objects.
+	ObjectTag ObjectType = 4
+)
+
+var (
+	// ErrObjectNotFound indicates that an object is missing from the
+	// repository's object database.
+	ErrObjectNotFound = errors.New("git object not found")
+	// ErrInvalidObject indicates that an object or pack file is malformed or
+	// uses an unsupported format.
+	ErrInvalidObject = errors.New("invalid git object")
+)
+
+// objectTypeNames maps object type names used in loose objects to their
+// types.
+var objectTypeNames = map[string]ObjectType{
+	"commit": ObjectCommit,
+	"tree":   ObjectTree,
+	"blob":   ObjectBlob,
+	"tag":    ObjectTag,
+}
+
+// Hash is the name of a Git object in hexadecimal form.
+type Hash string
+
+// object is an object read from the object database.
+type object struct {
+	// typ is the type of the object.
+	typ ObjectType
+	// data is the uncompressed content of the object.
+	data []byte
+}
+
+// objectStore reads objects from a repository's object database, both loose
+// and packed.
+type objectStore struct {
+	// dirs are the object directories to search, including alternates.
+	dirs []string
+	// packs are the pack files of all object directories.
+	packs []*packFile
+	// hashSize is the size of object names in bytes.
+	hashSize int
+}
+
+// newObjectStore opens the object database of the repository.
+func newObjectStore(repo *repository) (*objectStore, error) {
+	s := &objectStore{hashSize: repo.hashSize()}
+
+	// Collect object directories, following alternates
+	pending := []string{filepath.Join(repo.commonDir, "objects")}
+	seen := map[string]bool{}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		s.dirs = append(s.dirs, dir)
+
+		alternates, err := readLines(filepath.Join(dir, "info", "alternates"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, alt := range alternates {
+			if alt == "" || strings.HasPrefix(alt, "#") {
+				continue
+			}
+			if !filepath.IsAbs(alt) {
+				alt = filepath.Join(dir, alt)
+			}
+			pending = append(pending, filepath.Clean(alt))
+		}
+	}
+
+	// Open pack indexes
+	for _, dir := range s.dirs {
+		idxPaths, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		if err != nil {
+			return nil, err
+		}
+		for _, idxPath := range idxPaths {
+			pack, err := openPackFile(idxPath, s.hashSize)
+			if err != nil {
+				return nil, err
+			}
+			s.packs = append(s.packs, pack)
+		}
+	}
+
+	return s, nil
+}
+
+// close releases the pack files of the store.
+func (s *objectStore) close() error {
+	var errs []error
+	for _, pack := range s.packs {
+		errs = append(errs, pack.close())
+	}
+	return errors.Join(errs...)
+}
+
+// read returns the object with the specified name.
+func (s *objectStore) read(h Hash) (object, error) {
+	raw, err := hex.DecodeString(string(h))
+	if err != nil || len(raw) != s.hashSize {
+		return object{}, fmt.Errorf("%w: bad object name %q", ErrInvalidObject, h)
+	}
+
+	for _, pack := range s.packs {
+		if offset, ok := pack.find(raw); ok {
+			return pack.readAt(offset, s)
+		}
+	}
+
+	for _, dir := range s.dirs {
+		obj, err := readLooseObject(dir, h)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return obj, err
+	}
+
+	return object{}, fmt.Errorf("%w: %s", ErrObjectNotFound, h)
+}
+
+// readTyped returns the content of the object with the specified name,
+// checking that it has the expected type.
+func (s *objectStore) readTyped(h Hash, typ ObjectType) ([]byte, error) {
+	obj, err := s.read(h)
+	if err != nil {
+		return nil, err
+	}
+	if obj.typ != typ {
+		return nil, fmt.Errorf("%w: %s has type %d, expected %d",
+			ErrInvalidObject, h, obj.typ, typ)
+	}
+	return obj.data, nil
+}
+
+// readLooseObject reads a zlib-compressed object stored in its own file.
+func readLooseObject(dir string, h Hash) (object, error) {
+	file, err := os.Open(filepath.Join(dir, string(h[:2]), string(h[2:])))
+	if err != nil {
+		return object{}, err
+	}
+	defer file.Close()
+
+	zr, err := zlib.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+	defer zr.Close()
+
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+
+	// Parse header, e.g. "blob 12\x00"
+	header, data, ok := bytes.Cut(content, []byte{0})
+	if !ok {
+		return object{}, fmt.Errorf("%w: missing header", ErrInvalidObject)
+	}
+	typeName, sizeStr, _ := strings.Cut(string(header), " ")
+	typ, ok := objectTypeNames[typeName]
+	if !ok {
+		return object{}, fmt.Errorf("%w: unknown type %q", ErrInvalidObject, typeName)
+	}
+	if size, err := strconv.Atoi(sizeStr); err != nil || size != len(data) {
+		return object{}, fmt.Errorf("%w: size mismatch", ErrInvalidObject)
+	}
+
+	return object{typ: typ, data: data}, nil
+}
+
+// readLines reads the lines of a text file with surrounding whitespace
+// removed.
+func readLines(path string) ([]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for line := range strings.Lines(string(contents)) {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return lines, nil
+}
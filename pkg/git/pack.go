@@ -0,0 +1,294 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// packObjOfsDelta is the pack entry type of deltas against an object at
+	// a relative offset in the same pack.
+	packObjOfsDelta = 6
+	// packObjRefDelta is the pack entry type of deltas against an object
+	// referenced by name.
+	packObjRefDelta = 7
+
+	// packIdxMagic is the magic number of version 2 pack index files.
+	packIdxMagic = "\xfftOc"
+
+	// maxDeltaCacheEntries is the number of resolved pack objects kept in
+	// memory to speed up delta chains.
+	maxDeltaCacheEntries = 256
+)
+
+// packFile provides access to the objects of a pack file through its index.
+type packFile struct {
+	// file is the open pack file.
+	file *os.File
+	// names are the sorted object names in the pack, concatenated.
+	names []byte
+	// offsets are the pack offsets of the objects, in the order of names.
+	offsets []uint64
+	// fanout holds the cumulative number of objects by first name byte.
+	fanout [256]uint32
+	// hashSize is the size of object names in bytes.
+	hashSize int
+
+	// mu guards cache.
+	mu sync.Mutex
+	// cache holds recently resolved objects keyed by pack offset.
+	cache map[uint64]object
+}
+
+// openPackFile opens the pack file belonging to the version 2 pack index at
+// idxPath.
+func openPackFile(idxPath string, hashSize int) (*packFile, error) {
+	idx, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(idx) < 8+256*4 || string(idx[:4]) != packIdxMagic ||
+		binary.BigEndian.Uint32(idx[4:8]) != 2 {
+		return nil, fmt.Errorf("%w: unsupported pack index %s",
+			ErrInvalidObject, idxPath)
+	}
+
+	p := &packFile{hashSize: hashSize, cache: map[uint64]object{}}
+	pos := 8
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(idx[pos:])
+		pos += 4
+	}
+	count := int(p.fanout[255])
+
+	// Layout: names, CRC32 checksums, 4-byte offsets, 8-byte large offsets
+	namesEnd := pos + count*hashSize
+	offsetsStart := namesEnd + count*4
+	largeStart := offsetsStart + count*4
+	if len(idx) < largeStart {
+		return nil, fmt.Errorf("%w: truncated pack index %s",
+			ErrInvalidObject, idxPath)
+	}
+	p.names = idx[pos:namesEnd]
+
+	p.offsets = make([]uint64, count)
+	for i := range count {
+		offset := binary.BigEndian.Uint32(idx[offsetsStart+i*4:])
+		if offset&0x80000000 == 0 {
+			p.offsets[i] = uint64(offset)
+			continue
+		}
+
+		largePos := largeStart + int(offset&0x7fffffff)*8
+		if len(idx) < largePos+8 {
+			return nil, fmt.Errorf("%w: truncated pack index %s",
+				ErrInvalidObject, idxPath)
+		}
+		p.offsets[i] = binary.BigEndian.Uint64(idx[largePos:])
+	}
+
+	p.file, err = os.Open(strings.TrimSuffix(idxPath, ".idx") + ".pack")
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// close closes the pack file.
+func (p *packFile) close() error {
+	return p.file.Close()
+}
+
+// find returns the pack offset of the object with the specified raw name.
+func (p *packFile) find(name []byte) (uint64, bool) {
+	lo := 0
+	if name[0] > 0 {
+		lo = int(p.fanout[name[0]-1])
+	}
+	hi := int(p.fanout[name[0]])
+
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		start := (lo + i) * p.hashSize
+		return bytes.Compare(p.names[start:start+p.hashSize], name) >= 0
+	})
+	if i < hi && bytes.Equal(p.names[i*p.hashSize:(i+1)*p.hashSize], name) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// readAt reads and, if necessary, resolves the object at the specified pack
+// offset. Deltas against objects in other packs are resolved through store.
+func (p *packFile) readAt(offset uint64, store *objectStore) (object, error) {
+	p.mu.Lock()
+	obj, ok := p.cache[offset]
+	p.mu.Unlock()
+	if ok {
+		return obj, nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(p.file, int64(offset), 1<<62))
+
+	// Parse entry header: type and inflated size as a variable-length integer
+	c, err := r.ReadByte()
+	if err != nil {
+		return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+	typ := int(c>>4) & 0x07
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = r.ReadByte(); err != nil {
+			return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var base object
+	switch typ {
+	case packObjOfsDelta:
+		// Base offset is encoded relative to this entry
+		c, err := r.ReadByte()
+		if err != nil {
+			return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+		}
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+			}
+			rel = ((rel + 1) << 7) | uint64(c&0x7f)
+		}
+		if rel == 0 || rel > offset {
+			return object{}, fmt.Errorf("%w: bad delta offset", ErrInvalidObject)
+		}
+		if base, err = p.readAt(offset-rel, store); err != nil {
+			return object{}, err
+		}
+	case packObjRefDelta:
+		name := make([]byte, p.hashSize)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return object{}, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+		}
+		if base, err = store.read(Hash(hex.EncodeToString(name))); err != nil {
+			return object{}, err
+		}
+	}
+
+	data, err := inflate(r, size)
+	if err != nil {
+		return object{}, err
+	}
+
+	switch typ {
+	case packObjOfsDelta, packObjRefDelta:
+		if data, err = applyDelta(base.data, data); err != nil {
+			return object{}, err
+		}
+		obj = object{typ: base.typ, data: data}
+	case int(ObjectCommit), int(ObjectTree), int(ObjectBlob), int(ObjectTag):
+		obj = object{typ: ObjectType(typ), data: data}
+	default:
+		return object{}, fmt.Errorf("%w: unknown pack entry type %d",
+			ErrInvalidObject, typ)
+	}
+
+	// Cache the object for subsequent deltas, evicting everything when full
+	p.mu.Lock()
+	if len(p.cache) >= maxDeltaCacheEntries {
+		clear(p.cache)
+	}
+	p.cache[offset] = obj
+	p.mu.Unlock()
+
+	return obj, nil
+}
+
+// inflate decompresses zlib data of the expected size.
+func inflate(r io.Reader, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+	defer zr.Close()
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+	return data, nil
+}
+
+// applyDelta reconstructs an object from its base and a delta consisting of
+// copy and insert instructions.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+
+	baseSize, err := binary.ReadUvarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: delta base size mismatch", ErrInvalidObject)
+	}
+	resultSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+	}
+
+	result := make([]byte, 0, resultSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+
+		if op&0x80 == 0 {
+			// Insert the next op bytes of the delta
+			if op == 0 || int(op) > r.Len() {
+				return nil, fmt.Errorf("%w: bad delta insert", ErrInvalidObject)
+			}
+			chunk := make([]byte, op)
+			r.Read(chunk) // nolint:errcheck
+			result = append(result, chunk...)
+			continue
+		}
+
+		// Copy a range of the base, offset and size bytes present per flag
+		var offset, size uint64
+		for i := range 4 {
+			if op&(1<<i) != 0 {
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+				}
+				offset |= uint64(b) << (8 * i)
+			}
+		}
+		for i := range 3 {
+			if op&(0x10<<i) != 0 {
+				b, err := r.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrInvalidObject, err)
+				}
+				size |= uint64(b) << (8 * i)
+			}
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > uint64(len(base)) {
+			return nil, fmt.Errorf("%w: bad delta copy", ErrInvalidObject)
+		}
+		result = append(result, base[offset:offset+size]...)
+	}
+
+	if uint64(len(result)) != resultSize {
+		return nil, fmt.Errorf("%w: delta result size mismatch", ErrInvalidObject)
+	}
+	return result, nil
+}
@@ -0,0 +1,286 @@
+package git
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSymrefDepth is the maximum number of symbolic references followed when
+// resolving a reference.
+const maxSymrefDepth = 8
+
+// ErrUnknownRevision indicates that a revision does not name a commit in the
+// repository.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// Commit represents a parsed commit object.
+type Commit struct {
+	// Hash is the name of the commit object.
+	Hash Hash
+	// Tree is the name of the commit's root tree.
+	Tree Hash
+	// Parents are the names of the parent commits.
+	Parents []Hash
+	// Author is the author's identity, e.g. "Jane Doe <jane@example.com>".
+	Author string
+	// AuthorTime is the time the commit was authored.
+	AuthorTime time.Time
+	// CommitTime is the time the commit was committed.
+	CommitTime time.Time
+}
+
+// treeEntry represents an entry of a tree object.
+type treeEntry struct {
+	// name is the file or directory name.
+	name string
+	// mode is the file mode, e.g. 0o100644 or 0o040000.
+	mode uint32
+	// hash is the name of the referenced object.
+	hash Hash
+}
+
+// isTree reports whether the entry refers to a subdirectory.
+func (e treeEntry) isTree() bool {
+	return e.mode&modeTypeMask == modeDir
+}
+
+// isBlob reports whether the entry refers to a regular file.
+func (e treeEntry) isBlob() bool {
+	return e.mode&modeTypeMask == 0o100000
+}
+
+// readCommit reads and parses the commit with the specified name.
+func (s *objectStore) readCommit(h Hash) (*Commit, error) {
+	data, err := s.readTyped(h, ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Commit{Hash: h}
+	headers, _, _ := bytes.Cut(data, []byte("\n\n"))
+	for line := range strings.Lines(string(headers)) {
+		key, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		switch key {
+		case "tree":
+			c.Tree = Hash(value)
+		case "parent":
+			c.Parents = append(c.Parents, Hash(value))
+		case "author":
+			c.Author, c.AuthorTime = parseSignature(value)
+		case "committer":
+			_, c.CommitTime = parseSignature(value)
+		}
+	}
+
+	if c.Tree == "" {
+		return nil, fmt.Errorf("%w: commit %s has no tree", ErrInvalidObject, h)
+	}
+	return c, nil
+}
+
+// parseSignature splits an author or committer header value into identity
+// and time, e.g. "Jane Doe <jane@example.com> 1700000000 +0100".
+func parseSignature(value string) (string, time.Time) {
+	end := strings.LastIndexByte(value, '>')
+	if end < 0 {
+		return value, time.Time{}
+	}
+
+	identity := value[:end+1]
+	fields := strings.Fields(value[end+1:])
+	if len(fields) == 0 {
+		return identity, time.Time{}
+	}
+
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return identity, time.Time{}
+	}
+	return identity, time.Unix(unix, 0)
+}
+
+// readTree reads and parses the tree with the specified name.
+func (s *objectStore) readTree(h Hash) ([]treeEntry, error) {
+	data, err := s.readTyped(h, ObjectTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each entry is "<octal mode> <name>\x00<raw object name>"
+	var entries []treeEntry
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < s.hashSize {
+			return nil, fmt.Errorf("%w: truncated tree %s", ErrInvalidObject, h)
+		}
+		modeStr, name, _ := strings.Cut(string(header), " ")
+		mode, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad mode in tree %s", ErrInvalidObject, h)
+		}
+
+		entries = append(entries, treeEntry{
+			name: name,
+			mode: uint32(mode),
+			hash: Hash(hex.EncodeToString(rest[:s.hashSize])),
+		})
+		data = rest[s.hashSize:]
+	}
+
+	return entries, nil
+}
+
+// peelToCommit follows annotated tags until reaching a commit.
+func (s *objectStore) peelToCommit(h Hash) (Hash, error) {
+	for range maxSymrefDepth {
+		obj, err := s.read(h)
+		if err != nil {
+			return "", err
+		}
+
+		switch obj.typ {
+		case ObjectCommit:
+			return h, nil
+		case ObjectTag:
+			target, _, _ := bytes.Cut(obj.data, []byte("\n"))
+			name, ok := bytes.CutPrefix(target, []byte("object "))
+			if !ok {
+				return "", fmt.Errorf("%w: tag %s has no object", ErrInvalidObject, h)
+			}
+			h = Hash(name)
+		default:
+			return "", fmt.Errorf("%w: %s is not a commit", ErrUnknownRevision, h)
+		}
+	}
+
+	return "", fmt.Errorf("%w: tag chain too long", ErrUnknownRevision)
+}
+
+// resolveRevision resolves a revision to a commit name. Supported revisions
+// are full object names, HEAD and reference names, either full or
+// abbreviated, e.g. "main", "origin/main" or "v1.0".
+func (r *repository) resolveRevision(s *objectStore, rev string) (Hash, error) {
+	if isHash(rev, s.hashSize) {
+		return s.peelToCommit(Hash(strings.ToLower(rev)))
+	}
+
+	// Same lookup order as Git's rev-parse
+	candidates := []string{
+		rev,
+		"refs/" + rev,
+		"refs/tags/" + rev,
+		"refs/heads/" + rev,
+		"refs/remotes/" + rev,
+		"refs/remotes/" + rev + "/HEAD",
+	}
+	for _, name := range candidates {
+		h, err := r.resolveRef(name)
+		if errors.Is(err, ErrUnknownRevision) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return s.peelToCommit(h)
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownRevision, rev)
+}
+
+// resolveRef resolves a full reference name, following symbolic references.
+func (r *repository) resolveRef(name string) (Hash, error) {
+	for range maxSymrefDepth {
+		value, err := r.readRef(name)
+		if err != nil {
+			return "", err
+		}
+
+		target, ok := strings.CutPrefix(value, "ref: ")
+		if !ok {
+			return Hash(value), nil
+		}
+		name = target
+	}
+
+	return "", fmt.Errorf("%w: symbolic reference chain too long", ErrUnknownRevision)
+}
+
+// readRef returns the raw value of a reference, looking up loose references
+// before packed ones.
+func (r *repository) readRef(name string) (string, error) {
+	// HEAD and other pseudo references live in the per-work tree directory
+	dirs := []string{r.commonDir}
+	if !strings.HasPrefix(name, "refs/") {
+		if !isPseudoRef(name) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+		}
+		dirs = []string{r.gitDir}
+	}
+
+	for _, dir := range dirs {
+		contents, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil {
+			return strings.TrimSpace(string(contents)), nil
+		} else if !errors.Is(err, os.ErrNotExist) && !isDirError(err) {
+			return "", err
+		}
+	}
+
+	lines, err := readLines(filepath.Join(r.commonDir, "packed-refs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+	} else if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		hash, refName, _ := strings.Cut(line, " ")
+		if refName == name {
+			return hash, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownRevision, name)
+}
+
+// isDirError reports whether the error was caused by reading a directory as a
+// file, which happens for reference names that are prefixes of others.
+func isDirError(err error) bool {
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	info, statErr := os.Stat(pathErr.Path)
+	return statErr == nil && info.IsDir()
+}
+
+// isHash reports whether the string is a full object name.
+func isHash(s string, hashSize int) bool {
+	if len(s) != hashSize*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// isPseudoRef reports whether the name is a pseudo reference such as HEAD or
+// ORIG_HEAD, which consist of uppercase letters and underscores only.
+func isPseudoRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,277 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitDirName is the name of the directory holding a repository's metadata.
+const gitDirName = ".git"
+
+// repository describes the location of a Git repository on disk.
+type repository struct {
+	// workTree is the absolute path of the work tree root.
+	workTree string
+	// gitDir is the absolute path of the repository's Git directory.
+	gitDir string
+	// commonDir is the absolute path of the directory shared between work
+	// trees. Same as gitDir unless the work tree is a linked work tree.
+	commonDir string
+	// config holds the merged global and repository configuration.
+	config gitConfig
+}
+
+// findRepository locates the repository enclosing the specified path by
+// searching it and its parent directories for a .git entry. It returns nil
+// if the path is not inside a repository.
+func findRepository(path string) (*repository, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for dir := absPath; ; dir = filepath.Dir(dir) {
+		gitDir, err := resolveGitDir(filepath.Join(dir, gitDirName))
+		if err != nil {
+			return nil, err
+		}
+		if gitDir != "" {
+			return openRepository(dir, gitDir)
+		}
+
+		if filepath.Dir(dir) == dir {
+			return nil, nil
+		}
+	}
+}
+
+// resolveGitDir returns the Git directory referenced by a .git entry, which
+// is either the directory itself or a file pointing to it, as used by linked
+// work trees and submodules. It returns an empty string if the entry does not
+// exist.
+func resolveGitDir(dotGit string) (string, error) {
+	info, err := os.Stat(dotGit)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if info.IsDir() {
+		return dotGit, nil
+	}
+
+	contents, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir:")
+	if !ok {
+		return "", nil
+	}
+
+	target = strings.TrimSpace(target)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(dotGit), target)
+	}
+	return target, nil
+}
+
+// openRepository reads the configuration of the repository with the
+// specified work tree and Git directory.
+func openRepository(workTree, gitDir string) (*repository, error) {
+	repo := &repository{
+		workTree:  workTree,
+		gitDir:    gitDir,
+		commonDir: gitDir,
+		config:    gitConfig{},
+	}
+
+	// Linked work trees share most of their metadata with the main one
+	if contents, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir := strings.TrimSpace(string(contents))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		repo.commonDir = commonDir
+	}
+
+	// Later files take precedence over earlier ones
+	configFiles := append(globalConfigFiles(),
+		filepath.Join(repo.commonDir, "config"))
+	for _, f := range configFiles {
+		err := repo.config.readFile(f)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return repo, nil
+}
+
+// excludesFile returns the path of the user's global exclude file, or an
+// empty string if it cannot be determined.
+func (r *repository) excludesFile() string {
+	if f, ok := r.config.get("core", "", "excludesfile"); ok {
+		return expandHome(f)
+	}
+
+	if dir := xdgConfigHome(); dir != "" {
+		return filepath.Join(dir, "git", "ignore")
+	}
+	return ""
+}
+
+// hashSize returns the size of object names in bytes, which depends on the
+// repository's object format.
+func (r *repository) hashSize() int {
+	if format, ok := r.config.get("extensions", "", "objectformat"); ok &&
+		strings.EqualFold(format, "sha256") {
+		return 32
+	}
+	return 20
+}
+
+// globalConfigFiles returns the paths of the user's global Git configuration
+// files in ascending order of precedence.
+func globalConfigFiles() []string {
+	if f := os.Getenv("GIT_CONFIG_GLOBAL"); f != "" {
+		return []string{f}
+	}
+
+	var configFiles []string
+	if dir := xdgConfigHome(); dir != "" {
+		configFiles = append(configFiles, filepath.Join(dir, "git", "config"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		configFiles = append(configFiles, filepath.Join(home, ".gitconfig"))
+	}
+	return configFiles
+}
+
+// xdgConfigHome returns the XDG base directory for configuration files, as
+// Git determines it.
+func xdgConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config")
+	}
+	return ""
+}
+
+// expandHome replaces a leading tilde in the path with the user's home
+// directory.
+func expandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, rest)
+}
+
+// gitConfig holds Git configuration values keyed by their canonical name,
+// e.g. "core.excludesfile" or "remote.origin.url".
+type gitConfig map[string]string
+
+// get returns the value of a configuration variable. Section and key names
+// are case-insensitive, subsection names are case-sensitive.
+func (c gitConfig) get(section, subsection, key string) (string, bool) {
+	v, ok := c[configKey(section, subsection, key)]
+	return v, ok
+}
+
+// configKey returns the canonical name of a configuration variable.
+func configKey(section, subsection, key string) string {
+	if subsection == "" {
+		return strings.ToLower(section) + "." + strings.ToLower(key)
+	}
+	return strings.ToLower(section) + "." + subsection + "." +
+		strings.ToLower(key)
+}
+
+// readFile reads the variables of a Git configuration file, overwriting
+// previously read values. Include directives are not followed.
+func (c gitConfig) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var section, subsection string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// Section header, e.g. [core] or [remote "origin"]
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			header := line[1:end]
+			section, subsection, _ = strings.Cut(header, " ")
+			subsection = strings.TrimSpace(subsection)
+			if unquoted, err := strconv.Unquote(subsection); err == nil {
+				subsection = unquoted
+			}
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !hasValue {
+			// A variable without a value is a boolean true
+			c[configKey(section, subsection, name)] = "true"
+			continue
+		}
+		c[configKey(section, subsection, name)] = parseConfigValue(value)
+	}
+
+	return scanner.Err()
+}
+
+// parseConfigValue strips comments and quotes from a configuration value and
+// resolves escape sequences.
+func parseConfigValue(value string) string {
+	var sb strings.Builder
+	inQuotes := false
+
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == '"':
+			inQuotes = !inQuotes
+		case ch == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(value[i])
+			}
+		case (ch == '#' || ch == ';') && !inQuotes:
+			return strings.TrimSpace(sb.String())
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,42 @@
+package src
+
+func helper1() int { return 1 }
+func helper2() int { return 2 }
+func helper3() int { return 3 }
+func helper4() int { return 4 }
+func helper5() int { return 5 + offset }
+func helper6() int { return 6 }
+func helper7() int { return 7 }
+func helper8() int { return 8 }
+func helper9() int { return 9 }
+func helper10() int { return 10 }
+func helper11() int { return 11 }
+func helper12() int { return 12 }
+func helper13() int { return 13 }
+func helper14() int { return 14 }
+func helper15() int { return 15 }
+func helper16() int { return 16 }
+func helper17() int { return 17 }
+func helper18() int { return 18 }
+func helper19() int { return 19 }
+func helper20() int { return 20 * multiplier }
+func helper21() int { return 21 }
+func helper22() int { return 22 }
+func helper23() int { return 23 }
+func helper24() int { return 24 }
+func helper25() int { return 25 }
+func helper26() int { return 26 }
+func helper27() int { return 27 }
+func helper28() int { return 28 }
+func helper29() int { return 29 }
+func helper30() int { return 30 }
+func helper31() int { return 31 }
+func helper32() int { return 32 }
+func helper33() int { return 33 }
+func helper34() int { return 34 }
+func helper35() int { return 35 }
+func helper36() int { return 36 }
+func helper37() int { return 37 }
+func helper38() int { return 38 }
+func helper39() int { return 39 }
+func helper40() int { return 40 }
@@ -0,0 +1,3 @@
+package src
+
+func renderWidget() {}
@@ -0,0 +1,191 @@
+// Package glob implements slash-separated glob patterns with the semantics
+// of Git's wildmatch, as used by ignore files.
+package glob
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPattern indicates a malformed glob pattern.
+var ErrInvalidPattern = errors.New("invalid glob pattern")
+
+// Pattern is a compiled glob pattern matched against slash-separated
+// relative paths.
+//
+// Like .gitignore patterns, a pattern without a separator matches the last
+// path component at any depth, e.g. "*.go", while others are anchored to the
+// root, e.g. "internal/**/*.go". A trailing separator matches everything
+// inside a directory, e.g. "vendor/".
+type Pattern struct {
+	// re matches the pattern against a slash-separated path.
+	re *regexp.Regexp
+	// basename indicates the pattern is matched against the last path
+	// component only.
+	basename bool
+}
+
+// Compile compiles a glob pattern.
+func Compile(pattern string) (*Pattern, error) {
+	p := &Pattern{}
+
+	glob := pattern
+	dir := strings.HasSuffix(glob, "/")
+	glob = strings.TrimRight(glob, "/")
+	if strings.TrimPrefix(glob, "/") == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+	}
+
+	// Patterns without a separator match at any depth, others are anchored
+	if !strings.Contains(glob, "/") {
+		if dir {
+			glob = "**/" + glob
+		} else {
+			p.basename = true
+		}
+	}
+	glob = strings.TrimPrefix(glob, "/")
+	if dir {
+		glob += "/**"
+	}
+
+	re, err := regexp.Compile("^" + ToRegexp(glob) + "$")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+	}
+	p.re = re
+
+	return p, nil
+}
+
+// Match reports whether the slash-separated relative path matches the pattern.
+func (p *Pattern) Match(relPath string) bool {
+	if p.basename {
+		relPath = relPath[strings.LastIndexByte(relPath, '/')+1:]
+	}
+	return p.re.MatchString(relPath)
+}
+
+// ToRegexp translates a slash-separated glob with Git wildmatch semantics into
+// an unanchored regular expression.
+func ToRegexp(glob string) string {
+	segments := strings.Split(glob, "/")
+	var sb strings.Builder
+
+	for i, segment := range segments {
+		isLast := i == len(segments)-1
+
+		if segment == "**" {
+			if isLast {
+				// Trailing "/**" matches everything inside
+				sb.WriteString(".*")
+			} else {
+				// Leading "**/" and inner "/**/" match zero or more
+				// directories
+				sb.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+
+		sb.WriteString(segmentToRegexp(segment))
+		if !isLast {
+			sb.WriteString("/")
+		}
+	}
+
+	return sb.String()
+}
+
+// segmentToRegexp translates a glob without separators into a regular
+// expression.
+func segmentToRegexp(segment string) string {
+	var sb strings.Builder
+	runes := []rune(segment)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+			}
+		case '*':
+			sb.WriteString("[^/]*")
+			// Consecutive asterisks within a segment act as a single one
+			for i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			class, n, ok := bracketToRegexp(runes[i:])
+			if !ok {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			sb.WriteString(class)
+			i += n - 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return sb.String()
+}
+
+// bracketToRegexp translates the bracket expression at the start of runes
+// into a regular expression character class. It returns the class, the
+// number of runes consumed and whether the expression is terminated.
+func bracketToRegexp(runes []rune) (string, int, bool) {
+	var sb strings.Builder
+	sb.WriteString("[")
+
+	i := 1
+	if i < len(runes) && (runes[i] == '!' || runes[i] == '^') {
+		sb.WriteString("^/")
+		i++
+	}
+
+	// A closing bracket right after the opening one is literal
+	first := i
+	for ; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ']' && i > first:
+			sb.WriteString("]")
+			return sb.String(), i + 1, true
+		case r == '[' && i+1 < len(runes) && runes[i+1] == ':':
+			// Character class such as [:alpha:]
+			end := strings.Index(string(runes[i:]), ":]")
+			if end < 0 {
+				return "", 0, false
+			}
+			class := []rune(string(runes[i:])[:end+2])
+			sb.WriteString(string(class))
+			i += len(class) - 1
+		case r == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteString(escapeClassRune(runes[i]))
+		case r == '\\' || r == '[' || r == ']':
+			sb.WriteString(escapeClassRune(r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return "", 0, false
+}
+
+// escapeClassRune escapes a rune for literal use in a regular expression
+// character class.
+func escapeClassRune(r rune) string {
+	if r < utf8.RuneSelf && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
@@ -0,0 +1,52 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		// basename patterns match at any depth
+		{"*.go", "main.go", true},
+		{"*.go", "internal/domain/domain.go", true},
+		{"*.go", "main.go.txt", false},
+		{"*_test.go", "pkg/git/git_test.go", true},
+		// patterns with a separator are anchored
+		{"internal/*.go", "internal/main.go", true},
+		{"internal/*.go", "internal/domain/domain.go", false},
+		{"/main.go", "main.go", true},
+		{"/main.go", "cmd/main.go", false},
+		{"internal/**/*.go", "internal/domain/domain.go", true},
+		{"internal/**/*.go", "internal/main.go", true},
+		{"**/testdata/**", "pkg/git/testdata/repo/main.go", true},
+		// trailing separators match directory contents
+		{"vendor/", "vendor/lib/lib.go", true},
+		{"vendor/", "third_party/vendor/lib.go", true},
+		{"vendor/", "vendor", false},
+		{"cmd/typomat/", "cmd/typomat/main.go", true},
+		{"cmd/typomat/", "pkg/cmd/typomat/main.go", false},
+		// wildcards and brackets
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file10.txt", false},
+		{"*.[ch]", "src/lib.h", true},
+		{"*.[!ch]", "src/lib.h", false},
+	}
+
+	for _, c := range cases {
+		p, err := Compile(c.pattern)
+		require.NoError(t, err, c.pattern)
+		assert.Equal(t, c.want, p.Match(c.path), "%s ~ %s", c.pattern, c.path)
+	}
+
+	for _, pattern := range []string{"", "/", "//"} {
+		_, err := Compile(pattern)
+		assert.ErrorIs(t, err, ErrInvalidPattern, pattern)
+	}
+}
@@ -0,0 +1,250 @@
+// Package layout models keyboard layouts: which finger types each character
+// and on which row its key sits, assuming touch typing.
+package layout
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrUnknownLayout indicates that no layout of the specified name exists.
+	ErrUnknownLayout = errors.New("unknown keyboard layout")
+	// ErrInvalidLayout indicates that a layout definition is malformed.
+	ErrInvalidLayout = errors.New("invalid keyboard layout definition")
+	// ErrUnknownFinger indicates that a finger name is not recognized.
+	ErrUnknownFinger = errors.New("unknown finger")
+)
+
+// Finger is a finger used for typing.
+type Finger int
+
+const (
+	LeftPinky Finger = iota
+	LeftRing
+	LeftMiddle
+	LeftIndex
+	RightIndex
+	RightMiddle
+	RightRing
+	RightPinky
+	Thumb
+)
+
+// fingerNames are the names of the fingers, in order.
+var fingerNames = []string{
+	"left-pinky", "left-ring", "left-middle", "left-index",
+	"right-index", "right-middle", "right-ring", "right-pinky", "thumb",
+}
+
+// Fingers returns all fingers, from the left pinky to the thumbs.
+func Fingers() []Finger {
+	fingers := make([]Finger, len(fingerNames))
+	for i := range fingers {
+		fingers[i] = Finger(i)
+	}
+	return fingers
+}
+
+// String returns the name of the finger, e.g. "left-ring".
+func (f Finger) String() string {
+	if f < 0 || int(f) >= len(fingerNames) {
+		return "unknown"
+	}
+	return fingerNames[f]
+}
+
+// ParseFinger returns the finger of the specified name, see Finger.String.
+func ParseFinger(name string) (Finger, error) {
+	i := slices.Index(fingerNames, strings.ToLower(name))
+	if i < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownFinger, name)
+	}
+	return Finger(i), nil
+}
+
+// Row is a row of keys.
+type Row int
+
+const (
+	NumberRow Row = iota
+	TopRow
+	HomeRow
+	BottomRow
+	SpaceRow
+)
+
+// rowNames are the names of the rows, in order.
+var rowNames = []string{"number", "top", "home", "bottom", "space"}
+
+// Rows returns all rows, from the number row to the space bar.
+func Rows() []Row {
+	rows := make([]Row, len(rowNames))
+	for i := range rows {
+		rows[i] = Row(i)
+	}
+	return rows
+}
+
+// String returns the name of the row, e.g. "home".
+func (r Row) String() string {
+	if r < 0 || int(r) >= len(rowNames) {
+		return "unknown"
+	}
+	return rowNames[r]
+}
+
+// Key is the position of a character on the keyboard.
+type Key struct {
+	// Char is the character of the key without modifiers.
+	Char rune
+	// Row is the row of the key.
+	Row Row
+	// Column is the position of the key within its row, starting at 0 for
+	// the leftmost key typed by the left pinky.
+	Column int
+	// Finger is the finger typing the key.
+	Finger Finger
+}
+
+// columnFingers assigns the fingers to the columns of the main keyboard
+// rows. Further columns to the right are typed by the right pinky.
+var columnFingers = []Finger{
+	LeftPinky, LeftRing, LeftMiddle, LeftIndex, LeftIndex,
+	RightIndex, RightIndex, RightMiddle, RightRing, RightPinky,
+}
+
+// Layout maps characters to the keys typing them.
+type Layout struct {
+	// Name is the name of the layout, e.g. "dvorak".
+	Name string
+	// Rows are the characters of the keys of each row without modifiers,
+	// from the number row to the bottom row.
+	Rows [][]rune
+	// keys are the keys by lowercase character.
+	keys map[rune]Key
+}
+
+// presets are the definitions of the built-in layouts by name.
+var presets = map[string]string{
+	"qwerty":  "1234567890-=\nqwertyuiop[]\nasdfghjkl;'\nzxcvbnm,./",
+	"dvorak":  "1234567890[]\n',.pyfgcrl/=\naoeuidhtns-\n;qjkxbmwvz",
+	"colemak": "1234567890-=\nqwfpgjluy;[]\narstdhneio'\nzxcvbkm,./",
+	"azerty":  "&é\"'(-è_çà)=\nazertyuiop^$\nqsdfghjklmù*\nwxcvbn,;:!",
+	"qwertz":  "1234567890ß´\nqwertzuiopü+\nasdfghjklöä#\nyxcvbnm,.-",
+}
+
+// Presets returns the names of the built-in layouts, sorted.
+func Presets() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Preset returns the built-in layout of the specified name, see Presets.
+func Preset(name string) (*Layout, error) {
+	def, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLayout, name)
+	}
+	return Parse(strings.ToLower(name), strings.NewReader(def))
+}
+
+// Load returns the built-in layout of the specified name or, if there is
+// none, reads a custom definition from the file at that path, see Parse.
+func Load(nameOrPath string) (*Layout, error) {
+	if l, err := Preset(nameOrPath); err == nil {
+		return l, nil
+	}
+
+	f, err := os.Open(nameOrPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLayout, nameOrPath)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(nameOrPath), filepath.Ext(nameOrPath))
+	return Parse(name, f)
+}
+
+// Parse reads a layout definition: the characters of the keys without
+// modifiers, one row per line, from the number row to the bottom row. The
+// number row may be left out. Each row starts with the key typed by the left
+// pinky; whitespace between keys is ignored, as are empty lines and lines
+// starting with '#'. For example:
+//
+//	# Colemak
+//	1234567890-=
+//	qwfpgjluy;[]
+//	arstdhneio'
+//	zxcvbkm,./
+//
+// The space bar is typed by the thumbs in every layout.
+func Parse(name string, r io.Reader) (*Layout, error) {
+	var rows [][]rune
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var row []rune
+		for _, c := range line {
+			if !unicode.IsSpace(c) {
+				row = append(row, c)
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch len(rows) {
+	case 3:
+		// No number row
+		rows = append([][]rune{nil}, rows...)
+	case 4:
+	default:
+		return nil, fmt.Errorf("%w: expected 3 or 4 rows, got %d",
+			ErrInvalidLayout, len(rows))
+	}
+
+	l := &Layout{Name: name, Rows: rows, keys: map[rune]Key{}}
+	for i, row := range rows {
+		for col, c := range row {
+			c = unicode.ToLower(c)
+			if _, ok := l.keys[c]; ok {
+				return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidLayout, c)
+			}
+			finger := RightPinky
+			if col < len(columnFingers) {
+				finger = columnFingers[col]
+			}
+			l.keys[c] = Key{Char: c, Row: Row(i), Column: col, Finger: finger}
+		}
+	}
+	l.keys[' '] = Key{Char: ' ', Row: SpaceRow, Finger: Thumb}
+
+	return l, nil
+}
+
+// Key returns the key typing the character. Uppercase letters are typed with
+// the key of the lowercase letter. The second return value is false if the
+// layout has no key for the character.
+func (l *Layout) Key(c rune) (Key, bool) {
+	k, ok := l.keys[unicode.ToLower(c)]
+	return k, ok
+}
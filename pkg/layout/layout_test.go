@@ -0,0 +1,103 @@
+package layout
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets() {
+		l, err := Preset(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, l.Name)
+
+		// Every preset covers the English alphabet
+		for c := 'a'; c <= 'z'; c++ {
+			_, ok := l.Key(c)
+			assert.True(t, ok, "%s: %c", name, c)
+		}
+	}
+
+	_, err := Preset("workman")
+	assert.ErrorIs(t, err, ErrUnknownLayout)
+}
+
+func TestKey(t *testing.T) {
+	qwerty, err := Preset("QWERTY")
+	require.NoError(t, err)
+	dvorak, err := Preset("dvorak")
+	require.NoError(t, err)
+	azerty, err := Preset("azerty")
+	require.NoError(t, err)
+
+	cases := []struct {
+		layout *Layout
+		c      rune
+		want   Key
+		ok     bool
+	}{
+		{qwerty, 'a', Key{Char: 'a', Row: HomeRow, Column: 0, Finger: LeftPinky}, true},
+		{qwerty, 'G', Key{Char: 'g', Row: HomeRow, Column: 4, Finger: LeftIndex}, true},
+		{qwerty, 'y', Key{Char: 'y', Row: TopRow, Column: 5, Finger: RightIndex}, true},
+		{qwerty, '=', Key{Char: '=', Row: NumberRow, Column: 11, Finger: RightPinky}, true},
+		{qwerty, ' ', Key{Char: ' ', Row: SpaceRow, Finger: Thumb}, true},
+		{dvorak, 'u', Key{Char: 'u', Row: HomeRow, Column: 3, Finger: LeftIndex}, true},
+		{azerty, 'é', Key{Char: 'é', Row: NumberRow, Column: 1, Finger: LeftRing}, true},
+
+		// shifted characters have no key of their own
+		{qwerty, '!', Key{}, false},
+	}
+
+	for _, c := range cases {
+		k, ok := c.layout.Key(c.c)
+		assert.Equal(t, c.ok, ok, "%s: %c", c.layout.Name, c.c)
+		assert.Equal(t, c.want, k, "%s: %c", c.layout.Name, c.c)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	l, err := Load("testdata/workman.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "workman", l.Name)
+	assert.Empty(t, l.Rows[NumberRow])
+
+	k, ok := l.Key('h')
+	assert.True(t, ok)
+	assert.Equal(t, Key{Char: 'h', Row: HomeRow, Column: 2, Finger: LeftMiddle}, k)
+
+	// Presets take precedence over files
+	l, err = Load("colemak")
+	require.NoError(t, err)
+	assert.Equal(t, "colemak", l.Name)
+
+	_, err = Load("testdata/missing.txt")
+	assert.ErrorIs(t, err, ErrUnknownLayout)
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"abc\ndef",
+		"1\nq\na\nz\nextra",
+		"abc\ndea\nghi",
+	}
+
+	for _, c := range cases {
+		_, err := Parse("invalid", strings.NewReader(c))
+		assert.ErrorIs(t, err, ErrInvalidLayout, c)
+	}
+}
+
+func TestParseFinger(t *testing.T) {
+	for _, f := range Fingers() {
+		parsed, err := ParseFinger(strings.ToUpper(f.String()))
+		require.NoError(t, err)
+		assert.Equal(t, f, parsed)
+	}
+
+	_, err := ParseFinger("left-thumb")
+	assert.ErrorIs(t, err, ErrUnknownFinger)
+}
@@ -0,0 +1,267 @@
+// Package lease provides advisory, cross-process leases backed by lock files.
+//
+// A lease is held by creating its lock file exclusively. The holder refreshes
+// the file's modification time in the background, so a lease abandoned by a
+// crashed process expires after its time-to-live and can be taken over.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pollInterval is the time to wait between attempts to acquire a held lease.
+const pollInterval = 50 * time.Millisecond
+
+// ErrHeld indicates that a lease is held by another holder.
+var ErrHeld = errors.New("lease is held")
+
+// Lease represents a held lease on a lock file.
+type Lease struct {
+	// path is the path to the lock file.
+	path string
+	// owner identifies the holder in the lock file contents.
+	owner string
+	// takeover is the path to the takeover file created to take over an
+	// expired lock file, if any.
+	takeover string
+	// stop signals the heartbeat goroutine to exit.
+	stop chan struct{}
+	// done is closed when the heartbeat goroutine has exited.
+	done chan struct{}
+	// once guards against releasing the lease multiple times.
+	once sync.Once
+}
+
+// Acquire blocks until the lease at the specified path is obtained or the
+// context is cancelled.
+//
+// A lease whose lock file has not been refreshed within ttl is considered
+// abandoned and is taken over.
+func Acquire(ctx context.Context, path string, ttl time.Duration) (*Lease, error) {
+	owner := newOwner()
+
+	for {
+		ok, takeover, err := attempt(path, ttl, owner)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return newLease(path, owner, takeover, ttl), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// TryAcquire obtains the lease at the specified path like Acquire, but
+// returns ErrHeld rather than waiting if it is held.
+func TryAcquire(path string, ttl time.Duration) (*Lease, error) {
+	owner := newOwner()
+
+	ok, takeover, err := attempt(path, ttl, owner)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrHeld
+	}
+	return newLease(path, owner, takeover, ttl), nil
+}
+
+// newOwner returns an identifier of a holder unique across processes.
+func newOwner() string {
+	return fmt.Sprintf("%d@%d", os.Getpid(), time.Now().UnixNano())
+}
+
+// newLease returns the lease held by owner and starts refreshing its lock
+// file.
+func newLease(path, owner, takeover string, ttl time.Duration) *Lease {
+	l := &Lease{
+		path:     path,
+		owner:    owner,
+		takeover: takeover,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go l.heartbeat(ttl / 3)
+	return l
+}
+
+// attempt tries once to create the lock file, taking it over first if it
+// has expired. It reports whether the lease was obtained, along with the path
+// to the takeover file if it was taken over.
+func attempt(
+	path string, ttl time.Duration, owner string,
+) (bool, string, error) {
+	ok, err := tryCreate(path, owner)
+	if err != nil || ok {
+		return ok, "", err
+	}
+
+	// Lease is held, take it over if it has expired
+	takeover, err := takeOverIfStale(path, ttl, owner)
+	if err != nil || takeover == "" {
+		return false, "", err
+	}
+	ok, err = tryCreate(path, owner)
+	if !ok {
+		os.Remove(takeover) // nolint:errcheck
+		return false, "", err
+	}
+	return true, takeover, nil
+}
+
+// Release gives up the lease by removing its lock file. Subsequent calls
+// have no effect.
+//
+// A lock file taken over by another holder after the lease expired is left
+// in place. To tell without racing the new holder, the lock file is first
+// moved aside and put back if it is not this lease's.
+func (l *Lease) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+
+		if l.takeover != "" {
+			os.Remove(l.takeover) // nolint:errcheck
+		}
+
+		aside := l.path + "." + l.owner + ".release"
+		if renameErr := os.Rename(l.path, aside); errors.Is(renameErr, os.ErrNotExist) {
+			return
+		} else if renameErr != nil {
+			err = renameErr
+			return
+		}
+
+		contents, readErr := os.ReadFile(aside)
+		if readErr == nil && string(contents) != l.owner {
+			readErr = restore(aside, l.path)
+		}
+		err = errors.Join(readErr, os.Remove(aside))
+	})
+	return err
+}
+
+// heartbeat refreshes the lock file's modification time at the specified
+// interval until the lease is released. Run as a goroutine.
+func (l *Lease) heartbeat(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			os.Chtimes(l.path, now, now) // nolint:errcheck
+		}
+	}
+}
+
+// tryCreate attempts to exclusively create the lock file, reporting whether
+// it succeeded.
+func tryCreate(path, owner string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, writeErr := f.WriteString(owner)
+	closeErr := f.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		os.Remove(path) // nolint:errcheck
+		return false, err
+	}
+	return true, nil
+}
+
+// takeOverIfStale removes the lock file if it has not been refreshed within
+// ttl. If it did, it returns the path to the takeover file created, which
+// must be removed once the lease taken over is released.
+//
+// Several holders may find the same lock file stale at once. Only the one
+// exclusively creating the takeover file of the lock file's version, told
+// apart by its modification time, removes it. The winner moves the lock file
+// aside rather than removing it outright and puts it back if it is no longer
+// the stale one, e.g. because it was released and acquired in between.
+// Takeover files left behind by crashed holders expire like lock files.
+func takeOverIfStale(
+	path string, ttl time.Duration, owner string,
+) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		// Released in the meantime, retry on the next attempt
+		return "", ignoreNotExist(err)
+	}
+	if time.Since(info.ModTime()) <= ttl {
+		return "", nil
+	}
+
+	removeStaleTakeovers(path, ttl)
+	takeover := fmt.Sprintf("%s.%d.takeover", path, info.ModTime().UnixNano())
+	if ok, err := tryCreate(takeover, owner); err != nil || !ok {
+		return "", err
+	}
+
+	aside := takeover + ".stale"
+	if err := os.Rename(path, aside); err != nil {
+		return "", errors.Join(ignoreNotExist(err), os.Remove(takeover))
+	}
+
+	asideInfo, err := os.Stat(aside)
+	if err != nil || !asideInfo.ModTime().Equal(info.ModTime()) {
+		err = errors.Join(err, restore(aside, path))
+		return "", errors.Join(err, os.Remove(aside), os.Remove(takeover))
+	}
+	return takeover, os.Remove(aside)
+}
+
+// ignoreNotExist returns nil if err indicates that a file does not exist, and
+// err otherwise.
+func ignoreNotExist(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// restore puts a lock file moved aside back in place, unless another lock
+// file took its place.
+func restore(aside, path string) error {
+	err := os.Link(aside, path)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
+	return err
+}
+
+// removeStaleTakeovers removes the takeover files of the lock file that
+// expired, as no holder can still be taking over their versions.
+func removeStaleTakeovers(path string, ttl time.Duration) {
+	takeovers, err := filepath.Glob(path + ".*.takeover")
+	if err != nil {
+		return
+	}
+	for _, takeover := range takeovers {
+		if info, err := os.Stat(takeover); err == nil && time.Since(info.ModTime()) > ttl {
+			os.Remove(takeover) // nolint:errcheck
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package lease
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	l, err := Acquire(context.Background(), path, time.Second)
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+
+	// Held lease blocks until the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = Acquire(ctx, path, time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Released lease can be acquired again
+	assert.NoError(t, l.Release())
+	assert.NoFileExists(t, path)
+	assert.NoError(t, l.Release())
+
+	l, err = Acquire(context.Background(), path, time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Release())
+}
+
+func TestAcquireStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	// Simulate a lock file abandoned by a crashed process
+	assert.NoError(t, os.WriteFile(path, []byte("crashed"), 0o644))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, past, past))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	l, err := Acquire(ctx, path, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Release())
+}
+
+func TestAcquireHeartbeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	ttl := 150 * time.Millisecond
+
+	l, err := Acquire(context.Background(), path, ttl)
+	assert.NoError(t, err)
+	defer l.Release() // nolint:errcheck
+
+	// A refreshed lease must not be taken over once its initial TTL elapses
+	ctx, cancel := context.WithTimeout(context.Background(), 3*ttl)
+	defer cancel()
+	_, err = Acquire(ctx, path, ttl)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAcquireMutualExclusion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	var holders atomic.Int32
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Go(func() {
+			l, err := Acquire(context.Background(), path, time.Second)
+			assert.NoError(t, err)
+
+			assert.Equal(t, int32(1), holders.Add(1))
+			time.Sleep(10 * time.Millisecond)
+			holders.Add(-1)
+
+			assert.NoError(t, l.Release())
+		})
+	}
+
+	wg.Wait()
+}
+
+func TestAcquireStaleMutualExclusion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	// Waiters compete to take over the same abandoned lock file
+	assert.NoError(t, os.WriteFile(path, []byte("crashed"), 0o644))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, past, past))
+
+	var holders atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for range 16 {
+		wg.Go(func() {
+			<-start
+			l, err := Acquire(context.Background(), path, time.Minute)
+			assert.NoError(t, err)
+
+			assert.Equal(t, int32(1), holders.Add(1))
+			time.Sleep(10 * time.Millisecond)
+			holders.Add(-1)
+
+			assert.NoError(t, l.Release())
+		})
+	}
+
+	close(start)
+	wg.Wait()
+	assert.NoFileExists(t, path)
+}
+
+func TestTryAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	l, err := TryAcquire(path, time.Second)
+	assert.NoError(t, err)
+
+	_, err = TryAcquire(path, time.Second)
+	assert.ErrorIs(t, err, ErrHeld)
+	assert.NoError(t, l.Release())
+
+	// Abandoned lease is taken over without waiting
+	assert.NoError(t, os.WriteFile(path, []byte("crashed"), 0o644))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, past, past))
+
+	l, err = TryAcquire(path, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Release())
+}
+
+func TestReleaseTakenOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	l, err := Acquire(context.Background(), path, time.Second)
+	assert.NoError(t, err)
+
+	// Simulate another holder taking over the expired lease
+	assert.NoError(t, os.WriteFile(path, []byte("other"), 0o644))
+
+	assert.NoError(t, l.Release())
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(contents))
+}
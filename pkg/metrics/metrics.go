@@ -45,3 +45,13 @@ func Accuracy(prompt, input string) float64 {
 
 	return float64(numCorrect) / float64(numMeasured) * 100.0
 }
+
+// KeystrokeAccuracy calculates the typing accuracy as a percentage based on
+// the number of key presses and how many of them were mistakes.
+func KeystrokeAccuracy(presses, mistakes int) float64 {
+	if presses == 0 {
+		return 100.0
+	}
+
+	return float64(presses-mistakes) / float64(presses) * 100.0
+}
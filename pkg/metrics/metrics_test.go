@@ -56,3 +56,25 @@ func TestAccuracy(t *testing.T) {
 		assert.Equal(t, c.want, got)
 	}
 }
+
+func TestKeystrokeAccuracy(t *testing.T) {
+	cases := []struct {
+		presses  int
+		mistakes int
+		want     float64
+	}{
+		// no key presses
+		{0, 0, 100.0},
+		// no mistakes
+		{10, 0, 100.0},
+		// one mistake in four presses
+		{4, 1, 75.0},
+		// all mistakes
+		{3, 3, 0.0},
+	}
+
+	for _, c := range cases {
+		got := KeystrokeAccuracy(c.presses, c.mistakes)
+		assert.Equal(t, c.want, got)
+	}
+}
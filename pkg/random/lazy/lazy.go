@@ -4,11 +4,14 @@ package lazy
 import (
 	"iter"
 	"math/rand/v2"
+
+	"github.com/vupdivup/typomat/pkg/random"
 )
 
 // Sample returns k random elements from the provided iterable sequence using
-// reservoir sampling.
-func Sample[T any](iter iter.Seq[T], k int) []T {
+// reservoir sampling. Randomness is drawn from r, or from the global source if
+// r is nil. See package random.
+func Sample[T any](iter iter.Seq[T], k int, r *rand.Rand) []T {
 	sample := make([]T, 0, k)
 	i := 0
 
@@ -16,7 +19,7 @@ func Sample[T any](iter iter.Seq[T], k int) []T {
 		if i < k {
 			sample = append(sample, item)
 		} else {
-			j := rand.IntN(i + 1)
+			j := random.IntN(r, i+1)
 			if j < k {
 				sample[j] = item
 			}
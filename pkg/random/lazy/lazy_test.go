@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vupdivup/typomat/pkg/random"
 )
 
 func TestSample(t *testing.T) {
@@ -18,7 +19,7 @@ func TestSample(t *testing.T) {
 	}
 
 	for range loops {
-		sampled := Sample(slices.Values(pop), n)
+		sampled := Sample(slices.Values(pop), n, nil)
 		assert.Len(t, sampled, n)
 		for _, item := range sampled {
 			freqs[item]++
@@ -41,10 +42,18 @@ func TestSample(t *testing.T) {
 
 	// Empty population
 	empty := []int{}
-	sampledEmpty := Sample(slices.Values(empty), 0)
+	sampledEmpty := Sample(slices.Values(empty), 0, nil)
 	assert.Empty(t, sampledEmpty)
 
 	// Sample size larger than population
-	sampledLarge := Sample(slices.Values(pop), len(pop)+5)
+	sampledLarge := Sample(slices.Values(pop), len(pop)+5, nil)
 	assert.Len(t, sampledLarge, len(pop))
 }
+
+func TestSampleSeeded(t *testing.T) {
+	pop := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	a := Sample(slices.Values(pop), 4, random.New(7))
+	b := Sample(slices.Values(pop), 4, random.New(7))
+	assert.Equal(t, a, b)
+}
@@ -1,4 +1,8 @@
 // Package random provides utilities for randomization tasks.
+//
+// Functions take an optional random source so that results can be
+// reproduced by seeding it. A nil source uses the global, randomly seeded
+// source of math/rand/v2.
 package random
 
 import (
@@ -7,14 +11,45 @@ import (
 
 // Shuffle returns a new slice with the elements of the input slice shuffled
 // randomly using the Fisher-Yates algorithm.
-func Shuffle[T any](slice []T) []T {
+func Shuffle[T any](slice []T, r *rand.Rand) []T {
 	s := make([]T, len(slice))
 	copy(s, slice)
 
 	for i := len(s) - 1; i > 0; i-- {
-		j := rand.IntN(i + 1)
+		j := IntN(r, i+1)
 		s[i], s[j] = s[j], s[i]
 	}
 
 	return s
 }
+
+// IntN returns a random integer in [0, n) from the source, or from the global
+// source if r is nil. It panics if n <= 0.
+func IntN(r *rand.Rand, n int) int {
+	if r == nil {
+		return rand.IntN(n)
+	}
+	return r.IntN(n)
+}
+
+// Float64 returns a random float in [0.0, 1.0) from the source, or from the
+// global source if r is nil.
+func Float64(r *rand.Rand) float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	return r.Float64()
+}
+
+// New returns a random source seeded with the specified seed. Sources with
+// equal seeds produce equal sequences.
+func New(seed uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// NewStream returns a random source seeded with the specified seed that
+// produces a sequence of its own for each stream number. Sources with equal
+// seeds and stream numbers produce equal sequences.
+func NewStream(seed, stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, stream))
+}
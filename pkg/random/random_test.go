@@ -19,7 +19,7 @@ func TestShuffle(t *testing.T) {
 	}
 
 	for range loops {
-		shuffled := Shuffle(original)
+		shuffled := Shuffle(original, nil)
 		for i, item := range shuffled {
 			freqs[item][i]++
 		}
@@ -43,6 +43,31 @@ func TestShuffle(t *testing.T) {
 
 	// Empty slice
 	empty := []int{}
-	shuffledEmpty := Shuffle(empty)
+	shuffledEmpty := Shuffle(empty, nil)
 	assert.Empty(t, shuffledEmpty)
 }
+
+func TestShuffleSeeded(t *testing.T) {
+	original := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	// Equal seeds produce equal permutations
+	a := Shuffle(original, New(42))
+	b := Shuffle(original, New(42))
+	assert.Equal(t, a, b)
+	assert.ElementsMatch(t, original, a)
+
+	// A source advances between calls
+	r := New(42)
+	first := Shuffle(original, r)
+	assert.Equal(t, a, first)
+	assert.NotEqual(t, first, Shuffle(original, r))
+}
+
+func TestNewStream(t *testing.T) {
+	original := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	// Streams of a seed are reproducible and differ from each other
+	a := Shuffle(original, NewStream(42, 1))
+	assert.Equal(t, a, Shuffle(original, NewStream(42, 1)))
+	assert.NotEqual(t, a, Shuffle(original, NewStream(42, 2)))
+}
@@ -3,15 +3,24 @@ package tokenizer
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/vupdivup/typomat/pkg/alphabet"
+	"golang.org/x/text/unicode/norm"
 )
 
+// ErrInvalidUTF8 indicates that a file contains bytes that are not valid
+// UTF-8.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8")
+
 type Case int
 
 const (
@@ -75,26 +84,25 @@ func splitMixedCaseToken(word []rune) [][]rune {
 	return result
 }
 
-// isTokenValid checks if all runes in the token are part of the allowed
-// character set.
-func isTokenValid(token []rune) bool {
+// isTokenValid checks if all runes in the token are letters of the alphabet.
+func isTokenValid(token []rune, letters alphabet.Alphabet) bool {
 	for _, r := range token {
-		if !slices.Contains(alphabet.AllRunes, r) {
+		if !letters.Contains(r) {
 			return false
 		}
 	}
 	return true
 }
 
-func tokenizeWord(word []rune) []string {
+func tokenizeWord(word []rune, letters alphabet.Alphabet) []string {
 	var tokens []string
 	var currentToken []rune
 
 	flush := func() {
-		if len(currentToken) > 0 && isTokenValid(currentToken) {
+		if len(currentToken) > 0 && isTokenValid(currentToken, letters) {
 			subtokens := splitMixedCaseToken(currentToken)
 			for _, subtoken := range subtokens {
-				if isTokenValid(subtoken) {
+				if isTokenValid(subtoken, letters) {
 					tokens = append(tokens, strings.ToLower(string(subtoken)))
 				}
 			}
@@ -118,11 +126,15 @@ func tokenizeWord(word []rune) []string {
 
 // TokenizeString splits an input string into word tokens.
 // It can handle natural language text as well as source code.
-// Tokens containing non-ASCII characters are filtered out.
+// Tokens containing runes other than the letters of the alphabet are filtered
+// out. The input is normalized to NFC first, so letters written with
+// combining characters count as the precomposed letters.
 //
 // An optional filter function can be provided to include/exclude specific words
 // before tokenization. It should return true to include the word.
-func TokenizeString(s string, wordFilter func(string) bool) []string {
+func TokenizeString(
+	s string, letters alphabet.Alphabet, wordFilter func(string) bool,
+) []string {
 	var tokens []string
 	var currentWord []rune
 
@@ -133,12 +145,12 @@ func TokenizeString(s string, wordFilter func(string) bool) []string {
 	flush := func() {
 		// Check word filter
 		if wordFilter(string(currentWord)) {
-			tokens = append(tokens, tokenizeWord(currentWord)...)
+			tokens = append(tokens, tokenizeWord(currentWord, letters)...)
 		}
 		currentWord = []rune{}
 	}
 
-	for _, r := range s {
+	for _, r := range norm.NFC.String(s) {
 		// Check for token boundaries
 		if slices.Contains(wordDelimiters, r) {
 			flush()
@@ -155,7 +167,12 @@ func TokenizeString(s string, wordFilter func(string) bool) []string {
 // TokenizeFile reads a file and returns its tokens. File contents are read
 // line by line to handle large files efficiently.
 // See TokenizeString for tokenization details.
-func TokenizeFile(path string, wordFilter func(string) bool) ([]string, error) {
+//
+// Lines that are not valid UTF-8 are skipped. ErrInvalidUTF8 is then returned
+// along with the tokens of the other lines, naming the skipped lines.
+func TokenizeFile(
+	path string, letters alphabet.Alphabet, wordFilter func(string) bool,
+) ([]string, error) {
 	tokens := []string{}
 
 	// Open the file for reading
@@ -165,9 +182,16 @@ func TokenizeFile(path string, wordFilter func(string) bool) ([]string, error) {
 	}
 	defer file.Close()
 
+	lineNum := 0
+	var invalidLines []int
 	flush := func(line string) {
+		lineNum++
+		if !utf8.ValidString(line) {
+			invalidLines = append(invalidLines, lineNum)
+			return
+		}
 		if len(line) > 0 {
-			lineTokens := TokenizeString(line, wordFilter)
+			lineTokens := TokenizeString(line, letters, wordFilter)
 			tokens = append(tokens, lineTokens...)
 		}
 	}
@@ -186,5 +210,29 @@ func TokenizeFile(path string, wordFilter func(string) bool) ([]string, error) {
 		flush(line)
 	}
 
+	if len(invalidLines) > 0 {
+		return tokens, invalidUTF8Error(invalidLines)
+	}
 	return tokens, nil
 }
+
+// maxReportedLines is the number of invalid lines named by the error of
+// TokenizeFile, keeping it short for files in legacy encodings.
+const maxReportedLines = 5
+
+// invalidUTF8Error returns ErrInvalidUTF8 naming the specified lines.
+func invalidUTF8Error(lines []int) error {
+	nums := make([]string, 0, maxReportedLines)
+	for _, line := range lines[:min(len(lines), maxReportedLines)] {
+		nums = append(nums, strconv.Itoa(line))
+	}
+
+	desc := "line " + nums[0]
+	if len(lines) > 1 {
+		desc = "lines " + strings.Join(nums, ", ")
+	}
+	if len(lines) > maxReportedLines {
+		desc += fmt.Sprintf(" and %d more", len(lines)-maxReportedLines)
+	}
+	return fmt.Errorf("%w on %s", ErrInvalidUTF8, desc)
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vupdivup/typomat/pkg/alphabet"
 )
 
 func TestTokenizeString(t *testing.T) {
@@ -31,7 +32,7 @@ func TestTokenizeString(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		got := TokenizeString(c.input, nil)
+		got := TokenizeString(c.input, alphabet.English, nil)
 		assert.ElementsMatch(t, got, c.want)
 	}
 }
@@ -58,7 +59,7 @@ func TestTokenizeFile(t *testing.T) {
 
 	// Test with relative paths
 	for _, c := range cases {
-		got, err := TokenizeFile(c.file, nil)
+		got, err := TokenizeFile(c.file, alphabet.English, nil)
 		assert.NoError(t, err)
 		assert.ElementsMatch(t, got, c.want)
 	}
@@ -67,8 +68,60 @@ func TestTokenizeFile(t *testing.T) {
 	for _, c := range cases {
 		abs, err := filepath.Abs(c.file)
 		assert.NoError(t, err)
-		got, err := TokenizeFile(abs, nil)
+		got, err := TokenizeFile(abs, alphabet.English, nil)
 		assert.NoError(t, err)
 		assert.ElementsMatch(t, got, c.want)
 	}
 }
+
+func TestTokenizeFileInvalidUTF8(t *testing.T) {
+	// Invalid lines are skipped, keeping the tokens of the valid ones
+	got, err := TokenizeFile("testdata/invalid_utf8.txt", alphabet.English, nil)
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+	assert.EqualError(t, err, "invalid UTF-8 on line 2")
+	assert.Equal(t, []string{"hello", "world", "more", "words"}, got)
+}
+
+func TestInvalidUTF8Error(t *testing.T) {
+	tests := []struct {
+		lines []int
+		want  string
+	}{
+		{[]int{3}, "invalid UTF-8 on line 3"},
+		{[]int{3, 7}, "invalid UTF-8 on lines 3, 7"},
+		{[]int{1, 2, 3, 4, 5, 6, 7}, "invalid UTF-8 on lines 1, 2, 3, 4, 5 and 2 more"},
+	}
+
+	for _, tt := range tests {
+		err := invalidUTF8Error(tt.lines)
+		assert.ErrorIs(t, err, ErrInvalidUTF8)
+		assert.EqualError(t, err, tt.want)
+	}
+}
+
+func TestTokenizeStringAlphabet(t *testing.T) {
+	de, _ := alphabet.Preset("de")
+	hu, _ := alphabet.Preset("hu")
+
+	cases := []struct {
+		input   string
+		letters alphabet.Alphabet
+		want    []string
+	}{
+		// non-English letters are dropped by default
+		{"// Größe der Übergabe", alphabet.English, []string{"der"}},
+		// and kept with the language's alphabet, lowercased
+		{"// Größe der Übergabe", de, []string{"größe", "der", "übergabe"}},
+		// mixed case splitting works across letters
+		{"ÜberGröße", de, []string{"über", "größe"}},
+		// combining characters are composed
+		{"tükör", hu, []string{"tükör"}},
+		// letters of other languages are still dropped
+		{"szőlő żółw", hu, []string{"szőlő"}},
+	}
+
+	for _, c := range cases {
+		got := TokenizeString(c.input, c.letters, nil)
+		assert.Equal(t, c.want, got, c.input)
+	}
+}
@@ -0,0 +1,204 @@
+// Package typomat lets other Go programs build typomat's practice corpus from
+// source code and generate typing prompts from it, as the typomat command
+// does.
+//
+// An Engine is created with New, set up with the sources to practice on and
+// then asked for prompts:
+//
+//	engine, err := typomat.New()
+//	if err != nil {
+//		return err
+//	}
+//	defer engine.Close()
+//
+//	if err := engine.Setup([]string{"path/to/repo"}, typomat.Options{},
+//		typomat.DefaultPromptLen); err != nil {
+//		return err
+//	}
+//	round, err := engine.Prompt()
+//
+// Data is stored in the typomat directory of the user's cache directory,
+// shared with the typomat command.
+package typomat
+
+import (
+	"sync"
+
+	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/domain"
+)
+
+type (
+	// Options configures the sources of practice text.
+	Options = domain.Options
+	// Filter restricts the files of a source directory that feed the corpus.
+	Filter = domain.Filter
+	// HistoryOptions selects the changes of a directory's Git history to
+	// practice on.
+	HistoryOptions = domain.HistoryOptions
+	// PromptOptions configures generated prompts, see Engine.Reconfigure.
+	PromptOptions = domain.PromptOptions
+	// Round is a prompt to be typed, along with what is needed to reproduce
+	// it.
+	Round = domain.Round
+	// IndexSummary describes the changes made to a cache by Engine.Index.
+	IndexSummary = domain.IndexSummary
+	// ProgressEvent describes the progress of source processing, as
+	// delivered by Engine.Subscribe.
+	ProgressEvent = domain.ProgressEvent
+	// Phase is a stage of processing a source.
+	Phase = domain.Phase
+	// ErrorOptions controls how files that fail to process are handled.
+	ErrorOptions = domain.ErrorOptions
+	// FileFailure records a file that failed to process, or that was
+	// processed only in part.
+	FileFailure = domain.FileFailure
+	// Drill biases prompts toward words typed with specific fingers of a
+	// keyboard layout, see the layout package.
+	Drill = domain.Drill
+	// WordResult is how a word of a prompt was typed, see
+	// Engine.RecordWords.
+	WordResult = domain.WordResult
+	// Difficulty is a level of difficulty of prompts, see PromptOptions.
+	Difficulty = domain.Difficulty
+	// Mode is how strictly mistakes are handled while typing a prompt, see
+	// Options.
+	Mode = domain.Mode
+)
+
+const (
+	// PhaseListing is the listing of the files of a source.
+	PhaseListing = domain.PhaseListing
+	// PhaseTokenizing is the tokenization of files.
+	PhaseTokenizing = domain.PhaseTokenizing
+	// PhaseFlushing is the storing of the remaining tokens.
+	PhaseFlushing = domain.PhaseFlushing
+	// PhaseCleaningUp is the removal of files that no longer exist.
+	PhaseCleaningUp = domain.PhaseCleaningUp
+	// PhaseDone indicates that all sources are processed.
+	PhaseDone = domain.PhaseDone
+)
+
+const (
+	// DifficultyEasy uses short, common words.
+	DifficultyEasy = domain.DifficultyEasy
+	// DifficultyNormal uses any words in lowercase. It is the default.
+	DifficultyNormal = domain.DifficultyNormal
+	// DifficultyHard uses longer, rarer words, some capitalized or
+	// punctuated.
+	DifficultyHard = domain.DifficultyHard
+	// DifficultyExpert uses long, rare words, often capitalized, joined into
+	// camel case identifiers or surrounded by symbols of code.
+	DifficultyExpert = domain.DifficultyExpert
+)
+
+const (
+	// ModeNormal marks mistakes and moves on. It is the default.
+	ModeNormal = domain.ModeNormal
+	// ModeStopOnLetter does not accept a wrong key, so the cursor only
+	// advances once the right one is pressed.
+	ModeStopOnLetter = domain.ModeStopOnLetter
+	// ModeStopOnWord makes a word typed with a mistake be typed again from
+	// its start.
+	ModeStopOnWord = domain.ModeStopOnWord
+	// ModeSuddenDeath fails the round on the first mistake.
+	ModeSuddenDeath = domain.ModeSuddenDeath
+)
+
+const (
+	// DefaultPromptLen is the default maximum length of a prompt in
+	// characters.
+	DefaultPromptLen = domain.DefaultPromptLen
+	// StdinPath is the source path denoting standard input.
+	StdinPath = domain.StdinPath
+)
+
+var (
+	// ErrNoTokensFound indicates that the sources contain no eligible words.
+	ErrNoTokensFound = domain.ErrNoTokensFound
+	// ErrClosed indicates that an engine was closed.
+	ErrClosed = domain.ErrClosed
+	// ErrTooManyErrors indicates that processing was aborted because too
+	// many files failed, see ErrorOptions.
+	ErrTooManyErrors = domain.ErrTooManyErrors
+)
+
+var (
+	// initOnce guards the one-time initialization of the log file.
+	initOnce sync.Once
+	// initErr is the result of the initialization.
+	initErr error
+)
+
+// Engine builds a corpus of practice text from sources and generates prompts
+// from it.
+type Engine struct {
+	// engine is the engine of the typomat command doing the work.
+	engine *domain.Engine
+}
+
+// New returns a new engine without sources. It sets up typomat's data
+// directories, recreating them if removed, and on first use its log file.
+func New() (*Engine, error) {
+	initOnce.Do(func() {
+		initErr = config.Init()
+	})
+	if initErr != nil {
+		return nil, initErr
+	}
+
+	// The cache directory may have been removed or changed since
+	if err := config.SetupDirs(); err != nil {
+		return nil, err
+	}
+	return &Engine{engine: domain.NewEngine()}, nil
+}
+
+// Setup processes the specified sources and starts generating prompts of the
+// specified maximum length with the specified options. Sources are
+// directories, single text files or StdinPath for standard input. Setup
+// should be called once per engine.
+func (e *Engine) Setup(paths []string, opts Options, maxLen int) error {
+	return e.engine.Setup(paths, opts, maxLen)
+}
+
+// Prompt returns the next prompt generated from the sources, following the
+// current prompt options, see Reconfigure. ErrClosed is returned once the
+// engine is closed.
+func (e *Engine) Prompt() (Round, error) {
+	return e.engine.Prompt()
+}
+
+// Subscribe returns a channel delivering the progress events of the engine,
+// starting with the current state. A subscriber that falls behind misses
+// intermediate events, but always receives the latest one. The channel is
+// closed when the engine is closed.
+func (e *Engine) Subscribe() <-chan ProgressEvent {
+	return e.engine.Subscribe()
+}
+
+// Reconfigure changes the options of prompts generated from now on. Rounds
+// returned by Prompt after Reconfigure returns follow the new options.
+func (e *Engine) Reconfigure(opts PromptOptions) error {
+	return e.engine.Reconfigure(opts)
+}
+
+// RecordWords schedules the mistyped words of a round for review and grades
+// the words put into its prompt for review, see Options.Review.
+func (e *Engine) RecordWords(round Round, words []WordResult) error {
+	return e.engine.RecordWords(round, words)
+}
+
+// Index builds or refreshes the cache of the specified directory with files
+// passing the filter, and returns a summary of the changes. Its progress is
+// delivered to subscribers, see Subscribe.
+func (e *Engine) Index(
+	dirPath string, filter Filter, errOpts ErrorOptions,
+) (IndexSummary, error) {
+	return e.engine.Index(dirPath, filter, errOpts)
+}
+
+// Close stops generating prompts and releases the resources of the engine.
+func (e *Engine) Close() error {
+	return e.engine.Close()
+}
@@ -0,0 +1,100 @@
+package typomat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests runs the tests with the user cache directory pointing to a
+// temporary directory and returns the exit code.
+func runTests(m *testing.M) int {
+	home, err := os.MkdirTemp("", "typomat-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(home)
+	for _, key := range []string{"XDG_CACHE_HOME", "HOME", "LocalAppData"} {
+		os.Setenv(key, home)
+	}
+	return m.Run()
+}
+
+func TestEngines(t *testing.T) {
+	// Engines work on their own sources side by side
+	words := []string{"alpha bravo charlie", "delta echo foxtrot"}
+	engines := make([]*Engine, len(words))
+	for i, content := range words {
+		dirPath := t.TempDir()
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dirPath, "words.txt"), []byte(content), 0o644))
+
+		engine, err := New()
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, engine.Close()) })
+		require.NoError(t, engine.Setup([]string{dirPath}, Options{}, DefaultPromptLen))
+		engines[i] = engine
+	}
+
+	for i, engine := range engines {
+		round, err := engine.Prompt()
+		require.NoError(t, err)
+		assert.ElementsMatch(t,
+			strings.Fields(words[i]), strings.Fields(round.Prompt))
+		assert.Equal(t, 1, round.Number)
+	}
+
+	// Caches built by Index are used by sessions
+	dirPath := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dirPath, "words.txt"), []byte("golf hotel india"), 0o644))
+	engine, err := New()
+	require.NoError(t, err)
+	events := engine.Subscribe()
+
+	summary, err := engine.Index(dirPath, Filter{}, ErrorOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.New)
+
+	require.NoError(t, engine.Setup([]string{dirPath}, Options{Cache: true}, DefaultPromptLen))
+	require.NoError(t, engine.Reconfigure(PromptOptions{MaxLen: 5}))
+	round, err := engine.Prompt()
+	require.NoError(t, err)
+	assert.Contains(t, []string{"golf", "hotel", "india"}, round.Prompt)
+	require.NoError(t, engine.RecordWords(round, []WordResult{{Token: round.Prompt}}))
+
+	require.NoError(t, engine.Close())
+	var last ProgressEvent
+	for event := range events {
+		last = event
+	}
+	assert.Equal(t, PhaseDone, last.Phase)
+}
+
+func TestNewRemovedCache(t *testing.T) {
+	cacheDir, err := os.UserCacheDir()
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(filepath.Join(cacheDir, "typomat")))
+
+	// Data directories are recreated for new engines
+	dirPath := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dirPath, "words.txt"), []byte("juliett"), 0o644))
+	engine, err := New()
+	require.NoError(t, err)
+	defer engine.Close()
+	require.NoError(t, engine.Setup([]string{dirPath}, Options{}, DefaultPromptLen))
+	round, err := engine.Prompt()
+	require.NoError(t, err)
+	assert.Equal(t, "juliett", round.Prompt)
+}
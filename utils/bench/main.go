@@ -4,6 +4,7 @@ import (
 	"os"
 
 	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/data"
 	"github.com/vupdivup/typomat/internal/domain"
 )
 
@@ -16,7 +17,12 @@ func main() {
 	}
 
 	dirPath := os.Args[1]
-	if err := domain.ProcessDirectory(dirPath); err != nil {
+	store, err := data.Open(dirPath, false)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close() // nolint:errcheck
+	if err := domain.NewEngine().ProcessDirectory(store, dirPath, domain.Filter{}, domain.ErrorOptions{}); err != nil {
 		panic(err)
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"runtime/pprof"
 
 	"github.com/vupdivup/typomat/internal/config"
+	"github.com/vupdivup/typomat/internal/data"
 	"github.com/vupdivup/typomat/internal/domain"
 )
 
@@ -26,7 +27,12 @@ func main() {
 		panic(err)
 	}
 	dirPath := os.Args[1]
-	if err := domain.ProcessDirectory(dirPath); err != nil {
+	store, err := data.Open(dirPath, false)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close() // nolint:errcheck
+	if err := domain.NewEngine().ProcessDirectory(store, dirPath, domain.Filter{}, domain.ErrorOptions{}); err != nil {
 		panic(err)
 	}
 	pprof.StopCPUProfile()